@@ -2,67 +2,450 @@ package main
 
 import (
 	"context"
-	"log"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
 	"syscall"
 	"time"
 
 	"simplesurance/internal/application"
+	"simplesurance/internal/certs"
+	"simplesurance/internal/clock"
 	"simplesurance/internal/config"
+	"simplesurance/internal/domain"
 	"simplesurance/internal/infrastructure/persistence"
 	"simplesurance/internal/infrastructure/repository"
+	"simplesurance/internal/logging"
+	"simplesurance/internal/metrics"
 	preshttp "simplesurance/internal/presentation/http"
+	"simplesurance/internal/tracing"
 )
 
 func main() {
-	cfg, err := config.Load()
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 	if err != nil {
-		log.Fatalf("failed to load configuration: %v", err)
+		// One error per line reads better than a log attribute.
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		os.Exit(2)
 	}
-	logger := log.New(os.Stdout, "[SERVER] ", log.LstdFlags|log.Lshortfile)
-	persister := persistence.NewFilePersistence()
-	memoryStore := repository.NewMemoryStore(cfg.Filename, persister)
-	timestampService := application.NewTimestampService(memoryStore, cfg.Threshold)
-	timestampHandler := preshttp.NewTimestampHandler(timestampService, logger)
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	// The level is a LevelVar so reloads can change it.
+	logLevel := new(slog.LevelVar)
+	level, err := logging.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		fatal(slog.Default(), "failed to create logger", err)
+	}
+	logLevel.Set(level)
+	logger, err := logging.New(os.Stdout, cfg.LogFormat, logLevel)
+	if err != nil {
+		fatal(slog.Default(), "failed to create logger", err)
+	}
+	// Code without a request context, such as background compaction, logs
+	// through the default logger.
+	slog.SetDefault(logger)
+	clk := clock.System{}
+	tracer, err := newTracer(cfg)
+	if err != nil {
+		fatal(logger, "failed to create tracer", err)
+	}
+	persister := newPersister(cfg)
+	metricsRegistry := metrics.NewRegistry()
+	metrics.RegisterRuntime(metricsRegistry)
+	storeMetrics := repository.NewMetrics(metricsRegistry)
+	httpMetrics := preshttp.NewMetrics(metricsRegistry)
+	health := application.NewHealth(application.WithMaxSyncFailures(cfg.MaxSyncFailures))
 
-	if err := timestampService.Initialize(ctx); err != nil {
-		logger.Fatalf("failed to initialize service: %v", err)
+	counterMetrics := application.NewMetrics(metricsRegistry)
+	counter, err := application.NewCounter("", application.CounterSpec{
+		Strategy:  application.Strategy(cfg.Strategy),
+		Threshold: cfg.Threshold,
+	}, newStorage(cfg, persister, clk, storeMetrics, health, false), serviceOptions(cfg, cfg, clk, counterMetrics)...)
+	if err != nil {
+		fatal(logger, "failed to create counter", err)
+	}
+	health.AddLivenessCheck("counter", application.CounterResponds(counter))
+	healthHandler := preshttp.NewHealthHandler(health)
+	timestampHandler := preshttp.NewTimestampHandler(counter)
+	var keyRegistry *application.CounterRegistry
+	var keySwitch *preshttp.KeySwitch
+	if cfg.KeyBy != "" {
+		// Per-client counters are kept in memory only, so idle ones can be
+		// evicted without leaving files behind. They are left out of the
+		// per-counter metrics, which would get a series per client.
+		keyRegistry = application.NewCounterRegistry(newStorage(cfg, nil, clk, nil, nil, true),
+			defaultSpec(cfg, cfg), keyRegistryOptions(cfg, cfg, clk)...)
+		metricsRegistry.NewGaugeFunc("counter_client_keys", "Clients with an open counter.", nil, func(emit func(float64, ...string)) {
+			emit(float64(keyRegistry.Len()))
+		})
+		keySwitch = preshttp.NewKeySwitch(newKeyFunc(cfg))
+		timestampHandler = preshttp.NewKeyedTimestampHandler(keyRegistry, keySwitch.Key)
 	}
+
+	registry := application.NewCounterRegistry(newStorage(cfg, persister, clk, storeMetrics, health, true),
+		defaultSpec(cfg, cfg), registryOptions(cfg, cfg, clk, counterMetrics)...)
+	counterHandler := preshttp.NewCounterHandler(registry)
+	metricsRegistry.NewGaugeFunc("counter_window_count", "Hits currently in a counter's window; the main counter has an empty name.",
+		[]string{"counter"}, func(emit func(float64, ...string)) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			counters := registry.Counters()
+			counters[""] = counter
+			names := make([]string, 0, len(counters))
+			for name := range counters {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				if count, err := counters[name].Peek(ctx); err == nil {
+					emit(float64(count), name)
+				}
+			}
+		})
+	metricsHandler := preshttp.NewMetricsHandler(metricsRegistry)
+	// The per-client counters are left to evictIdleKeys: they have no files
+	// to sync and expire whenever they are read.
+	worker := application.NewWorker(cfg.WorkerInterval,
+		application.WithWorkerClock(clk), application.WithWorkerMetrics(counterMetrics))
+	worker.Watch("", counter)
+	worker.WatchRegistry(registry)
+	health.AddLivenessCheck("worker", worker.Check)
+	var certReloader *certs.Reloader
+	if cfg.TLSCertFile != "" {
+		certReloader, err = certs.NewReloader(cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSClientCAFile, certs.WithClock(clk))
+		if err != nil {
+			fatal(logger, "failed to load TLS certificates", err)
+		}
+	}
+	reloader := &reloader{
+		args:           os.Args[1:],
+		started:        cfg,
+		current:        cfg,
+		clock:          clk,
+		logLevel:       logLevel,
+		counterMetrics: counterMetrics,
+		counter:        counter,
+		registry:       registry,
+		keyRegistry:    keyRegistry,
+		keySwitch:      keySwitch,
+		certs:          certReloader,
+	}
+	adminHandler := preshttp.NewAdminHandler(reloader.reload)
+
 	mux := http.NewServeMux()
-	mux.HandleFunc(cfg.Route, timestampHandler.HandleTimestamp)
-	mux.HandleFunc("/health", timestampHandler.HandleHealth)
+	handle := func(pattern string, handler http.HandlerFunc) {
+		mux.HandleFunc(pattern, httpMetrics.Instrument(pattern, preshttp.NameSpan(pattern, handler)))
+	}
+	handle(cfg.Route, healthHandler.WhenStarted(timestampHandler.HandleTimestamp))
+	handle("/count", healthHandler.WhenStarted(timestampHandler.HandleCount))
+	handle(preshttp.CountersPrefix, healthHandler.WhenStarted(counterHandler.HandleCounter))
+	handle("/health", timestampHandler.HandleHealth)
+	handle("/livez", healthHandler.HandleLivez)
+	handle("/readyz", healthHandler.HandleReadyz)
+	handle("/metrics", metricsHandler.HandleMetrics)
+
+	// The admin endpoint is kept off the public listener, on a plaintext
+	// one that only listens on loopback by default. It serves the probes
+	// too, so local health checks need neither certificates nor HTTPS.
+	adminMux := http.NewServeMux()
+	handleAdmin := func(pattern string, handler http.HandlerFunc) {
+		adminMux.HandleFunc(pattern, httpMetrics.Instrument(pattern, preshttp.NameSpan(pattern, handler)))
+	}
+	handleAdmin("/admin/reload", adminHandler.HandleReload)
+	handleAdmin("/livez", healthHandler.HandleLivez)
+	handleAdmin("/readyz", healthHandler.HandleReadyz)
+	adminServer := &http.Server{
+		Addr:         cfg.AdminAddr,
+		Handler:      preshttp.Trace(tracer)(preshttp.RequestLogger(logger)(adminMux)),
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: config.WriteTimeout,
+	}
 
 	server := &http.Server{
 		Addr:         cfg.ServerAddr(),
-		Handler:      mux,
+		Handler:      preshttp.Trace(tracer)(preshttp.RequestLogger(logger)(mux)),
 		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		WriteTimeout: config.WriteTimeout,
 		IdleTimeout:  120 * time.Second,
 	}
+	watchCtx, stopWatching := context.WithCancel(logging.WithLogger(context.Background(), logger))
+	defer stopWatching()
+	scheme := "http"
+	if certReloader != nil {
+		// Only the admin listener, on ADMIN_ADDR, stays plaintext.
+		server.TLSConfig = certReloader.TLSConfig(cfg.TLSMinVersion)
+		go certReloader.Watch(watchCtx)
+		scheme = "https"
+	} else {
+		logger.Warn("serving plain HTTP; set TLS_CERT_FILE, or REQUIRE_TLS to refuse to start without it")
+	}
+	// The server starts before the counters load so probes can report it.
 	go func() {
-		logger.Printf("Starting server at http://%s%s", cfg.Address, cfg.ServerAddr())
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logger.Fatalf("server failed to start: %v", err)
+		logger.Info("starting server", "url", fmt.Sprintf("%s://%s%s", scheme, cfg.Address, cfg.ServerAddr()))
+		var err error
+		if certReloader != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			fatal(logger, "server failed to start", err)
 		}
 	}()
+
+	if cfg.AdminAddr != "" {
+		go func() {
+			logger.Info("starting admin server", "addr", cfg.AdminAddr)
+			if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				fatal(logger, "admin server failed to start", err)
+			}
+		}()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := counter.Initialize(ctx); err != nil {
+		fatal(logger, "failed to initialize service", err)
+	}
+	if err := registry.Initialize(ctx); err != nil {
+		fatal(logger, "failed to initialize counters", err)
+	}
+	evictCtx, stopEviction := context.WithCancel(context.Background())
+	defer stopEviction()
+	if keyRegistry != nil {
+		go evictIdleKeys(evictCtx, keyRegistry, clk, cfg.Threshold)
+	}
+	worker.Start()
+	health.MarkStarted()
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			if _, err := reloader.reload(context.Background()); err != nil {
+				logger.Error("failed to reload configuration", "error", err)
+			}
+		}
+	}()
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
+	signal.Stop(hangup)
 
-	logger.Println("Shutting down server...")
+	// Keep serving while load balancers notice /readyz failing.
+	health.MarkDraining()
+	shutdownDelay := reloader.config().ShutdownDelay
+	logger.Info("shutting down server", "delay", shutdownDelay)
+	time.Sleep(shutdownDelay)
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer shutdownCancel()
 
 	if err := server.Shutdown(shutdownCtx); err != nil {
-		logger.Printf("Server forced to shutdown: %v", err)
+		logger.Error("server forced to shutdown", "error", err)
+	}
+	if err := adminServer.Shutdown(shutdownCtx); err != nil {
+		logger.Error("admin server forced to shutdown", "error", err)
+	}
+	stopEviction()
+	stopWatching()
+	// Stopping the worker first keeps its passes off the counters being closed.
+	if err := worker.Stop(shutdownCtx); err != nil {
+		logger.Error("failed to stop worker", "error", err)
+	}
+	if err := counter.Close(); err != nil {
+		logger.Error("failed to close store", "error", err)
+	}
+	if keyRegistry != nil {
+		if err := keyRegistry.Close(); err != nil {
+			logger.Error("failed to close client counters", "error", err)
+		}
 	}
-	if err := memoryStore.Close(); err != nil {
-		logger.Printf("Error closing store: %v", err)
+	if err := registry.Close(); err != nil {
+		logger.Error("failed to close counters", "error", err)
 	}
+	if err := tracer.Shutdown(shutdownCtx); err != nil {
+		logger.Error("failed to export remaining spans", "error", err)
+	}
+
+	logger.Info("server exited")
+}
+
+// newTracer returns a tracer exporting as configured. Without an exporter
+// it still propagates traceparent, it just records nothing.
+func newTracer(cfg *config.Config) (*tracing.Tracer, error) {
+	switch cfg.TraceExporter {
+	case "otlp":
+		return tracing.NewTracer(tracing.NewHTTPExporter(cfg.TraceEndpoint, cfg.TraceServiceName)), nil
+	case "file":
+		exporter, err := tracing.NewFileExporter(cfg.TraceFile, cfg.TraceServiceName)
+		if err != nil {
+			return nil, err
+		}
+		return tracing.NewTracer(exporter), nil
+	}
+	return tracing.NewTracer(nil), nil
+}
+// defaultSpec applies to the counters not declared in COUNTERS. The strategy
+// stays the one started with, like the main counter's, since switching it
+// takes a restart.
+func defaultSpec(started, cfg *config.Config) application.CounterSpec {
+	return application.CounterSpec{
+		Strategy:  application.Strategy(started.Strategy),
+		Threshold: cfg.Threshold,
+	}
+}
+// serviceOptions keeps the sync mode started with, since the worker that
+// syncs in the background only runs as configured at startup.
+func serviceOptions(started, cfg *config.Config, clk domain.Clock, counterMetrics *application.Metrics) []application.Option {
+	opts := []application.Option{
+		application.WithMetrics(counterMetrics),
+		application.WithClock(clk),
+		application.WithLimit(cfg.Limit),
+		application.WithMaxDelay(started.MaxWait),
+	}
+	if started.SyncMode == "background" {
+		opts = append(opts, application.WithBackgroundSync())
+	}
+	return opts
+}
+func registryOptions(started, cfg *config.Config, clk domain.Clock, counterMetrics *application.Metrics) []application.RegistryOption {
+	opts := []application.RegistryOption{
+		application.WithAutoCreate(cfg.AutoCreateCounters),
+		application.WithMaxCounters(cfg.MaxCounters),
+		application.WithServiceOptions(serviceOptions(started, cfg, clk, counterMetrics)...),
+	}
+	for name, c := range cfg.Counters {
+		opts = append(opts, application.WithCounter(name, application.CounterSpec{
+			Strategy:  application.Strategy(c.Strategy),
+			Threshold: c.Threshold,
+		}))
+	}
+	return opts
+}
+func keyRegistryOptions(started, cfg *config.Config, clk domain.Clock) []application.RegistryOption {
+	return []application.RegistryOption{
+		application.WithMaxCounters(cfg.MaxKeys),
+		application.WithEviction(),
+		application.WithServiceOptions(
+			application.WithClock(clk),
+			application.WithLimit(cfg.Limit),
+			application.WithMaxDelay(started.MaxWait),
+		),
+	}
+}
+func newKeyFunc(cfg *config.Config) preshttp.KeyFunc {
+	switch cfg.KeyBy {
+	case "header":
+		return preshttp.HeaderKey(cfg.KeyName)
+	case "query":
+		return preshttp.QueryKey(cfg.KeyName)
+	}
+	return preshttp.ForwardedClientIP(cfg.TrustedProxies)
+}
+
+// evictIdleKeys drops the counters of clients whose window has emptied, once
+// per window.
+func evictIdleKeys(ctx context.Context, registry *application.CounterRegistry, clk domain.Clock, interval time.Duration) {
+	ticker := clk.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			registry.EvictIdle(ctx)
+		}
+	}
+}
+
+func fatal(logger *slog.Logger, msg string, err error) {
+	logger.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+func newPersister(cfg *config.Config) persistence.FilePersistence {
+	persistenceOpts := []persistence.Option{
+		persistence.WithRecovery(persistence.RecoveryMode(cfg.Recovery)),
+	}
+	if cfg.Format == "binary" {
+		return persistence.NewBinaryPersistence(persistenceOpts...)
+	}
+	return persistence.NewFilePersistence(persistenceOpts...)
+}
+
+// newStorage opens counter files next to FILENAME. Named counters get their
+// own file; the main counter uses FILENAME itself. storeMetrics and health may
+// be nil.
+func newStorage(cfg *config.Config, persister persistence.FilePersistence, clk domain.Clock, storeMetrics *repository.Metrics, health *application.Health, named bool) application.Storage {
+	// Sync failures are counted per file, so a file that keeps failing is
+	// not hidden by the others syncing fine.
+	onSync := func(file string) func(error) {
+		if health == nil {
+			return nil
+		}
+		return func(err error) { health.ObserveSync(file, err) }
+	}
+	filename := func(name string) string {
+		if named {
+			return counterFilename(cfg.Filename, name)
+		}
+		return cfg.Filename
+	}
+	return application.Storage{
+		Timestamps: func(name string) (domain.TimestampRepository, error) {
+			threshold := cfg.Threshold
+			if c, ok := cfg.Counters[name]; ok && named && c.Threshold > 0 {
+				threshold = c.Threshold
+			}
+			return newRepository(cfg, filename(name), threshold, persister, clk,
+				repository.WithMetrics(storeMetrics, name), repository.WithSyncObserver(onSync(filename(name)))), nil
+		},
+		State: func(name string) (domain.StateStore, error) {
+			return repository.NewFileStateStore(filename(name)+stateSuffix, persister,
+				repository.WithClock(clk), repository.WithMetrics(storeMetrics, name), repository.WithSyncObserver(onSync(filename(name)+stateSuffix))), nil
+		},
+	}
+}
+
+func newRepository(cfg *config.Config, filename string, threshold time.Duration, persister persistence.FilePersistence, clk domain.Clock, opts ...repository.Option) domain.TimestampRepository {
+	storeOpts := append([]repository.Option{repository.WithClock(clk)}, opts...)
+	if cfg.PersistenceMode == "wal" {
+		storeOpts = append(storeOpts,
+			repository.WithWAL(cfg.CompactInterval),
+			repository.WithDurability(repository.Durability{
+				Mode:     repository.DurabilityMode(cfg.Durability),
+				Interval: cfg.DurabilityInterval,
+			}),
+		)
+	}
+
+	switch cfg.Store {
+	case "bucket":
+		return repository.NewBucketStore(filename, persister, threshold, cfg.BucketSize, storeOpts...)
+	case "sharded":
+		return repository.NewShardedStore(filename, persister, cfg.Shards, storeOpts...)
+	case "ring":
+		storeOpts = append(storeOpts, repository.WithRingBuffer())
+	}
+	return repository.NewMemoryStore(filename, persister, storeOpts...)
+}
+
+// stateSuffix marks the files of strategies that keep state instead of timestamps.
+const stateSuffix = ".state"
 
-	logger.Println("Server exited")
+// counterFilename places a counter's log next to the main one, so
+// "data/timestamps.log" becomes "data/timestamps.logins.log".
+func counterFilename(filename, counter string) string {
+	ext := filepath.Ext(filename)
+	return strings.TrimSuffix(filename, ext) + "." + counter + ext
 }
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+
+	"simplesurance/internal/application"
+	"simplesurance/internal/certs"
+	"simplesurance/internal/config"
+	"simplesurance/internal/domain"
+	"simplesurance/internal/logging"
+	preshttp "simplesurance/internal/presentation/http"
+)
+
+// liveSettings can change without a restart. KEY_BY can change how clients
+// are keyed, but not be turned on or off.
+var liveSettings = map[string]bool{
+	"THRESHOLD":            true,
+	"LIMIT":                true,
+	"COUNTERS":             true,
+	"AUTO_CREATE_COUNTERS": true,
+	"MAX_COUNTERS":         true,
+	"KEY_BY":               true,
+	"TRUSTED_PROXIES":      true,
+	"MAX_KEYS":             true,
+	"LOG_LEVEL":            true,
+	"SHUTDOWN_DELAY":       true,
+}
+
+// reloader re-reads the configuration on SIGHUP or POST /admin/reload and
+// applies the live settings to the running counters, which keep their hits.
+// It also reloads the TLS files if they changed, without waiting for the
+// next check of certs.Reloader.Watch.
+type reloader struct {
+	args           []string
+	started        *config.Config
+	clock          domain.Clock
+	logLevel       *slog.LevelVar
+	counterMetrics *application.Metrics
+	counter        application.Counter
+	registry       *application.CounterRegistry
+	keyRegistry    *application.CounterRegistry
+	keySwitch      *preshttp.KeySwitch
+	certs          *certs.Reloader
+
+	mu      sync.Mutex
+	current *config.Config
+}
+
+func (r *reloader) config() *config.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+// reload applies the new configuration and returns the changed settings that
+// need a restart. An invalid configuration, or TLS files that fail to load,
+// is rejected as a whole before anything is applied. Once applied, failing
+// to close or open a counter is reported, but the configuration stays.
+func (r *reloader) reload(ctx context.Context) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cfg, err := config.Load(r.args)
+	if err != nil {
+		return nil, err
+	}
+	restartRequired := r.restartRequired(cfg)
+	changed := r.current.Changed(cfg)
+
+	level, err := logging.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+	mainSpec := application.CounterSpec{Strategy: application.Strategy(r.started.Strategy), Threshold: cfg.Threshold}
+	errs := []error{
+		application.ValidateSpec(mainSpec, cfg.Limit),
+		r.registry.Validate(defaultSpec(r.started, cfg), registryOptions(r.started, cfg, r.clock, r.counterMetrics)...),
+	}
+	if r.keyRegistry != nil {
+		errs = append(errs, r.keyRegistry.Validate(defaultSpec(r.started, cfg), keyRegistryOptions(r.started, cfg, r.clock)...))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	// The certificates are the one part that can fail to load, so they
+	// go first; a reload that keeps the old ones changes nothing.
+	if r.certs != nil {
+		if _, err := r.certs.Reload(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	r.logLevel.Set(level)
+	errs = []error{
+		r.counter.Reconfigure(cfg.Threshold, cfg.Limit),
+		r.registry.Reconfigure(ctx, defaultSpec(r.started, cfg), registryOptions(r.started, cfg, r.clock, r.counterMetrics)...),
+	}
+	if r.keyRegistry != nil {
+		errs = append(errs, r.keyRegistry.Reconfigure(ctx, defaultSpec(r.started, cfg), keyRegistryOptions(r.started, cfg, r.clock)...))
+		if cfg.KeyBy != "" {
+			r.keySwitch.Set(newKeyFunc(cfg))
+		}
+	}
+	r.current = cfg
+
+	logger := logging.FromContext(ctx)
+	for _, name := range restartRequired {
+		logger.Warn("changed setting requires a restart", "setting", name)
+	}
+	if err := errors.Join(errs...); err != nil {
+		logger.Error("configuration reloaded, but counters failed to apply it", "changed", changed, "error", err)
+		return restartRequired, err
+	}
+	logger.Info("configuration reloaded", "changed", changed)
+	return restartRequired, nil
+}
+
+// restartRequired lists the settings that differ from the ones started with
+// and cannot be applied live, so they are reported until the restart.
+func (r *reloader) restartRequired(cfg *config.Config) []string {
+	var names []string
+	for _, name := range r.started.Changed(cfg) {
+		live := liveSettings[name]
+		if name == "KEY_BY" {
+			live = (r.started.KeyBy == "") == (cfg.KeyBy == "")
+		}
+		if !live {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,116 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"simplesurance/internal/domain"
+)
+
+// Counter is a rate-limiting window driven by one Strategy.
+type Counter interface {
+	Initialize(ctx context.Context) error
+	// TryRecord records a hit unless the limit is reached.
+	TryRecord(ctx context.Context) (Decision, error)
+	// Peek returns the current count without recording a hit.
+	Peek(ctx context.Context) (int, error)
+	// Reconfigure changes the threshold and limit while keeping the hits
+	// already in the window.
+	Reconfigure(threshold time.Duration, limit int) error
+	// Maintain expires old hits and persists the window; a Worker calls it
+	// periodically.
+	Maintain(ctx context.Context) error
+	Close() error
+}
+
+type Strategy string
+
+const (
+	// StrategySlidingLog keeps every timestamp in the window. It is exact and
+	// the only strategy that works without a limit.
+	StrategySlidingLog Strategy = "sliding-log"
+	// StrategySlidingWindow weights the previous fixed window's count by how
+	// much of it still overlaps the sliding window.
+	StrategySlidingWindow Strategy = "sliding-window"
+	// StrategyFixedWindow counts hits in aligned windows that reset at once.
+	StrategyFixedWindow Strategy = "fixed-window"
+	// StrategyTokenBucket refills limit tokens per threshold and allows
+	// bursts of up to limit hits.
+	StrategyTokenBucket Strategy = "token-bucket"
+	// StrategyLeakyBucket queues up to limit hits and lets them through at a
+	// constant rate of limit per threshold; see Decision.Delay.
+	StrategyLeakyBucket Strategy = "leaky-bucket"
+)
+
+// CounterSpec describes a counter. Zero fields take the registry defaults.
+type CounterSpec struct {
+	Strategy  Strategy
+	Threshold time.Duration
+}
+
+// StateStoreFactory opens the state store that backs the named counter.
+type StateStoreFactory func(name string) (domain.StateStore, error)
+
+// Storage opens the persistence of a counter: a timestamp repository for the
+// sliding log and a state store for the other strategies.
+type Storage struct {
+	Timestamps RepositoryFactory
+	State      StateStoreFactory
+}
+
+// NewCounter builds the named counter. Strategies other than the sliding log
+// need a limit, set with WithLimit.
+func NewCounter(name string, spec CounterSpec, storage Storage, opts ...Option) (Counter, error) {
+	counter, err := newCounter(name, spec, storage, opts)
+	if err != nil {
+		return nil, err
+	}
+	if m := newOptions(opts).metrics; m != nil {
+		return m.instrument(name, counter), nil
+	}
+	return counter, nil
+}
+
+// ValidateSpec checks that a counter with spec and limit can be created, or
+// reconfigured to them, without opening its storage.
+func ValidateSpec(spec CounterSpec, limit int) error {
+	if spec.Strategy == "" || spec.Strategy == StrategySlidingLog {
+		if spec.Threshold <= 0 || limit < 0 {
+			return fmt.Errorf("%w: threshold must be positive and limit non-negative", domain.ErrInvalidInput)
+		}
+		return nil
+	}
+	if limit <= 0 {
+		return fmt.Errorf("%w: strategy %q requires a limit", domain.ErrInvalidInput, spec.Strategy)
+	}
+	_, err := newAlgorithm(spec.Strategy, limit, spec.Threshold, 0)
+	return err
+}
+
+func newCounter(name string, spec CounterSpec, storage Storage, opts []Option) (Counter, error) {
+	if spec.Strategy == "" || spec.Strategy == StrategySlidingLog {
+		repo, err := storage.Timestamps(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create repository: %w", err)
+		}
+		return NewTimestampService(repo, spec.Threshold, opts...), nil
+	}
+
+	o := newOptions(opts)
+	if o.limit == 0 {
+		return nil, fmt.Errorf("%w: strategy %q requires a limit", domain.ErrInvalidInput, spec.Strategy)
+	}
+	alg, err := newAlgorithm(spec.Strategy, o.limit, spec.Threshold, o.maxDelay)
+	if err != nil {
+		return nil, err
+	}
+	store, err := storage.State(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create state store: %w", err)
+	}
+	counter := newStateCounter(spec.Strategy, alg, store, o.clock)
+	counter.backgroundSync = o.backgroundSync
+	counter.maxDelay = o.maxDelay
+	return counter, nil
+}
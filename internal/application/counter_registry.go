@@ -0,0 +1,454 @@
+package application
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"simplesurance/internal/domain"
+	"simplesurance/internal/logging"
+)
+
+const DefaultMaxCounters = 100
+
+var (
+	ErrTooManyCounters = errors.New("counter limit reached")
+	ErrRegistryClosed  = errors.New("counter registry closed")
+
+	// errReconfigured makes Get start over when the registry was
+	// reconfigured while it loaded a counter.
+	errReconfigured = errors.New("registry reconfigured while loading counter")
+)
+
+// RepositoryFactory opens the repository that backs the named counter.
+type RepositoryFactory func(name string) (domain.TimestampRepository, error)
+
+// CounterRegistry holds named counters, each with its own storage, strategy
+// and threshold. Declared counters use their spec; other names are created on
+// first use with the defaults unless auto-creation is off.
+type CounterRegistry struct {
+	storage     Storage
+	defaults    CounterSpec
+	declared    map[string]CounterSpec
+	autoCreate  bool
+	maxCounters int
+	serviceOpts []Option
+	evict       bool
+
+	mu       sync.RWMutex
+	counters map[string]*registryEntry
+	// lru orders the names of the open counters from the most to the least
+	// recently acquired. It is guarded by mu, and by lruMu as well for the
+	// moves made under a read lock.
+	lru   *list.List
+	lruMu sync.Mutex
+	// loading holds the counters being loaded, so concurrent callers for
+	// the same name share one load.
+	loading map[string]*pendingCounter
+	// generation changes with every Reconfigure, so a load that ran
+	// across one is not kept with stale settings.
+	generation int
+	closed     bool
+}
+
+type registryEntry struct {
+	counter Counter
+	// spec is the spec the counter was opened or last reconfigured with.
+	spec CounterSpec
+	// leases counts the callers of Acquire still using the counter. It only
+	// grows under a read lock of mu, so it cannot change while mu is held.
+	leases atomic.Int32
+	used   *list.Element
+}
+
+type pendingCounter struct {
+	done  chan struct{}
+	entry *registryEntry
+	err   error
+}
+
+type RegistryOption func(*CounterRegistry)
+
+// WithCounter declares a counter. Zero fields of spec use the registry defaults.
+func WithCounter(name string, spec CounterSpec) RegistryOption {
+	return func(r *CounterRegistry) {
+		r.declared[name] = spec
+	}
+}
+
+// WithAutoCreate controls whether undeclared counters are created on first use.
+func WithAutoCreate(enabled bool) RegistryOption {
+	return func(r *CounterRegistry) {
+		r.autoCreate = enabled
+	}
+}
+
+// WithMaxCounters bounds the number of open counters, declared ones included.
+// Once it is reached, new counters fail with ErrTooManyCounters unless one
+// can be evicted.
+func WithMaxCounters(n int) RegistryOption {
+	return func(r *CounterRegistry) {
+		if n > 0 {
+			r.maxCounters = n
+		}
+	}
+}
+
+// WithEviction lets the registry close undeclared counters whose window is
+// empty, on EvictIdle and to make room for a new counter once the maximum is
+// reached. It is meant for counters kept in memory only: an evicted counter
+// starts over when it is opened again.
+func WithEviction() RegistryOption {
+	return func(r *CounterRegistry) {
+		r.evict = true
+	}
+}
+
+// WithServiceOptions is applied to every counter.
+func WithServiceOptions(opts ...Option) RegistryOption {
+	return func(r *CounterRegistry) {
+		r.serviceOpts = append(r.serviceOpts, opts...)
+	}
+}
+
+func NewCounterRegistry(storage Storage, defaults CounterSpec, opts ...RegistryOption) *CounterRegistry {
+	r := &CounterRegistry{
+		storage:  storage,
+		counters: make(map[string]*registryEntry),
+		lru:      list.New(),
+		loading:  make(map[string]*pendingCounter),
+	}
+	r.configure(defaults, opts)
+	return r
+}
+func (r *CounterRegistry) configure(defaults CounterSpec, opts []RegistryOption) {
+	if defaults.Strategy == "" {
+		defaults.Strategy = StrategySlidingLog
+	}
+	r.defaults = defaults
+	r.declared = make(map[string]CounterSpec)
+	r.autoCreate = true
+	r.maxCounters = DefaultMaxCounters
+	r.serviceOpts = nil
+	r.evict = false
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.generation++
+}
+
+// Initialize opens every declared counter so configuration errors surface at startup.
+func (r *CounterRegistry) Initialize(ctx context.Context) error {
+	for name := range r.declared {
+		if _, err := r.Get(ctx, name); err != nil {
+			return fmt.Errorf("failed to open counter %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Get returns the named counter, creating and loading it if needed. With
+// WithEviction, use Acquire instead: a counter from Get may be evicted
+// before the caller gets to use it.
+func (r *CounterRegistry) Get(ctx context.Context, name string) (Counter, error) {
+	counter, release, err := r.Acquire(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	release()
+	return counter, nil
+}
+
+// Acquire is Get for registries with eviction: the counter is not evicted
+// until release is called.
+func (r *CounterRegistry) Acquire(ctx context.Context, name string) (counter Counter, release func(), err error) {
+	if err := domain.ValidateCounterName(name); err != nil {
+		return nil, nil, err
+	}
+	for {
+		r.mu.RLock()
+		entry, ok := r.counters[name]
+		if ok {
+			entry.leases.Add(1)
+			r.lruMu.Lock()
+			r.lru.MoveToFront(entry.used)
+			r.lruMu.Unlock()
+		}
+		r.mu.RUnlock()
+
+		if !ok {
+			entry, err = r.open(ctx, name)
+			if errors.Is(err, errReconfigured) || (err == nil && entry == nil) {
+				continue
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		return entry.counter, func() { entry.leases.Add(-1) }, nil
+	}
+}
+
+// Lookup returns the named counter, leased as by Acquire, only if it is
+// already open. It neither creates the counter nor marks it as used, so
+// reads such as a count cannot fill the registry or keep counters from
+// eviction.
+func (r *CounterRegistry) Lookup(name string) (counter Counter, release func(), ok bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entry, ok := r.counters[name]
+	if !ok {
+		return nil, nil, false
+	}
+	entry.leases.Add(1)
+	return entry.counter, func() { entry.leases.Add(-1) }, true
+}
+
+// open loads the named counter without holding mu, so a slow or damaged file
+// only delays the callers that want that counter. The loaded entry is
+// returned leased; callers that only waited for the load get no entry and
+// look it up again.
+func (r *CounterRegistry) open(ctx context.Context, name string) (*registryEntry, error) {
+	r.mu.Lock()
+	if _, ok := r.counters[name]; ok {
+		r.mu.Unlock()
+		return nil, nil
+	}
+	if pending, ok := r.loading[name]; ok {
+		r.mu.Unlock()
+		select {
+		case <-pending.done:
+			return nil, pending.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if r.closed {
+		r.mu.Unlock()
+		return nil, ErrRegistryClosed
+	}
+	spec, declared := r.specLocked(name)
+	if !declared && !r.autoCreate {
+		r.mu.Unlock()
+		return nil, fmt.Errorf("%w: counter %q", domain.ErrNotFound, name)
+	}
+	if len(r.counters)+len(r.loading) >= r.maxCounters && !r.evictOldestLocked(ctx) {
+		r.mu.Unlock()
+		return nil, ErrTooManyCounters
+	}
+	pending := &pendingCounter{done: make(chan struct{})}
+	r.loading[name] = pending
+	generation, opts := r.generation, r.serviceOpts
+	r.mu.Unlock()
+
+	// Other callers may wait for this load, so it is not cut short when
+	// this caller gives up.
+	loadCtx := context.WithoutCancel(ctx)
+	counter, err := NewCounter(name, spec, r.storage, opts...)
+	if err == nil {
+		if err = counter.Initialize(loadCtx); err != nil {
+			counter.Close()
+			counter = nil
+		}
+	}
+
+	var entry *registryEntry
+	r.mu.Lock()
+	delete(r.loading, name)
+	switch {
+	case err != nil:
+	case r.closed:
+		counter.Close()
+		err = ErrRegistryClosed
+	case r.generation != generation:
+		counter.Close()
+		err = errReconfigured
+	default:
+		entry = &registryEntry{counter: counter, spec: spec, used: r.lru.PushFront(name)}
+		entry.leases.Store(1)
+		r.counters[name] = entry
+	}
+	r.mu.Unlock()
+	pending.err = err
+	close(pending.done)
+
+	if err == nil {
+		logging.FromContext(ctx).Debug("opened counter", "counter", name, "strategy", spec.Strategy, "threshold", spec.Threshold)
+	}
+	return entry, err
+}
+
+// specLocked returns the spec of the named counter with the defaults filled
+// in, and whether it is declared. The caller must hold mu.
+func (r *CounterRegistry) specLocked(name string) (CounterSpec, bool) {
+	spec, declared := r.declared[name]
+	if spec.Strategy == "" {
+		spec.Strategy = r.defaults.Strategy
+	}
+	if spec.Threshold <= 0 {
+		spec.Threshold = r.defaults.Threshold
+	}
+	return spec, declared
+}
+
+// Reconfigure replaces the defaults and options as NewCounterRegistry would
+// set them, then applies them to the open counters, keeping their hits.
+// Counters whose strategy changed, and undeclared ones once auto-creation
+// is off, are closed; the former reopen with their new strategy on next
+// use. Newly declared counters are opened.
+func (r *CounterRegistry) Reconfigure(ctx context.Context, defaults CounterSpec, opts ...RegistryOption) error {
+	if err := r.Validate(defaults, opts...); err != nil {
+		return err
+	}
+	if err := r.reconfigure(ctx, defaults, opts); err != nil {
+		return err
+	}
+	return r.Initialize(ctx)
+}
+
+// Validate checks that every counter could be opened with the defaults and
+// options, without changing the registry. Reconfigure does nothing unless
+// they pass.
+func (r *CounterRegistry) Validate(defaults CounterSpec, opts ...RegistryOption) error {
+	next := &CounterRegistry{}
+	next.configure(defaults, opts)
+	limit := newOptions(next.serviceOpts).limit
+
+	spec, _ := next.specLocked("")
+	errs := []error{ValidateSpec(spec, limit)}
+	for name := range next.declared {
+		spec, _ := next.specLocked(name)
+		if err := ValidateSpec(spec, limit); err != nil {
+			errs = append(errs, fmt.Errorf("invalid counter %q: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *CounterRegistry) reconfigure(ctx context.Context, defaults CounterSpec, opts []RegistryOption) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.configure(defaults, opts)
+	limit := newOptions(r.serviceOpts).limit
+	var errs []error
+	for name, entry := range r.counters {
+		spec, declared := r.specLocked(name)
+		if (!declared && !r.autoCreate) || spec.Strategy != entry.spec.Strategy {
+			if err := entry.counter.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("failed to close counter %q: %w", name, err))
+			}
+			r.removeLocked(name)
+			logging.FromContext(ctx).Debug("closed reconfigured counter", "counter", name)
+			continue
+		}
+		if err := entry.counter.Reconfigure(spec.Threshold, limit); err != nil {
+			errs = append(errs, fmt.Errorf("failed to reconfigure counter %q: %w", name, err))
+			continue
+		}
+		entry.spec = spec
+	}
+	return errors.Join(errs...)
+}
+
+// EvictIdle closes the undeclared counters whose window is empty and that
+// no caller of Acquire holds, and returns how many were evicted. It does
+// nothing without WithEviction.
+func (r *CounterRegistry) EvictIdle(ctx context.Context) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.evict {
+		return 0
+	}
+	evicted := 0
+	for name := range r.counters {
+		if r.evictLocked(ctx, name) {
+			evicted++
+		}
+	}
+	if evicted > 0 {
+		logging.FromContext(ctx).Debug("evicted idle counters", "evicted", evicted, "open", len(r.counters))
+	}
+	return evicted
+}
+
+// evictOldestLocked evicts the least recently acquired counter that may be
+// evicted, if it is idle. It stops there, since the counters acquired later
+// are no more likely to be idle, so a full registry costs a new counter one
+// check instead of a scan.
+func (r *CounterRegistry) evictOldestLocked(ctx context.Context) bool {
+	if !r.evict {
+		return false
+	}
+	for e := r.lru.Back(); e != nil; e = e.Prev() {
+		name := e.Value.(string)
+		if _, declared := r.declared[name]; declared || r.counters[name].leases.Load() > 0 {
+			continue
+		}
+		return r.evictLocked(ctx, name)
+	}
+	return false
+}
+
+// evictLocked closes the named counter if it is undeclared, not leased and
+// idle. Holding mu keeps Acquire from leasing it in between.
+func (r *CounterRegistry) evictLocked(ctx context.Context, name string) bool {
+	entry := r.counters[name]
+	if _, declared := r.declared[name]; declared || entry.leases.Load() > 0 {
+		return false
+	}
+	if count, err := entry.counter.Peek(ctx); err != nil || count > 0 {
+		return false
+	}
+	entry.counter.Close()
+	r.removeLocked(name)
+	return true
+}
+
+// removeLocked forgets the named counter. The caller must hold mu.
+func (r *CounterRegistry) removeLocked(name string) {
+	r.lru.Remove(r.counters[name].used)
+	delete(r.counters, name)
+}
+
+// Counters returns the open counters by name.
+func (r *CounterRegistry) Counters() map[string]Counter {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	counters := make(map[string]Counter, len(r.counters))
+	for name, entry := range r.counters {
+		counters[name] = entry.counter
+	}
+	return counters
+}
+
+// Len returns the number of open counters.
+func (r *CounterRegistry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.counters)
+}
+
+// Close flushes and closes all open counters. Counters still loading are
+// closed when they finish, and Get fails from then on.
+func (r *CounterRegistry) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closed = true
+	var errs []error
+	for _, entry := range r.counters {
+		if err := entry.counter.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	r.counters = make(map[string]*registryEntry)
+	r.lru.Init()
+	return errors.Join(errs...)
+}
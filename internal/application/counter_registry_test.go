@@ -0,0 +1,361 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"simplesurance/internal/clock"
+	"simplesurance/internal/domain"
+)
+
+func newMockStorage(repos map[string]*mockRepo) Storage {
+	return Storage{
+		Timestamps: func(name string) (domain.TimestampRepository, error) {
+			repo := &mockRepo{}
+			repos[name] = repo
+			return repo, nil
+		},
+		State: func(name string) (domain.StateStore, error) {
+			return &mockStateStore{}, nil
+		},
+	}
+}
+
+func TestCounterRegistry_Get(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []RegistryOption
+		counter string
+		wantErr error
+	}{
+		{
+			name:    "creates undeclared counter on demand",
+			counter: "signups",
+		},
+		{
+			name:    "declared counter with auto-create disabled",
+			opts:    []RegistryOption{WithCounter("logins", CounterSpec{Threshold: time.Second}), WithAutoCreate(false)},
+			counter: "logins",
+		},
+		{
+			name:    "undeclared counter with auto-create disabled",
+			opts:    []RegistryOption{WithAutoCreate(false)},
+			counter: "signups",
+			wantErr: domain.ErrNotFound,
+		},
+		{
+			name:    "invalid name",
+			counter: "../etc",
+			wantErr: domain.ErrInvalidInput,
+		},
+		{
+			name:    "limit reached",
+			opts:    []RegistryOption{WithCounter("logins", CounterSpec{}), WithMaxCounters(1)},
+			counter: "signups",
+			wantErr: ErrTooManyCounters,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := NewCounterRegistry(newMockStorage(map[string]*mockRepo{}), CounterSpec{Threshold: time.Minute}, tt.opts...)
+			ctx := context.Background()
+			if err := registry.Initialize(ctx); err != nil {
+				t.Fatalf("Initialize() error = %v", err)
+			}
+
+			counter, err := registry.Get(ctx, tt.counter)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Get() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && counter == nil {
+				t.Fatal("Get() returned no counter")
+			}
+		})
+	}
+}
+
+func TestCounterRegistry_IndependentWindows(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1_700_000_000, 0))
+	repos := map[string]*mockRepo{}
+	registry := NewCounterRegistry(newMockStorage(repos), CounterSpec{Threshold: time.Minute},
+		WithCounter("short", CounterSpec{Threshold: time.Second}),
+		WithCounter("fixed", CounterSpec{Strategy: StrategyFixedWindow}),
+		WithServiceOptions(WithClock(fake), WithLimit(100)),
+	)
+	ctx := context.Background()
+
+	record := func(name string) int {
+		t.Helper()
+		counter, err := registry.Get(ctx, name)
+		if err != nil {
+			t.Fatalf("Get(%q) error = %v", name, err)
+		}
+		decision, err := counter.TryRecord(ctx)
+		if err != nil {
+			t.Fatalf("TryRecord() error = %v", err)
+		}
+		return decision.Count
+	}
+
+	record("short")
+	record("long")
+	record("long")
+	record("fixed")
+	fake.Advance(2 * time.Second)
+
+	if got := record("short"); got != 1 {
+		t.Errorf("short count = %d, want 1 (its one-second window expired)", got)
+	}
+	if got := record("long"); got != 3 {
+		t.Errorf("long count = %d, want 3 (default one-minute window)", got)
+	}
+	if got := record("fixed"); got != 2 {
+		t.Errorf("fixed count = %d, want 2 (declared strategy with default threshold)", got)
+	}
+	if len(repos) != 2 {
+		t.Errorf("repositories created = %d, want 2 (fixed keeps state, not timestamps)", len(repos))
+	}
+}
+
+func TestCounterRegistry_EvictIdle(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1_700_000_000, 0))
+	registry := NewCounterRegistry(newMockStorage(map[string]*mockRepo{}), CounterSpec{Threshold: time.Minute},
+		WithCounter("declared", CounterSpec{}),
+		WithMaxCounters(3),
+		WithEviction(),
+		WithServiceOptions(WithClock(fake)),
+	)
+	ctx := context.Background()
+	record := func(name string) error {
+		counter, err := registry.Get(ctx, name)
+		if err != nil {
+			return err
+		}
+		_, err = counter.TryRecord(ctx)
+		return err
+	}
+
+	for _, name := range []string{"declared", "old", "new"} {
+		if err := record(name); err != nil {
+			t.Fatalf("record(%q) error = %v", name, err)
+		}
+		fake.Advance(20 * time.Second)
+	}
+	if err := record("full"); !errors.Is(err, ErrTooManyCounters) {
+		t.Fatalf("record(full) error = %v, want %v while every counter is busy", err, ErrTooManyCounters)
+	}
+
+	// "declared" and "old" are now idle, but declared counters are kept.
+	fake.Advance(25 * time.Second)
+	if evicted := registry.EvictIdle(ctx); evicted != 1 {
+		t.Errorf("EvictIdle() = %d, want 1", evicted)
+	}
+	if n := registry.Len(); n != 2 {
+		t.Errorf("Len() = %d, want 2", n)
+	}
+
+	// Reaching the limit evicts the least recently used counter if it is idle.
+	fake.Advance(time.Minute)
+	if err := record("other"); err != nil {
+		t.Fatalf("record(other) error = %v", err)
+	}
+	if err := record("full"); err != nil {
+		t.Errorf("record(full) error = %v, want room made by evicting \"new\"", err)
+	}
+
+	// A counter is kept while it is acquired, even with an empty window.
+	fake.Advance(time.Minute)
+	_, release, err := registry.Acquire(ctx, "full")
+	if err != nil {
+		t.Fatalf("Acquire(full) error = %v", err)
+	}
+	if evicted := registry.EvictIdle(ctx); evicted != 1 {
+		t.Errorf("EvictIdle() with \"full\" acquired = %d, want 1", evicted)
+	}
+	release()
+	if evicted := registry.EvictIdle(ctx); evicted != 1 {
+		t.Errorf("EvictIdle() after release = %d, want 1", evicted)
+	}
+}
+
+func TestCounterRegistry_EvictsOnlyWithEviction(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1_700_000_000, 0))
+	registry := NewCounterRegistry(newMockStorage(map[string]*mockRepo{}), CounterSpec{Threshold: time.Minute},
+		WithMaxCounters(1),
+		WithServiceOptions(WithClock(fake)),
+	)
+	ctx := context.Background()
+	idle, err := registry.Get(ctx, "idle")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := registry.Get(ctx, "new"); !errors.Is(err, ErrTooManyCounters) {
+		t.Errorf("Get(new) error = %v, want %v", err, ErrTooManyCounters)
+	}
+	if evicted := registry.EvictIdle(ctx); evicted != 0 {
+		t.Errorf("EvictIdle() = %d, want 0", evicted)
+	}
+	if counters := registry.Counters(); counters["idle"] != idle {
+		t.Error("idle counter was replaced")
+	}
+}
+
+func TestCounterRegistry_Reconfigure(t *testing.T) {
+	repos := map[string]*mockRepo{}
+	registry := NewCounterRegistry(newMockStorage(repos), CounterSpec{Threshold: time.Minute},
+		WithCounter("logins", CounterSpec{}),
+		WithServiceOptions(WithLimit(1)),
+	)
+	ctx := context.Background()
+	if err := registry.Initialize(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"logins", "signups", "uploads"} {
+		counter, err := registry.Get(ctx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tryRecord(t, counter)
+	}
+	logins, _ := registry.Get(ctx, "logins")
+	uploads, _ := registry.Get(ctx, "uploads")
+
+	// "uploads" switches strategy, "orders" is newly declared and "signups"
+	// goes once undeclared counters are no longer created.
+	err := registry.Reconfigure(ctx, CounterSpec{Threshold: time.Minute},
+		WithCounter("logins", CounterSpec{}),
+		WithCounter("uploads", CounterSpec{Strategy: StrategyTokenBucket}),
+		WithCounter("orders", CounterSpec{}),
+		WithAutoCreate(false),
+		WithServiceOptions(WithLimit(2)),
+	)
+	if err != nil {
+		t.Fatalf("Reconfigure() error = %v", err)
+	}
+
+	counters := registry.Counters()
+	if _, ok := counters["signups"]; ok {
+		t.Error("undeclared counter kept with auto-create disabled")
+	}
+	if _, ok := counters["orders"]; !ok {
+		t.Error("newly declared counter not opened")
+	}
+	if counters["uploads"] == uploads {
+		t.Error("counter not reopened after a strategy change")
+	}
+	if counters["logins"] != logins {
+		t.Fatal("unchanged counter was reopened")
+	}
+	if got := peek(t, logins); got != 1 {
+		t.Errorf("Peek() = %d, want the hit recorded before reconfiguring", got)
+	}
+	if !tryRecord(t, logins).Allowed {
+		t.Error("hit under the raised limit was rejected")
+	}
+}
+
+// blockingRepo holds Load until release is closed.
+type blockingRepo struct {
+	*mockRepo
+	loading chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingRepo) Load(ctx context.Context) error {
+	close(b.loading)
+	<-b.release
+	return b.mockRepo.Load(ctx)
+}
+
+func TestCounterRegistry_LoadsOutsideLock(t *testing.T) {
+	slow := &blockingRepo{mockRepo: &mockRepo{}, loading: make(chan struct{}), release: make(chan struct{})}
+	var slowOpened atomic.Int32
+	registry := NewCounterRegistry(Storage{
+		Timestamps: func(name string) (domain.TimestampRepository, error) {
+			if name == "slow" {
+				slowOpened.Add(1)
+				return slow, nil
+			}
+			return &mockRepo{}, nil
+		},
+	}, CounterSpec{Threshold: time.Minute})
+	ctx := context.Background()
+	if _, err := registry.Get(ctx, "open"); err != nil {
+		t.Fatal(err)
+	}
+
+	const waiters = 3
+	results := make(chan Counter, waiters)
+	for i := 0; i < waiters; i++ {
+		go func() {
+			counter, err := registry.Get(ctx, "slow")
+			if err != nil {
+				t.Errorf("Get(slow) error = %v", err)
+			}
+			results <- counter
+		}()
+	}
+	<-slow.loading
+
+	// Other counters, open or not, are served while "slow" loads.
+	for _, name := range []string{"open", "new"} {
+		getCtx, cancel := context.WithTimeout(ctx, time.Second)
+		if _, err := registry.Get(getCtx, name); err != nil {
+			t.Errorf("Get(%s) while another counter loads: error = %v", name, err)
+		}
+		cancel()
+	}
+	getCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	if _, err := registry.Get(getCtx, "slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Get(slow) with an expiring context: error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	cancel()
+
+	close(slow.release)
+	first := <-results
+	for i := 1; i < waiters; i++ {
+		if counter := <-results; counter != first {
+			t.Error("concurrent callers got different counters")
+		}
+	}
+	if n := slowOpened.Load(); n != 1 {
+		t.Errorf("slow counter opened %d times, want once", n)
+	}
+}
+
+func TestCounterRegistry_ReconfigureRejectsInvalid(t *testing.T) {
+	registry := NewCounterRegistry(newMockStorage(map[string]*mockRepo{}), CounterSpec{Threshold: time.Minute},
+		WithServiceOptions(WithLimit(1)),
+	)
+	ctx := context.Background()
+	counter, err := registry.Get(ctx, "logins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tryRecord(t, counter)
+
+	// "uploads" is valid, but a token bucket without a limit is not, so
+	// neither the new limit nor auto-creation being off is applied.
+	err = registry.Reconfigure(ctx, CounterSpec{Threshold: time.Minute},
+		WithCounter("uploads", CounterSpec{}),
+		WithCounter("orders", CounterSpec{Strategy: StrategyTokenBucket}),
+		WithAutoCreate(false),
+	)
+	if !errors.Is(err, domain.ErrInvalidInput) {
+		t.Fatalf("Reconfigure() error = %v, want %v", err, domain.ErrInvalidInput)
+	}
+	if counters := registry.Counters(); len(counters) != 1 || counters["logins"] != counter {
+		t.Errorf("Counters() = %v, want only the unchanged logins counter", counters)
+	}
+	if tryRecord(t, counter).Allowed {
+		t.Error("hit over the old limit was allowed")
+	}
+	if _, err := registry.Get(ctx, "signups"); err != nil {
+		t.Errorf("Get(signups) error = %v, want auto-creation still on", err)
+	}
+}
@@ -0,0 +1,328 @@
+package application
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"simplesurance/internal/clock"
+	"simplesurance/internal/domain"
+)
+
+type mockStateStore struct {
+	state []int64
+}
+
+func (m *mockStateStore) Load(ctx context.Context) ([]int64, error) {
+	return append([]int64(nil), m.state...), nil
+}
+
+func (m *mockStateStore) Save(ctx context.Context, state []int64) error {
+	m.state = append([]int64(nil), state...)
+	return nil
+}
+
+func (m *mockStateStore) Close() error {
+	return nil
+}
+
+var allStrategies = []Strategy{
+	StrategySlidingLog,
+	StrategySlidingWindow,
+	StrategyFixedWindow,
+	StrategyTokenBucket,
+	StrategyLeakyBucket,
+}
+
+const (
+	conformanceLimit  = 5
+	conformanceWindow = 10 * time.Second
+)
+
+// conformanceStart is aligned to the window so fixed windows start with it.
+var conformanceStart = time.Unix(1_700_000_000, 0).Truncate(conformanceWindow)
+
+// newConformanceCounter returns a counter whose storage survives being
+// reopened by calling the returned function again.
+func newConformanceCounter(t *testing.T, strategy Strategy, clk domain.Clock) func() Counter {
+	t.Helper()
+	repo := &mockRepo{}
+	store := &mockStateStore{}
+	storage := Storage{
+		Timestamps: func(string) (domain.TimestampRepository, error) { return repo, nil },
+		State:      func(string) (domain.StateStore, error) { return store, nil },
+	}
+	return func() Counter {
+		t.Helper()
+		counter, err := NewCounter("test", CounterSpec{Strategy: strategy, Threshold: conformanceWindow}, storage,
+			WithClock(clk), WithLimit(conformanceLimit))
+		if err != nil {
+			t.Fatalf("NewCounter() error = %v", err)
+		}
+		if err := counter.Initialize(context.Background()); err != nil {
+			t.Fatalf("Initialize() error = %v", err)
+		}
+		return counter
+	}
+}
+
+func tryRecord(t *testing.T, counter Counter) Decision {
+	t.Helper()
+	decision, err := counter.TryRecord(context.Background())
+	if err != nil {
+		t.Fatalf("TryRecord() error = %v", err)
+	}
+	return decision
+}
+
+func peek(t *testing.T, counter Counter) int {
+	t.Helper()
+	count, err := counter.Peek(context.Background())
+	if err != nil {
+		t.Fatalf("Peek() error = %v", err)
+	}
+	return count
+}
+
+// TestCounter_Conformance checks the behavior every strategy shares.
+func TestCounter_Conformance(t *testing.T) {
+	for _, strategy := range allStrategies {
+		t.Run(string(strategy), func(t *testing.T) {
+			t.Run("burst up to the limit", func(t *testing.T) {
+				fake := clock.NewFake(conformanceStart)
+				counter := newConformanceCounter(t, strategy, fake)()
+
+				for i := 1; i <= conformanceLimit; i++ {
+					d := tryRecord(t, counter)
+					if !d.Allowed || d.Count != i || d.Remaining != conformanceLimit-i || d.Limit != conformanceLimit {
+						t.Fatalf("hit %d: TryRecord() = %+v, want allowed with count %d", i, d, i)
+					}
+				}
+				d := tryRecord(t, counter)
+				if d.Allowed || d.Remaining != 0 || d.RetryAfter() <= 0 {
+					t.Fatalf("TryRecord() over the limit = %+v, want rejected with a retry delay", d)
+				}
+			})
+
+			t.Run("rejection records nothing", func(t *testing.T) {
+				fake := clock.NewFake(conformanceStart)
+				counter := newConformanceCounter(t, strategy, fake)()
+				for i := 0; i < conformanceLimit; i++ {
+					tryRecord(t, counter)
+				}
+
+				before := peek(t, counter)
+				for i := 0; i < 3; i++ {
+					tryRecord(t, counter)
+				}
+				if after := peek(t, counter); after != before {
+					t.Errorf("Peek() after rejections = %d, want %d", after, before)
+				}
+			})
+
+			t.Run("retry after the advertised delay", func(t *testing.T) {
+				fake := clock.NewFake(conformanceStart)
+				counter := newConformanceCounter(t, strategy, fake)()
+				for i := 0; i < conformanceLimit; i++ {
+					tryRecord(t, counter)
+				}
+				fake.Advance(time.Second)
+
+				rejected := tryRecord(t, counter)
+				if rejected.Allowed {
+					t.Fatalf("TryRecord() = %+v, want rejected", rejected)
+				}
+				if rejected.RetryAfter() > time.Nanosecond {
+					fake.Advance(rejected.RetryAfter() - time.Nanosecond)
+					if d := tryRecord(t, counter); d.Allowed {
+						t.Fatalf("TryRecord() just before RetryAfter = %+v, want rejected", d)
+					}
+					fake.Advance(time.Nanosecond)
+				} else {
+					fake.Advance(rejected.RetryAfter())
+				}
+				if d := tryRecord(t, counter); !d.Allowed {
+					t.Errorf("TryRecord() after RetryAfter = %+v, want allowed", d)
+				}
+			})
+
+			t.Run("quota returns after idle windows", func(t *testing.T) {
+				fake := clock.NewFake(conformanceStart)
+				counter := newConformanceCounter(t, strategy, fake)()
+				for i := 0; i < conformanceLimit; i++ {
+					tryRecord(t, counter)
+				}
+
+				fake.Advance(2 * conformanceWindow)
+				if count := peek(t, counter); count != 0 {
+					t.Errorf("Peek() after idle windows = %d, want 0", count)
+				}
+				for i := 1; i <= conformanceLimit; i++ {
+					if d := tryRecord(t, counter); !d.Allowed {
+						t.Fatalf("hit %d after idle windows: TryRecord() = %+v, want allowed", i, d)
+					}
+				}
+			})
+
+			t.Run("state survives a restart", func(t *testing.T) {
+				fake := clock.NewFake(conformanceStart)
+				open := newConformanceCounter(t, strategy, fake)
+				counter := open()
+				for i := 0; i < conformanceLimit; i++ {
+					tryRecord(t, counter)
+				}
+				if err := counter.Close(); err != nil {
+					t.Fatalf("Close() error = %v", err)
+				}
+
+				reopened := open()
+				if count := peek(t, reopened); count != conformanceLimit {
+					t.Errorf("Peek() after reopening = %d, want %d", count, conformanceLimit)
+				}
+				if d := tryRecord(t, reopened); d.Allowed {
+					t.Errorf("TryRecord() after reopening = %+v, want rejected", d)
+				}
+			})
+		})
+	}
+}
+
+func TestCounter_Strategies(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy Strategy
+		// hits are the offsets from conformanceStart at which to record.
+		hits       []time.Duration
+		wantResult []bool
+	}{
+		{
+			name:       "fixed window resets at the boundary",
+			strategy:   StrategyFixedWindow,
+			hits:       []time.Duration{9 * time.Second, 9 * time.Second, 9 * time.Second, 9 * time.Second, 9 * time.Second, 9 * time.Second, 10 * time.Second},
+			wantResult: []bool{true, true, true, true, true, false, true},
+		},
+		{
+			name:     "sliding window weights the previous window",
+			strategy: StrategySlidingWindow,
+			// The first window's five hits count half at 15s, leaving room for three.
+			hits:       []time.Duration{9 * time.Second, 9 * time.Second, 9 * time.Second, 9 * time.Second, 9 * time.Second, 15 * time.Second, 15 * time.Second, 15 * time.Second, 15 * time.Second},
+			wantResult: []bool{true, true, true, true, true, true, true, true, false},
+		},
+		{
+			name:     "token bucket refills one token per interval",
+			strategy: StrategyTokenBucket,
+			// One token every 2s; after the burst only one is back at 2s.
+			hits:       []time.Duration{0, 0, 0, 0, 0, 2 * time.Second, 2 * time.Second},
+			wantResult: []bool{true, true, true, true, true, true, false},
+		},
+		{
+			name:       "leaky bucket holds limit hits",
+			strategy:   StrategyLeakyBucket,
+			hits:       []time.Duration{0, 0, 0, 0, 0, 0, 2 * time.Second},
+			wantResult: []bool{true, true, true, true, true, false, true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := clock.NewFake(conformanceStart)
+			counter := newConformanceCounter(t, tt.strategy, fake)()
+			for i, offset := range tt.hits {
+				fake.Set(conformanceStart.Add(offset))
+				if d := tryRecord(t, counter); d.Allowed != tt.wantResult[i] {
+					t.Errorf("hit %d at +%v: TryRecord() = %+v, want allowed %v", i, offset, d, tt.wantResult[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCounter_LeakyBucketDelays(t *testing.T) {
+	fake := clock.NewFake(conformanceStart)
+	counter := newConformanceCounter(t, StrategyLeakyBucket, fake)()
+
+	// Five per ten seconds drains one queued hit every two seconds.
+	for i := 0; i < conformanceLimit; i++ {
+		want := time.Duration(i) * 2 * time.Second
+		if d := tryRecord(t, counter); d.Delay != want {
+			t.Errorf("hit %d: Delay = %v, want %v", i, d.Delay, want)
+		}
+	}
+}
+
+func TestCounter_LeakyBucketMaxDelay(t *testing.T) {
+	fake := clock.NewFake(conformanceStart)
+	storage := Storage{State: func(string) (domain.StateStore, error) { return &mockStateStore{}, nil }}
+	counter, err := NewCounter("test", CounterSpec{Strategy: StrategyLeakyBucket, Threshold: conformanceWindow}, storage,
+		WithClock(fake), WithLimit(conformanceLimit), WithMaxDelay(3*time.Second))
+	if err != nil {
+		t.Fatalf("NewCounter() error = %v", err)
+	}
+
+	// Hits wait 0s and 2s; the third would wait 4s and is turned away
+	// without taking a place in the queue.
+	for i := 0; i < 2; i++ {
+		if d := tryRecord(t, counter); !d.Allowed {
+			t.Fatalf("hit %d rejected", i)
+		}
+	}
+	d := tryRecord(t, counter)
+	if d.Allowed {
+		t.Fatalf("hit with Delay over the cap allowed, Delay = %v", d.Delay)
+	}
+	if d.RetryAfter() != time.Second {
+		t.Errorf("RetryAfter() = %v, want 1s", d.RetryAfter())
+	}
+	if got := peek(t, counter); got != 2 {
+		t.Errorf("Peek() after the rejected hit = %d, want 2", got)
+	}
+
+	fake.Advance(time.Second)
+	if d := tryRecord(t, counter); !d.Allowed || d.Delay != 3*time.Second {
+		t.Errorf("hit after RetryAfter: Allowed = %v, Delay = %v, want allowed after 3s", d.Allowed, d.Delay)
+	}
+}
+
+func TestNewCounter_RequiresLimit(t *testing.T) {
+	storage := newMockStorage(map[string]*mockRepo{})
+	for _, strategy := range allStrategies[1:] {
+		if _, err := NewCounter("test", CounterSpec{Strategy: strategy, Threshold: time.Minute}, storage); err == nil {
+			t.Errorf("NewCounter(%s) without a limit succeeded, want error", strategy)
+		}
+	}
+	if _, err := NewCounter("test", CounterSpec{Strategy: "unknown", Threshold: time.Minute}, storage, WithLimit(1)); err == nil {
+		t.Error("NewCounter(unknown) succeeded, want error")
+	}
+}
+
+func TestCounter_Reconfigure(t *testing.T) {
+	for _, strategy := range allStrategies {
+		t.Run(string(strategy), func(t *testing.T) {
+			clk := clock.NewFake(conformanceStart)
+			counter := newConformanceCounter(t, strategy, clk)()
+			for i := 0; i < conformanceLimit; i++ {
+				tryRecord(t, counter)
+			}
+			if tryRecord(t, counter).Allowed {
+				t.Fatal("hit over the limit was allowed")
+			}
+
+			if err := counter.Reconfigure(conformanceWindow, 2*conformanceLimit); err != nil {
+				t.Fatalf("Reconfigure() error = %v", err)
+			}
+			if got := peek(t, counter); got != conformanceLimit {
+				t.Errorf("Peek() after raising the limit = %d, want %d", got, conformanceLimit)
+			}
+			if !tryRecord(t, counter).Allowed {
+				t.Error("hit under the raised limit was rejected")
+			}
+
+			if err := counter.Reconfigure(conformanceWindow, conformanceLimit-1); err != nil {
+				t.Fatalf("Reconfigure() error = %v", err)
+			}
+			if tryRecord(t, counter).Allowed {
+				t.Error("hit over the lowered limit was allowed")
+			}
+		})
+	}
+}
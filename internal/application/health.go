@@ -0,0 +1,171 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+const DefaultMaxSyncFailures = 3
+
+var (
+	ErrStarting = errors.New("counters are still loading")
+	ErrDraining = errors.New("server is shutting down")
+)
+
+// CheckResult is the outcome of one probe check. Err is nil if it passed.
+type CheckResult struct {
+	Name string
+	Err  error
+}
+
+// Health tracks whether the server is alive and whether it should receive
+// traffic. It is not ready until MarkStarted, nor after MarkDraining, nor
+// while the last syncs of any one store have all failed.
+type Health struct {
+	maxSyncFailures int
+
+	mu       sync.Mutex
+	liveness []livenessCheck
+	started  bool
+	draining bool
+	// syncFailures holds the current streak of failed syncs of each store
+	// whose last sync failed.
+	syncFailures map[string]syncFailures
+}
+
+type syncFailures struct {
+	count   int
+	lastErr error
+}
+
+type livenessCheck struct {
+	name  string
+	check func(context.Context) error
+}
+
+type HealthOption func(*Health)
+
+// WithMaxSyncFailures sets how many consecutive failed syncs make the server
+// unready.
+func WithMaxSyncFailures(n int) HealthOption {
+	return func(h *Health) {
+		if n > 0 {
+			h.maxSyncFailures = n
+		}
+	}
+}
+
+func NewHealth(opts ...HealthOption) *Health {
+	h := &Health{
+		maxSyncFailures: DefaultMaxSyncFailures,
+		syncFailures:    make(map[string]syncFailures),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// AddLivenessCheck adds a check that fails only if the process is stuck and
+// should be restarted, such as a deadlocked counter.
+func (h *Health) AddLivenessCheck(name string, check func(ctx context.Context) error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.liveness = append(h.liveness, livenessCheck{name: name, check: check})
+}
+
+// MarkStarted records that the counters finished loading.
+func (h *Health) MarkStarted() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.started = true
+}
+
+// MarkDraining records that shutdown began.
+func (h *Health) MarkDraining() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.draining = true
+}
+func (h *Health) Started() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.started
+}
+
+// ObserveSync records the outcome of a sync of the named store, such as its
+// file. A success only ends the streak of failures of that store, so one
+// healthy store does not hide another that keeps failing.
+func (h *Health) ObserveSync(store string, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err == nil {
+		delete(h.syncFailures, store)
+		return
+	}
+	failures := h.syncFailures[store]
+	h.syncFailures[store] = syncFailures{count: failures.count + 1, lastErr: err}
+}
+
+// Liveness runs the checks added with AddLivenessCheck.
+func (h *Health) Liveness(ctx context.Context) []CheckResult {
+	h.mu.Lock()
+	checks := append([]livenessCheck(nil), h.liveness...)
+	h.mu.Unlock()
+
+	results := make([]CheckResult, len(checks))
+	for i, c := range checks {
+		results[i] = CheckResult{Name: c.name, Err: c.check(ctx)}
+	}
+	return results
+}
+
+// CounterResponds is a liveness check that fails if counter does not answer
+// a Peek before ctx ends, e.g. because it is deadlocked.
+func CounterResponds(counter Counter) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		done := make(chan error, 1)
+		go func() {
+			_, err := counter.Peek(ctx)
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			return err
+		case <-ctx.Done():
+			return fmt.Errorf("counter did not respond: %w", ctx.Err())
+		}
+	}
+}
+
+// Readiness checks startup, shutdown and persistence.
+func (h *Health) Readiness(ctx context.Context) []CheckResult {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	results := []CheckResult{{Name: "startup"}, {Name: "shutdown"}, {Name: "persistence"}}
+	if !h.started {
+		results[0].Err = ErrStarting
+	}
+	if h.draining {
+		results[1].Err = ErrDraining
+	}
+	var failing []string
+	for store, failures := range h.syncFailures {
+		if failures.count >= h.maxSyncFailures {
+			failing = append(failing, store)
+		}
+	}
+	slices.Sort(failing)
+	var errs []error
+	for _, store := range failing {
+		failures := h.syncFailures[store]
+		errs = append(errs, fmt.Errorf("last %d syncs of %s failed: %w", failures.count, store, failures.lastErr))
+	}
+	results[2].Err = errors.Join(errs...)
+	return results
+}
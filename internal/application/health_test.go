@@ -0,0 +1,87 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func failedChecks(results []CheckResult) []string {
+	var failed []string
+	for _, result := range results {
+		if result.Err != nil {
+			failed = append(failed, result.Name)
+		}
+	}
+	return failed
+}
+
+func TestHealth_Readiness(t *testing.T) {
+	errDisk := errors.New("disk full")
+	type observed struct {
+		store string
+		err   error
+	}
+	failA, okA := observed{"a.log", errDisk}, observed{"a.log", nil}
+	failB, okB := observed{"b.log", errDisk}, observed{"b.log", nil}
+	tests := []struct {
+		name       string
+		started    bool
+		draining   bool
+		syncs      []observed
+		wantFailed []string
+	}{
+		{name: "loading", wantFailed: []string{"startup"}},
+		{name: "ready", started: true},
+		{name: "draining", started: true, draining: true, wantFailed: []string{"shutdown"}},
+		{name: "fewer failed syncs than allowed", started: true, syncs: []observed{failA, failA}},
+		{name: "too many failed syncs", started: true, syncs: []observed{failA, failA, failA}, wantFailed: []string{"persistence"}},
+		{name: "a successful sync recovers", started: true, syncs: []observed{failA, failA, failA, okA}},
+		{name: "failures must be consecutive", started: true, syncs: []observed{failA, failA, okA, failA}},
+		{name: "other stores syncing do not hide failures", started: true, syncs: []observed{failA, okB, failA, okB, failA}, wantFailed: []string{"persistence"}},
+		{name: "failures of different stores do not add up", started: true, syncs: []observed{failA, failB, failA, failB}},
+		{name: "one store recovering leaves the other failing", started: true, syncs: []observed{failA, failB, failA, failB, failA, failB, okA}, wantFailed: []string{"persistence"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHealth(WithMaxSyncFailures(3))
+			if tt.started {
+				h.MarkStarted()
+			}
+			if tt.draining {
+				h.MarkDraining()
+			}
+			for _, s := range tt.syncs {
+				h.ObserveSync(s.store, s.err)
+			}
+
+			failed := failedChecks(h.Readiness(context.Background()))
+			if len(failed) != len(tt.wantFailed) || (len(failed) > 0 && failed[0] != tt.wantFailed[0]) {
+				t.Errorf("failed checks = %v, want %v", failed, tt.wantFailed)
+			}
+		})
+	}
+}
+
+// stuckCounter never answers, like a counter whose lock is never released.
+type stuckCounter struct {
+	Counter
+}
+
+func (stuckCounter) Peek(ctx context.Context) (int, error) {
+	select {}
+}
+
+func TestCounterResponds(t *testing.T) {
+	h := NewHealth()
+	h.AddLivenessCheck("main", CounterResponds(NewTimestampService(&mockRepo{}, time.Minute)))
+	h.AddLivenessCheck("stuck", CounterResponds(stuckCounter{}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if failed := failedChecks(h.Liveness(ctx)); len(failed) != 1 || failed[0] != "stuck" {
+		t.Errorf("failed checks = %v, want [stuck]", failed)
+	}
+}
@@ -0,0 +1,72 @@
+package application
+
+import (
+	"context"
+	"time"
+
+	"simplesurance/internal/metrics"
+)
+
+// Metrics counts the hits counters admit and reject, and the Worker's passes.
+type Metrics struct {
+	hits         *metrics.CounterVec
+	passes       *metrics.CounterVec
+	passDuration *metrics.HistogramVec
+}
+
+func NewMetrics(r *metrics.Registry) *Metrics {
+	return &Metrics{
+		hits: r.NewCounterVec("counter_hits_total",
+			"Hits offered to a counter by result, \"allowed\" or \"rejected\".", "counter", "result"),
+		passes: r.NewCounterVec("worker_passes_total",
+			"Background maintenance passes by result, \"ok\" or \"failed\".", "result"),
+		passDuration: r.NewHistogramVec("worker_pass_duration_seconds",
+			"Time taken by a background maintenance pass over all counters.", metrics.DefaultBuckets),
+	}
+}
+
+// WithMetrics counts every counter's hits under its name.
+func WithMetrics(m *Metrics) Option {
+	return func(o *options) {
+		o.metrics = m
+	}
+}
+
+// instrumentedCounter counts the decisions of the counter it wraps.
+type instrumentedCounter struct {
+	Counter
+	allowed  *metrics.Counter
+	rejected *metrics.Counter
+}
+
+func (m *Metrics) instrument(name string, counter Counter) Counter {
+	return &instrumentedCounter{
+		Counter:  counter,
+		allowed:  m.hits.WithLabelValues(name, "allowed"),
+		rejected: m.hits.WithLabelValues(name, "rejected"),
+	}
+}
+func (c *instrumentedCounter) TryRecord(ctx context.Context) (Decision, error) {
+	decision, err := c.Counter.TryRecord(ctx)
+	if err != nil {
+		return decision, err
+	}
+	if decision.Allowed {
+		c.allowed.Inc()
+	} else {
+		c.rejected.Inc()
+	}
+	return decision, nil
+}
+
+func (m *Metrics) observePass(d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	result := "ok"
+	if err != nil {
+		result = "failed"
+	}
+	m.passes.WithLabelValues(result).Inc()
+	m.passDuration.WithLabelValues().Observe(d.Seconds())
+}
@@ -0,0 +1,32 @@
+package application
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"simplesurance/internal/clock"
+	"simplesurance/internal/metrics"
+)
+
+func TestWithMetrics(t *testing.T) {
+	m := NewMetrics(metrics.NewRegistry())
+	fake := clock.NewFake(time.Unix(1_700_000_000, 0))
+	counter, err := NewCounter("logins", CounterSpec{Threshold: time.Minute}, newMockStorage(map[string]*mockRepo{}),
+		WithClock(fake), WithLimit(2), WithMetrics(m))
+	if err != nil {
+		t.Fatalf("NewCounter() error = %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := counter.TryRecord(context.Background()); err != nil {
+			t.Fatalf("TryRecord() error = %v", err)
+		}
+	}
+
+	if got := m.hits.WithLabelValues("logins", "allowed").Value(); got != 2 {
+		t.Errorf("allowed hits = %d, want 2", got)
+	}
+	if got := m.hits.WithLabelValues("logins", "rejected").Value(); got != 1 {
+		t.Errorf("rejected hits = %d, want 1", got)
+	}
+}
@@ -0,0 +1,108 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"simplesurance/internal/logging"
+	"simplesurance/internal/tracing"
+)
+
+// WithLimit turns a counter into a rate limiter: once the window holds limit
+// hits, TryRecord rejects requests until there is room again. A limit of zero
+// disables the check, which only the sliding log supports.
+func WithLimit(limit int) Option {
+	return func(o *options) {
+		if limit > 0 {
+			o.limit = limit
+		}
+	}
+}
+
+// WithMaxDelay caps how long the leaky bucket holds a hit. A hit that would
+// wait longer is rejected, so the caller can retry instead of outwaiting the
+// server's write timeout. Zero leaves the delay unbounded.
+func WithMaxDelay(d time.Duration) Option {
+	return func(o *options) {
+		if d > 0 {
+			o.maxDelay = d
+		}
+	}
+}
+
+// Decision is the outcome of TryRecord.
+type Decision struct {
+	Allowed bool
+	// Count is the number of timestamps in the window after the decision.
+	Count int
+	// Limit is zero when the service has no limit.
+	Limit     int
+	Remaining int
+	// Reset is how long until the oldest hit stops counting; for a rejected
+	// hit, how long until one would be allowed.
+	Reset time.Duration
+	// Delay is how long an allowed hit should wait before proceeding. Only
+	// the leaky bucket sets it, to release queued hits at a constant rate.
+	Delay time.Duration
+}
+
+// RetryAfter is how long a rejected caller should wait before retrying.
+func (d Decision) RetryAfter() time.Duration {
+	if d.Allowed {
+		return 0
+	}
+	return d.Reset
+}
+
+// TryRecord records a timestamp unless the window is already at the limit, in
+// which case nothing is stored.
+func (s *TimestampService) TryRecord(ctx context.Context) (Decision, error) {
+	threshold, limit := s.settings()
+	if limit == 0 {
+		count, err := s.RecordTimestamp(ctx)
+		if err != nil {
+			return Decision{}, err
+		}
+		return Decision{Allowed: true, Count: count}, nil
+	}
+
+	ctx, span := tracing.Start(ctx, "counter.try_record")
+	defer span.End()
+
+	recorded, err := s.repo.Record(ctx, s.clock.Now, threshold, limit)
+	if err != nil {
+		return Decision{}, fmt.Errorf("failed to record timestamp: %w", err)
+	}
+
+	// Syncing outside Record lets concurrent callers share a group commit.
+	if recorded.Stored && !s.backgroundSync {
+		if err := s.repo.Sync(ctx); err != nil {
+			span.RecordError(err)
+			return Decision{}, fmt.Errorf("failed to sync timestamp: %w", err)
+		}
+	}
+
+	decision := Decision{
+		Allowed:   recorded.Stored,
+		Count:     recorded.Count,
+		Limit:     limit,
+		Remaining: max(limit-recorded.Count, 0),
+	}
+	if !recorded.Oldest.IsZero() {
+		decision.Reset = max(recorded.Oldest.Add(threshold).Sub(recorded.Timestamp), 0)
+	}
+	observeDecision(ctx, decision)
+	return decision, nil
+}
+
+// observeDecision annotates the current span with d and logs rejections.
+func observeDecision(ctx context.Context, d Decision) {
+	tracing.SpanFromContext(ctx).SetAttributes(
+		tracing.Attribute{Key: "counter.allowed", Value: d.Allowed},
+		tracing.Attribute{Key: "counter.count", Value: d.Count},
+	)
+	if !d.Allowed {
+		logging.FromContext(ctx).Debug("hit rejected", "count", d.Count, "limit", d.Limit, "retry_after", d.RetryAfter())
+	}
+}
@@ -0,0 +1,110 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"simplesurance/internal/clock"
+)
+
+func TestTimestampService_TryRecord(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	tests := []struct {
+		name       string
+		limit      int
+		timestamps []time.Time
+		storeErr   error
+		want       Decision
+		wantStored int
+		wantErr    bool
+	}{
+		{
+			name:       "no limit always allows",
+			timestamps: []time.Time{start.Add(-time.Second), start.Add(-time.Second)},
+			want:       Decision{Allowed: true, Count: 3},
+			wantStored: 3,
+		},
+		{
+			name:       "below limit",
+			limit:      3,
+			timestamps: []time.Time{start.Add(-45 * time.Second)},
+			want:       Decision{Allowed: true, Count: 2, Limit: 3, Remaining: 1, Reset: 15 * time.Second},
+			wantStored: 2,
+		},
+		{
+			name:       "empty window resets after a full threshold",
+			limit:      1,
+			want:       Decision{Allowed: true, Count: 1, Limit: 1, Remaining: 0, Reset: time.Minute},
+			wantStored: 1,
+		},
+		{
+			name:       "at limit rejects and stores nothing",
+			limit:      2,
+			timestamps: []time.Time{start.Add(-50 * time.Second), start.Add(-10 * time.Second)},
+			want:       Decision{Allowed: false, Count: 2, Limit: 2, Remaining: 0, Reset: 10 * time.Second},
+			wantStored: 2,
+		},
+		{
+			name:       "expired entries free up room",
+			limit:      2,
+			timestamps: []time.Time{start.Add(-time.Minute), start.Add(-1500 * time.Millisecond)},
+			want:       Decision{Allowed: true, Count: 2, Limit: 2, Remaining: 0, Reset: 58500 * time.Millisecond},
+			wantStored: 2,
+		},
+		{
+			name:     "store error",
+			limit:    2,
+			storeErr: errors.New("store failed"),
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &mockRepo{timestamps: tt.timestamps, storeErr: tt.storeErr}
+			service := NewTimestampService(repo, time.Minute, WithClock(clock.NewFake(start)), WithLimit(tt.limit))
+
+			got, err := service.TryRecord(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TryRecord() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("TryRecord() = %+v, want %+v", got, tt.want)
+			}
+			if len(repo.timestamps) != tt.wantStored {
+				t.Errorf("stored timestamps = %d, want %d", len(repo.timestamps), tt.wantStored)
+			}
+		})
+	}
+}
+
+func TestTimestampService_TryRecordRetryAfter(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1_700_000_000, 0))
+	service := NewTimestampService(&mockRepo{}, time.Second, WithClock(fake), WithLimit(2))
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if d, err := service.TryRecord(ctx); err != nil || !d.Allowed {
+			t.Fatalf("TryRecord() = %+v, %v, want allowed", d, err)
+		}
+		fake.Advance(300 * time.Millisecond)
+	}
+
+	rejected, err := service.TryRecord(ctx)
+	if err != nil {
+		t.Fatalf("TryRecord() error = %v", err)
+	}
+	if rejected.Allowed || rejected.RetryAfter() != 400*time.Millisecond {
+		t.Fatalf("TryRecord() = %+v, want rejected with RetryAfter 400ms", rejected)
+	}
+
+	fake.Advance(rejected.RetryAfter())
+	if d, err := service.TryRecord(ctx); err != nil || !d.Allowed {
+		t.Errorf("TryRecord() after RetryAfter = %+v, %v, want allowed", d, err)
+	}
+}
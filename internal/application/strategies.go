@@ -0,0 +1,340 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"simplesurance/internal/domain"
+	"simplesurance/internal/tracing"
+)
+
+// algorithm is the in-memory state of a strategy that keeps a fixed number of
+// integers instead of every timestamp. Times are Unix nanoseconds.
+type algorithm interface {
+	// try decides a hit at now and reports whether the state changed.
+	try(now int64) (Decision, bool)
+	count(now int64) int
+	state() []int64
+	// restore loads a saved state, or starts empty if state is missing or was
+	// written by another strategy.
+	restore(state []int64)
+}
+
+// Tags stored as the first state entry, so a file written by one strategy is
+// not misread by another after a configuration change.
+const (
+	tagFixedWindow int64 = iota + 1
+	tagSlidingWindow
+	tagTokenBucket
+	tagLeakyBucket
+)
+
+func newAlgorithm(strategy Strategy, limit int, threshold, maxDelay time.Duration) (algorithm, error) {
+	if threshold <= 0 {
+		return nil, fmt.Errorf("%w: strategy %q requires a positive threshold", domain.ErrInvalidInput, strategy)
+	}
+	window := int64(threshold)
+	switch strategy {
+	case StrategyFixedWindow:
+		return &fixedWindow{limit: limit, window: window}, nil
+	case StrategySlidingWindow:
+		return &slidingWindow{limit: limit, window: window}, nil
+	case StrategyTokenBucket:
+		return newGCRA(tagTokenBucket, limit, window), nil
+	case StrategyLeakyBucket:
+		a := newGCRA(tagLeakyBucket, limit, window)
+		a.maxDelay = int64(maxDelay)
+		return a, nil
+	}
+	return nil, fmt.Errorf("%w: unknown strategy %q", domain.ErrInvalidInput, strategy)
+}
+
+// stateCounter runs an algorithm and saves its state after every change, or
+// on Maintain with background sync.
+type stateCounter struct {
+	strategy       Strategy
+	alg            algorithm
+	store          domain.StateStore
+	clock          domain.Clock
+	backgroundSync bool
+	maxDelay       time.Duration
+	mu             sync.Mutex
+	// dirty is set while the state differs from the saved one. Guarded by mu.
+	dirty bool
+}
+
+func newStateCounter(strategy Strategy, alg algorithm, store domain.StateStore, clock domain.Clock) *stateCounter {
+	return &stateCounter{
+		strategy: strategy,
+		alg:      alg,
+		store:    store,
+		clock:    clock,
+	}
+}
+func (c *stateCounter) Initialize(ctx context.Context) error {
+	state, err := c.store.Load(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load state: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.alg.restore(state)
+	return nil
+}
+func (c *stateCounter) TryRecord(ctx context.Context) (Decision, error) {
+	ctx, span := tracing.Start(ctx, "counter.try_record")
+	defer span.End()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	decision, changed := c.alg.try(c.clock.Now().UnixNano())
+	if changed && c.backgroundSync {
+		c.dirty = true
+	} else if changed {
+		// Saving under mu keeps the file in step with the decisions made.
+		if err := c.store.Save(ctx, c.alg.state()); err != nil {
+			span.RecordError(err)
+			return Decision{}, fmt.Errorf("failed to save state: %w", err)
+		}
+	}
+	observeDecision(ctx, decision)
+	return decision, nil
+}
+func (c *stateCounter) Peek(ctx context.Context) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.alg.count(c.clock.Now().UnixNano()), nil
+}
+// Reconfigure carries the state over to an algorithm with the new
+// parameters, so hits already counted still count. Fixed and sliding
+// windows start over if the threshold moves their window boundaries.
+func (c *stateCounter) Reconfigure(threshold time.Duration, limit int) error {
+	if limit <= 0 {
+		return fmt.Errorf("%w: strategy %q requires a limit", domain.ErrInvalidInput, c.strategy)
+	}
+	alg, err := newAlgorithm(c.strategy, limit, threshold, c.maxDelay)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	alg.restore(c.alg.state())
+	if next, ok := alg.(*gcra); ok {
+		next.rescale(c.alg.(*gcra), c.clock.Now().UnixNano())
+	}
+	c.alg = alg
+	return nil
+}
+
+// Maintain saves the state if it changed. The algorithms expire hits as they
+// read the clock, so there is nothing else to do.
+func (c *stateCounter) Maintain(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.dirty {
+		return nil
+	}
+	if err := c.store.Save(ctx, c.alg.state()); err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	c.dirty = false
+	return nil
+}
+func (c *stateCounter) Close() error {
+	return errors.Join(c.Maintain(context.Background()), c.store.Close())
+}
+
+// fixedWindow counts hits in windows aligned to multiples of the threshold.
+type fixedWindow struct {
+	limit  int
+	window int64
+	start  int64
+	hits   int
+}
+
+func (a *fixedWindow) try(now int64) (Decision, bool) {
+	if start := alignDown(now, a.window); start != a.start {
+		a.start, a.hits = start, 0
+	}
+	d := Decision{Limit: a.limit, Reset: time.Duration(a.start + a.window - now)}
+	if a.hits >= a.limit {
+		d.Count = a.hits
+		return d, false
+	}
+	a.hits++
+	d.Allowed, d.Count, d.Remaining = true, a.hits, a.limit-a.hits
+	return d, true
+}
+func (a *fixedWindow) count(now int64) int {
+	if alignDown(now, a.window) != a.start {
+		return 0
+	}
+	return a.hits
+}
+func (a *fixedWindow) state() []int64 {
+	return []int64{tagFixedWindow, a.start, int64(a.hits)}
+}
+func (a *fixedWindow) restore(state []int64) {
+	a.start, a.hits = 0, 0
+	if len(state) == 3 && state[0] == tagFixedWindow {
+		a.start, a.hits = state[1], int(state[2])
+	}
+}
+
+// slidingWindow estimates the hits in the last threshold as the current
+// aligned window's hits plus the previous window's, weighted by overlap.
+type slidingWindow struct {
+	limit    int
+	window   int64
+	start    int64
+	current  int
+	previous int
+}
+
+// at returns the window start and counts as of now without changing the state.
+func (a *slidingWindow) at(now int64) (int64, int, int) {
+	start := alignDown(now, a.window)
+	switch start {
+	case a.start:
+		return a.start, a.current, a.previous
+	case a.start + a.window:
+		return start, 0, a.current
+	}
+	return start, 0, 0
+}
+func (a *slidingWindow) estimate(now, start int64, current, previous int) float64 {
+	overlap := float64(a.window-(now-start)) / float64(a.window)
+	return float64(previous)*overlap + float64(current)
+}
+func (a *slidingWindow) try(now int64) (Decision, bool) {
+	start, current, previous := a.at(now)
+	d := Decision{Limit: a.limit}
+	if a.estimate(now, start, current, previous) >= float64(a.limit) {
+		d.Count = a.limit
+		d.Reset = a.retryAfter(now, start, current, previous)
+		return d, false
+	}
+
+	a.start, a.current, a.previous = start, current+1, previous
+	d.Allowed = true
+	d.Count = min(int(a.estimate(now, start, a.current, previous)), a.limit)
+	d.Remaining = a.limit - d.Count
+	// The current window's hits stop counting once the next window ends.
+	d.Reset = time.Duration(start + 2*a.window - now)
+	return d, true
+}
+
+// retryAfter finds the first instant at which the estimate drops below the limit.
+func (a *slidingWindow) retryAfter(now, start int64, current, previous int) time.Duration {
+	window := float64(a.window)
+	limit := float64(a.limit)
+	elapsed := now - start
+	if current < a.limit {
+		// Within this window, once enough of the previous one has slid out.
+		at := int64(math.Ceil(window - (limit-float64(current))*window/float64(previous)))
+		return time.Duration(at - elapsed + 1)
+	}
+	// In the next window, once enough of this one has slid out.
+	at := int64(math.Ceil(window - limit*window/float64(current)))
+	return time.Duration(a.window - elapsed + at + 1)
+}
+func (a *slidingWindow) count(now int64) int {
+	start, current, previous := a.at(now)
+	return int(a.estimate(now, start, current, previous))
+}
+func (a *slidingWindow) state() []int64 {
+	return []int64{tagSlidingWindow, a.start, int64(a.current), int64(a.previous)}
+}
+func (a *slidingWindow) restore(state []int64) {
+	a.start, a.current, a.previous = 0, 0, 0
+	if len(state) == 4 && state[0] == tagSlidingWindow {
+		a.start, a.current, a.previous = state[1], int(state[2]), int(state[3])
+	}
+}
+
+// gcra implements both buckets with the generic cell rate algorithm: tat is
+// the theoretical arrival time, the instant at which the bucket is empty
+// again if no more hits arrive. Each hit adds one emission interval.
+//
+// As a token bucket, tat-now is the number of spent tokens times the
+// interval. As a leaky bucket it is the backlog of queued hits, and every
+// admitted hit is told to wait until its turn. A hit whose turn is more than
+// maxDelay away is rejected instead, if maxDelay is set.
+type gcra struct {
+	tag      int64
+	limit    int
+	interval int64
+	maxDelay int64
+	tat      int64
+}
+
+func newGCRA(tag int64, limit int, window int64) *gcra {
+	return &gcra{
+		tag:      tag,
+		limit:    limit,
+		interval: max(window/int64(limit), 1),
+	}
+}
+func (a *gcra) try(now int64) (Decision, bool) {
+	base := max(a.tat, now)
+	d := Decision{Limit: a.limit}
+	if slack := base - now - int64(a.limit-1)*a.interval; slack > 0 {
+		d.Count = a.count(now)
+		d.Reset = time.Duration(slack)
+		return d, false
+	}
+	if wait := base - now - a.maxDelay; a.tag == tagLeakyBucket && a.maxDelay > 0 && wait > 0 {
+		d.Count = a.count(now)
+		d.Reset = time.Duration(wait)
+		return d, false
+	}
+
+	a.tat = base + a.interval
+	d.Allowed = true
+	d.Count = a.count(now)
+	d.Remaining = a.limit - d.Count
+	d.Reset = time.Duration(a.tat - now)
+	if a.tag == tagLeakyBucket {
+		d.Delay = time.Duration(base - now)
+	}
+	return d, true
+}
+func (a *gcra) count(now int64) int {
+	backlog := max(a.tat-now, 0)
+	return int((backlog + a.interval - 1) / a.interval)
+}
+// rescale keeps the backlog restored from prev at the same number of hits,
+// which the new emission interval would otherwise stretch or shrink.
+func (a *gcra) rescale(prev *gcra, now int64) {
+	if backlog := a.tat - now; backlog > 0 {
+		a.tat = now + int64(float64(backlog)/float64(prev.interval)*float64(a.interval))
+	}
+}
+func (a *gcra) state() []int64 {
+	return []int64{a.tag, a.tat}
+}
+func (a *gcra) restore(state []int64) {
+	a.tat = 0
+	if len(state) == 2 && state[0] == a.tag {
+		a.tat = state[1]
+	}
+}
+
+// alignDown rounds t down to a multiple of step, also for negative t.
+func alignDown(t, step int64) int64 {
+	start := t - t%step
+	if t%step < 0 {
+		start -= step
+	}
+	return start
+}
@@ -3,18 +3,64 @@ package application
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
+	"simplesurance/internal/clock"
 	"simplesurance/internal/domain"
+	"simplesurance/internal/tracing"
 )
 type TimestampService struct {
-	repo      domain.TimestampRepository
-	threshold int
+	repo           domain.TimestampRepository
+	clock          domain.Clock
+	backgroundSync bool
+
+	settingsMu sync.RWMutex
+	threshold  time.Duration
+	limit      int
+}
+
+type options struct {
+	clock          domain.Clock
+	limit          int
+	maxDelay       time.Duration
+	metrics        *Metrics
+	backgroundSync bool
+}
+
+type Option func(*options)
+
+// WithClock replaces the wall clock used to timestamp and expire requests.
+func WithClock(c domain.Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+// WithBackgroundSync leaves syncing to Maintain, so hits no longer wait for
+// the disk. A crash loses the hits recorded since the last Maintain.
+func WithBackgroundSync() Option {
+	return func(o *options) {
+		o.backgroundSync = true
+	}
 }
-func NewTimestampService(repo domain.TimestampRepository, threshold int) *TimestampService {
+
+func newOptions(opts []Option) options {
+	o := options{clock: clock.System{}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func NewTimestampService(repo domain.TimestampRepository, threshold time.Duration, opts ...Option) *TimestampService {
+	o := newOptions(opts)
 	return &TimestampService{
-		repo:      repo,
-		threshold: threshold,
+		repo:           repo,
+		threshold:      threshold,
+		clock:          o.clock,
+		limit:          o.limit,
+		backgroundSync: o.backgroundSync,
 	}
 }
 func (s *TimestampService) Initialize(ctx context.Context) error {
@@ -22,8 +68,9 @@ func (s *TimestampService) Initialize(ctx context.Context) error {
 		return fmt.Errorf("failed to load timestamps: %w", err)
 	}
 
-	current := int(time.Now().Unix())
-	if err := s.repo.RemoveExpired(ctx, current, s.threshold); err != nil {
+	current := s.clock.Now()
+	threshold, _ := s.settings()
+	if err := s.repo.RemoveExpired(ctx, current, threshold); err != nil {
 		return fmt.Errorf("failed to remove expired timestamps: %w", err)
 	}
 
@@ -34,20 +81,67 @@ func (s *TimestampService) Initialize(ctx context.Context) error {
 	return nil
 }
 func (s *TimestampService) RecordTimestamp(ctx context.Context) (int, error) {
-	current := int(time.Now().Unix())
-	if err := s.repo.RemoveExpired(ctx, current, s.threshold); err != nil {
-		return 0, fmt.Errorf("failed to remove expired timestamps: %w", err)
-	}
-	if err := s.repo.Store(ctx, current); err != nil {
-		return 0, fmt.Errorf("failed to store timestamp: %w", err)
+	ctx, span := tracing.Start(ctx, "counter.record")
+	defer span.End()
+
+	threshold, _ := s.settings()
+	recorded, err := s.repo.Record(ctx, s.clock.Now, threshold, 0)
+	if err != nil {
+		return 0, fmt.Errorf("failed to record timestamp: %w", err)
 	}
-	if err := s.repo.Sync(ctx); err != nil {
-		return 0, fmt.Errorf("failed to sync timestamp: %w", err)
+	// Syncing outside Record lets concurrent callers share a group commit.
+	if !s.backgroundSync {
+		if err := s.repo.Sync(ctx); err != nil {
+			span.RecordError(err)
+			return 0, fmt.Errorf("failed to sync timestamp: %w", err)
+		}
 	}
-	count, err := s.repo.Count(ctx)
+
+	return recorded.Count, nil
+}
+
+// Peek returns the count without recording a hit. Expired entries are left
+// for the next hit or Maintain to remove, so reads share the store's lock.
+func (s *TimestampService) Peek(ctx context.Context) (int, error) {
+	threshold, _ := s.settings()
+	count, err := s.repo.CountUnexpired(ctx, s.clock.Now(), threshold)
 	if err != nil {
 		return 0, fmt.Errorf("failed to get count: %w", err)
 	}
 
 	return count, nil
 }
+
+// Maintain expires old entries and syncs the repository, so the log file
+// drops them even while no hits arrive.
+func (s *TimestampService) Maintain(ctx context.Context) error {
+	threshold, _ := s.settings()
+	if err := s.repo.RemoveExpired(ctx, s.clock.Now(), threshold); err != nil {
+		return fmt.Errorf("failed to remove expired timestamps: %w", err)
+	}
+	if err := s.repo.Sync(ctx); err != nil {
+		return fmt.Errorf("failed to sync timestamps: %w", err)
+	}
+	return nil
+}
+func (s *TimestampService) Close() error {
+	return s.repo.Close()
+}
+
+// Reconfigure takes effect from the next hit; the repository adapts to the
+// new threshold when it next expires entries.
+func (s *TimestampService) Reconfigure(threshold time.Duration, limit int) error {
+	if threshold <= 0 || limit < 0 {
+		return fmt.Errorf("%w: threshold must be positive and limit non-negative", domain.ErrInvalidInput)
+	}
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+
+	s.threshold, s.limit = threshold, limit
+	return nil
+}
+func (s *TimestampService) settings() (time.Duration, int) {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+	return s.threshold, s.limit
+}
@@ -5,17 +5,43 @@ import (
 	"errors"
 	"testing"
 	"time"
+
+	"simplesurance/internal/clock"
+	"simplesurance/internal/domain"
 )
 type mockRepo struct {
-	timestamps []int
+	timestamps []time.Time
 	storeErr   error
 	loadErr    error
 	syncErr    error
 	countErr   error
 	removeErr  error
+	syncs      int
 }
 
-func (m *mockRepo) Store(ctx context.Context, timestamp int) error {
+// Record composes the other methods, so their injected errors apply to it.
+func (m *mockRepo) Record(ctx context.Context, now func() time.Time, threshold time.Duration, limit int) (domain.Recorded, error) {
+	recorded := domain.Recorded{Timestamp: now()}
+	if err := m.RemoveExpired(ctx, recorded.Timestamp, threshold); err != nil {
+		return domain.Recorded{}, err
+	}
+	count, err := m.Count(ctx)
+	if err != nil {
+		return domain.Recorded{}, err
+	}
+	if limit <= 0 || count < limit {
+		if err := m.Store(ctx, recorded.Timestamp); err != nil {
+			return domain.Recorded{}, err
+		}
+		recorded.Stored = true
+		count++
+	}
+	recorded.Count = count
+	recorded.Oldest, _ = m.Oldest(ctx)
+	return recorded, nil
+}
+
+func (m *mockRepo) Store(ctx context.Context, timestamp time.Time) error {
 	if m.storeErr != nil {
 		return m.storeErr
 	}
@@ -23,8 +49,8 @@ func (m *mockRepo) Store(ctx context.Context, timestamp int) error {
 	return nil
 }
 
-func (m *mockRepo) View(ctx context.Context) ([]int, error) {
-	result := make([]int, len(m.timestamps))
+func (m *mockRepo) View(ctx context.Context) ([]time.Time, error) {
+	result := make([]time.Time, len(m.timestamps))
 	copy(result, m.timestamps)
 	return result, nil
 }
@@ -36,17 +62,40 @@ func (m *mockRepo) Count(ctx context.Context) (int, error) {
 	return len(m.timestamps), nil
 }
 
+func (m *mockRepo) CountUnexpired(ctx context.Context, current time.Time, threshold time.Duration) (int, error) {
+	if m.countErr != nil {
+		return 0, m.countErr
+	}
+	count := 0
+	for _, ts := range m.timestamps {
+		if current.Sub(ts) < threshold {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *mockRepo) Oldest(ctx context.Context) (time.Time, error) {
+	var oldest time.Time
+	for _, ts := range m.timestamps {
+		if oldest.IsZero() || ts.Before(oldest) {
+			oldest = ts
+		}
+	}
+	return oldest, nil
+}
+
 func (m *mockRepo) Load(ctx context.Context) error {
 	return m.loadErr
 }
 
-func (m *mockRepo) RemoveExpired(ctx context.Context, current, threshold int) error {
+func (m *mockRepo) RemoveExpired(ctx context.Context, current time.Time, threshold time.Duration) error {
 	if m.removeErr != nil {
 		return m.removeErr
 	}
-	var valid []int
+	var valid []time.Time
 	for _, ts := range m.timestamps {
-		if current-ts < threshold {
+		if current.Sub(ts) < threshold {
 			valid = append(valid, ts)
 		}
 	}
@@ -55,6 +104,7 @@ func (m *mockRepo) RemoveExpired(ctx context.Context, current, threshold int) er
 }
 
 func (m *mockRepo) Sync(ctx context.Context) error {
+	m.syncs++
 	return m.syncErr
 }
 
@@ -71,7 +121,7 @@ func TestTimestampService_Initialize(t *testing.T) {
 		{
 			name: "successful initialization",
 			mockRepo: &mockRepo{
-				timestamps: []int{int(time.Now().Unix()) - 100},
+				timestamps: []time.Time{time.Now().Add(-100 * time.Second)},
 			},
 			wantErr: false,
 		},
@@ -93,7 +143,7 @@ func TestTimestampService_Initialize(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			service := NewTimestampService(tt.mockRepo, 60)
+			service := NewTimestampService(tt.mockRepo, 60*time.Second)
 			ctx := context.Background()
 
 			err := service.Initialize(ctx)
@@ -105,7 +155,7 @@ func TestTimestampService_Initialize(t *testing.T) {
 }
 
 func TestTimestampService_RecordTimestamp(t *testing.T) {
-	now := int(time.Now().Unix())
+	now := time.Now()
 	tests := []struct {
 		name      string
 		mockRepo *mockRepo
@@ -115,7 +165,7 @@ func TestTimestampService_RecordTimestamp(t *testing.T) {
 		{
 			name: "successful record",
 			mockRepo: &mockRepo{
-				timestamps: []int{now - 30},
+				timestamps: []time.Time{now.Add(-30 * time.Second)},
 			},
 			wantCount: 2, // existing + new
 			wantErr:   false,
@@ -156,7 +206,7 @@ func TestTimestampService_RecordTimestamp(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			service := NewTimestampService(tt.mockRepo, 60)
+			service := NewTimestampService(tt.mockRepo, 60*time.Second)
 			ctx := context.Background()
 
 			count, err := service.RecordTimestamp(ctx)
@@ -171,3 +221,125 @@ func TestTimestampService_RecordTimestamp(t *testing.T) {
 		})
 	}
 }
+
+func TestTimestampService_ExpiryBoundary(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	tests := []struct {
+		name      string
+		threshold time.Duration
+		advance   time.Duration
+		wantCount int
+	}{
+		{
+			name:      "just inside the window",
+			threshold: 60 * time.Second,
+			advance:   60*time.Second - time.Nanosecond,
+			wantCount: 2,
+		},
+		{
+			name:      "exactly at the threshold",
+			threshold: 60 * time.Second,
+			advance:   60 * time.Second,
+			wantCount: 1,
+		},
+		{
+			name:      "millisecond window",
+			threshold: 1500 * time.Millisecond,
+			advance:   1499 * time.Millisecond,
+			wantCount: 2,
+		},
+		{
+			name:      "millisecond window expired",
+			threshold: 1500 * time.Millisecond,
+			advance:   1500 * time.Millisecond,
+			wantCount: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := clock.NewFake(start)
+			service := NewTimestampService(&mockRepo{}, tt.threshold, WithClock(fake))
+			ctx := context.Background()
+
+			if _, err := service.RecordTimestamp(ctx); err != nil {
+				t.Fatalf("RecordTimestamp() error = %v", err)
+			}
+			fake.Advance(tt.advance)
+			count, err := service.RecordTimestamp(ctx)
+			if err != nil {
+				t.Fatalf("RecordTimestamp() error = %v", err)
+			}
+			if count != tt.wantCount {
+				t.Errorf("RecordTimestamp() count = %v, want %v", count, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestTimestampService_InitializeDropsExpired(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1_700_000_000, 0))
+	repo := &mockRepo{
+		timestamps: []time.Time{fake.Now().Add(-61 * time.Second), fake.Now().Add(-59 * time.Second)},
+	}
+	service := NewTimestampService(repo, 60*time.Second, WithClock(fake))
+
+	if err := service.Initialize(context.Background()); err != nil {
+		t.Fatalf("Initialize() error = %v", err)
+	}
+	if len(repo.timestamps) != 1 {
+		t.Errorf("timestamps after Initialize() = %v, want 1 entry", repo.timestamps)
+	}
+}
+
+func TestTimestampService_Peek(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1_700_000_000, 0))
+	tests := []struct {
+		name       string
+		mockRepo   *mockRepo
+		wantCount  int
+		wantStored int
+		wantErr    bool
+	}{
+		{
+			name: "skips expired without removing them",
+			mockRepo: &mockRepo{
+				timestamps: []time.Time{fake.Now().Add(-90 * time.Second), fake.Now().Add(-30 * time.Second)},
+			},
+			wantCount:  1,
+			wantStored: 2,
+		},
+		{
+			name:      "empty window",
+			mockRepo:  &mockRepo{},
+			wantCount: 0,
+		},
+		{
+			name: "count error",
+			mockRepo: &mockRepo{
+				countErr: errors.New("count failed"),
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewTimestampService(tt.mockRepo, 60*time.Second, WithClock(fake))
+
+			count, err := service.Peek(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Peek() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && count != tt.wantCount {
+				t.Errorf("Peek() count = %v, want %v", count, tt.wantCount)
+			}
+			if tt.mockRepo.syncs != 0 {
+				t.Errorf("Sync() calls = %v, want 0", tt.mockRepo.syncs)
+			}
+			if len(tt.mockRepo.timestamps) != tt.wantStored {
+				t.Errorf("stored timestamps = %v, want %v", len(tt.mockRepo.timestamps), tt.wantStored)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"simplesurance/internal/clock"
+	"simplesurance/internal/domain"
+	"simplesurance/internal/logging"
+)
+
+const DefaultWorkerInterval = time.Second
+
+// stalledPasses is how many intervals may go by without a finished pass
+// before the worker counts as stuck.
+const stalledPasses = 3
+
+// Worker calls Maintain on counters at a fixed interval, so windows expire
+// while no hits arrive and, with WithBackgroundSync, hits need not wait for
+// the disk. Failed syncs reach Health through the stores' sync observers; a
+// worker that stops finishing passes fails its liveness check.
+type Worker struct {
+	interval time.Duration
+	clock    domain.Clock
+	metrics  *Metrics
+
+	mu         sync.Mutex
+	counters   map[string]Counter
+	registries []*CounterRegistry
+	lastPass   time.Time
+
+	stop chan struct{}
+	done chan struct{}
+	// cancel aborts the pass in progress when Stop gives up waiting.
+	cancel context.CancelFunc
+}
+
+type WorkerOption func(*Worker)
+
+// WithWorkerClock replaces the wall clock that ticks the worker.
+func WithWorkerClock(c domain.Clock) WorkerOption {
+	return func(w *Worker) {
+		w.clock = c
+	}
+}
+
+// WithWorkerMetrics counts the worker's passes and times them.
+func WithWorkerMetrics(m *Metrics) WorkerOption {
+	return func(w *Worker) {
+		w.metrics = m
+	}
+}
+
+func NewWorker(interval time.Duration, opts ...WorkerOption) *Worker {
+	if interval <= 0 {
+		interval = DefaultWorkerInterval
+	}
+	w := &Worker{
+		interval: interval,
+		clock:    clock.System{},
+		counters: make(map[string]Counter),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
+}
+
+// Watch adds a counter to every pass under the given name.
+func (w *Worker) Watch(name string, counter Counter) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.counters[name] = counter
+}
+
+// WatchRegistry adds the counters open in registry at the time of each pass.
+func (w *Worker) WatchRegistry(registry *CounterRegistry) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.registries = append(w.registries, registry)
+}
+
+// Start runs passes in a goroutine until Stop.
+func (w *Worker) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	w.mu.Lock()
+	w.stop = make(chan struct{})
+	w.done = make(chan struct{})
+	w.cancel = cancel
+	w.lastPass = w.clock.Now()
+	w.mu.Unlock()
+
+	go w.run(ctx, w.clock.NewTicker(w.interval))
+}
+
+// Stop waits for the pass in progress to finish, or cancels it once ctx
+// ends. Counters sync on Close, so nothing is lost by skipping later passes.
+func (w *Worker) Stop(ctx context.Context) error {
+	close(w.stop)
+	select {
+	case <-w.done:
+		w.cancel()
+		return nil
+	case <-ctx.Done():
+		w.cancel()
+		<-w.done
+		return fmt.Errorf("maintenance pass did not finish: %w", ctx.Err())
+	}
+}
+
+func (w *Worker) run(ctx context.Context, ticker domain.Ticker) {
+	defer close(w.done)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C():
+			w.pass(ctx)
+		}
+	}
+}
+
+// pass runs one supervised RunOnce: a panic fails the pass instead of
+// ending the worker.
+func (w *Worker) pass(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, stalledPasses*w.interval)
+	defer cancel()
+
+	start := w.clock.Now()
+	var err error
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("maintenance pass panicked: %v", r)
+				logging.FromContext(ctx).Error("maintenance pass panicked", "panic", r)
+			}
+		}()
+		err = w.RunOnce(ctx)
+	}()
+	w.metrics.observePass(w.clock.Now().Sub(start), err)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.lastPass = w.clock.Now()
+}
+
+// RunOnce maintains every watched counter, including those that fail
+// before it, and returns their errors.
+func (w *Worker) RunOnce(ctx context.Context) error {
+	counters := w.snapshot()
+	names := make([]string, 0, len(counters))
+	for name := range counters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		if err := counters[name].Maintain(ctx); err != nil {
+			logging.FromContext(ctx).Warn("failed to maintain counter", "counter", name, "error", err)
+			errs = append(errs, fmt.Errorf("counter %q: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+func (w *Worker) snapshot() map[string]Counter {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	counters := make(map[string]Counter, len(w.counters))
+	for _, registry := range w.registries {
+		for name, counter := range registry.Counters() {
+			counters[name] = counter
+		}
+	}
+	for name, counter := range w.counters {
+		counters[name] = counter
+	}
+	return counters
+}
+
+// Check is a liveness check that fails once no pass has finished for
+// several intervals, e.g. because a sync hangs. It passes until Start.
+func (w *Worker) Check(ctx context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.lastPass.IsZero() {
+		return nil
+	}
+	if since := w.clock.Now().Sub(w.lastPass); since > stalledPasses*w.interval {
+		return fmt.Errorf("no maintenance pass finished in %v", since.Round(time.Millisecond))
+	}
+	return nil
+}
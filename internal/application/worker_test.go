@@ -0,0 +1,108 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"simplesurance/internal/clock"
+	"simplesurance/internal/domain"
+	"simplesurance/internal/metrics"
+)
+
+type panickingCounter struct {
+	Counter
+}
+
+func (panickingCounter) Maintain(ctx context.Context) error {
+	panic("corrupt window")
+}
+
+func TestWorker_RunOnce(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1_700_000_000, 0))
+	ctx := context.Background()
+	repo := &mockRepo{}
+	store := &mockStateStore{}
+	storage := Storage{
+		Timestamps: func(string) (domain.TimestampRepository, error) { return repo, nil },
+		State:      func(string) (domain.StateStore, error) { return store, nil },
+	}
+	opts := []Option{WithClock(fake), WithLimit(5), WithBackgroundSync()}
+	log, err := NewCounter("log", CounterSpec{Threshold: time.Minute}, storage, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket, err := NewCounter("bucket", CounterSpec{Strategy: StrategyTokenBucket, Threshold: time.Minute}, storage, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	broken, err := NewCounter("broken", CounterSpec{Threshold: time.Minute}, Storage{
+		Timestamps: func(string) (domain.TimestampRepository, error) {
+			return &mockRepo{syncErr: errors.New("disk full")}, nil
+		},
+	}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, counter := range []Counter{log, bucket} {
+		tryRecord(t, counter)
+	}
+	if repo.syncs != 0 || store.state != nil {
+		t.Fatalf("hits synced on the request path: %d syncs, state %v", repo.syncs, store.state)
+	}
+
+	w := NewWorker(time.Second, WithWorkerClock(fake))
+	w.Watch("broken", broken)
+	w.Watch("bucket", bucket)
+	w.Watch("log", log)
+	fake.Advance(time.Minute)
+	err = w.RunOnce(ctx)
+	if err == nil || !strings.Contains(err.Error(), `counter "broken"`) {
+		t.Errorf("RunOnce() error = %v, want the broken counter's error", err)
+	}
+	if repo.syncs != 1 || len(repo.timestamps) != 0 {
+		t.Errorf("after RunOnce(): %d syncs, %d timestamps, want 1 sync of an expired window", repo.syncs, len(repo.timestamps))
+	}
+	if store.state == nil {
+		t.Error("RunOnce() did not save the token bucket's state")
+	}
+
+	store.state = nil
+	w.RunOnce(ctx)
+	if store.state != nil {
+		t.Error("RunOnce() saved an unchanged state")
+	}
+}
+
+func TestWorker_Supervision(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1_700_000_000, 0))
+	m := NewMetrics(metrics.NewRegistry())
+	w := NewWorker(time.Second, WithWorkerClock(fake), WithWorkerMetrics(m))
+	w.Watch("panics", panickingCounter{})
+
+	w.Start()
+	failed := m.passes.WithLabelValues("failed")
+	for want := uint64(1); want <= 2; want++ {
+		fake.Advance(time.Second)
+		deadline := time.Now().Add(time.Second)
+		for failed.Value() < want && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if got := failed.Value(); got != want {
+			t.Fatalf("failed passes = %d, want %d; a panic must not end the worker", got, want)
+		}
+	}
+	if err := w.Check(context.Background()); err != nil {
+		t.Errorf("Check() error = %v while passes finish", err)
+	}
+	if err := w.Stop(context.Background()); err != nil {
+		t.Errorf("Stop() error = %v", err)
+	}
+
+	fake.Advance(4 * time.Second)
+	if err := w.Check(context.Background()); err == nil {
+		t.Error("Check() error = nil, want a stalled worker to fail")
+	}
+}
@@ -0,0 +1,190 @@
+// Package certs serves TLS certificates that are reloaded from disk when
+// their files change, so they can be rotated without a restart.
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"simplesurance/internal/clock"
+	"simplesurance/internal/domain"
+	"simplesurance/internal/logging"
+)
+
+const DefaultWatchInterval = 5 * time.Second
+
+// Reloader holds a certificate and key, and optionally the CAs that client
+// certificates must chain to, as last loaded from their files. A load that
+// fails, e.g. because the certificate was replaced before its key, keeps the
+// previous files in use and is retried on the next check.
+type Reloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+	clock        domain.Clock
+	interval     time.Duration
+
+	// mu serializes reloads; handshakes only read the atomics.
+	mu        sync.Mutex
+	stamp     string
+	cert      atomic.Pointer[tls.Certificate]
+	clientCAs atomic.Pointer[x509.CertPool]
+}
+
+type Option func(*Reloader)
+
+// WithClock replaces the wall clock that ticks Watch.
+func WithClock(c domain.Clock) Option {
+	return func(r *Reloader) {
+		r.clock = c
+	}
+}
+
+// WithWatchInterval sets how often Watch checks the files for changes.
+func WithWatchInterval(d time.Duration) Option {
+	return func(r *Reloader) {
+		if d > 0 {
+			r.interval = d
+		}
+	}
+}
+
+// NewReloader loads the certificate and key, and the client CAs unless
+// clientCAFile is empty.
+func NewReloader(certFile, keyFile, clientCAFile string, opts ...Option) (*Reloader, error) {
+	r := &Reloader{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+		clock:        clock.System{},
+		interval:     DefaultWatchInterval,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if _, err := r.Reload(context.Background()); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload loads the files again if any of them changed since the last
+// successful load, and reports whether it did.
+func (r *Reloader) Reload(ctx context.Context) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stamp, err := r.fileStamp()
+	if err != nil {
+		return false, err
+	}
+	if stamp == r.stamp {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to load certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return false, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+	cert.Leaf = leaf
+	var clientCAs *x509.CertPool
+	if r.clientCAFile != "" {
+		if clientCAs, err = loadCertPool(r.clientCAFile); err != nil {
+			return false, err
+		}
+	}
+
+	r.cert.Store(&cert)
+	r.clientCAs.Store(clientCAs)
+	r.stamp = stamp
+	logging.FromContext(ctx).Info("loaded TLS certificate", "file", r.certFile,
+		"subject", leaf.Subject.String(), "not_after", leaf.NotAfter)
+	return true, nil
+}
+
+// fileStamp identifies the current contents of the files by their size and
+// modification time. Stat follows symlinks, so a mounted secret whose link is
+// swapped to new files counts as changed.
+func (r *Reloader) fileStamp() (string, error) {
+	var stamp strings.Builder
+	for _, fileName := range []string{r.certFile, r.keyFile, r.clientCAFile} {
+		if fileName == "" {
+			continue
+		}
+		info, err := os.Stat(fileName)
+		if err != nil {
+			return "", fmt.Errorf("failed to check TLS file: %w", err)
+		}
+		fmt.Fprintf(&stamp, "%d:%d;", info.ModTime().UnixNano(), info.Size())
+	}
+	return stamp.String(), nil
+}
+func loadCertPool(fileName string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("failed to load client CA file: no PEM certificates found")
+	}
+	return pool, nil
+}
+
+// Watch reloads the files whenever they change until ctx ends. Failures are
+// logged and retried on the next check.
+func (r *Reloader) Watch(ctx context.Context) {
+	ticker := r.clock.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			if _, err := r.Reload(ctx); err != nil {
+				logging.FromContext(ctx).Warn("failed to reload TLS certificate, keeping the previous one", "error", err)
+			}
+		}
+	}
+}
+
+// GetCertificate returns the certificate last loaded, for tls.Config.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+// TLSConfig returns a server configuration that presents the current
+// certificate and, with a client CA file, requires client certificates that
+// chain to the current CAs.
+func (r *Reloader) TLSConfig(minVersion uint16) *tls.Config {
+	config := &tls.Config{
+		MinVersion:     minVersion,
+		GetCertificate: r.GetCertificate,
+		// Set here since the per-client copies below would miss the "h2"
+		// that http.Server adds to its own copy.
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+	if r.clientCAFile == "" {
+		return config
+	}
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	base := config.Clone()
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		c := base.Clone()
+		c.ClientCAs = r.clientCAs.Load()
+		return c, nil
+	}
+	return config
+}
@@ -0,0 +1,192 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type keyPair struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// issue creates a certificate for name, signed by parent or self-signed as a
+// CA if parent is nil.
+func issue(t *testing.T, name string, parent *keyPair) *keyPair {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &keyPair{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+// writeFile writes content with a modification time of at, so rewrites are
+// noticed however quickly they follow each other.
+func writeFile(t *testing.T, fileName string, content []byte, at time.Time) {
+	t.Helper()
+	if err := os.WriteFile(fileName, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(fileName, at, at); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloader_Reload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	ctx := context.Background()
+	start := time.Now()
+
+	first := issue(t, "first.test", nil)
+	writeFile(t, certFile, first.certPEM, start)
+	writeFile(t, keyFile, first.keyPEM, start)
+	r, err := NewReloader(certFile, keyFile, "")
+	if err != nil {
+		t.Fatalf("NewReloader() error = %v", err)
+	}
+	serving := func() string {
+		cert, _ := r.GetCertificate(nil)
+		return cert.Leaf.Subject.CommonName
+	}
+
+	if reloaded, err := r.Reload(ctx); reloaded || err != nil {
+		t.Errorf("Reload() of unchanged files = %v, %v, want false, nil", reloaded, err)
+	}
+
+	// The certificate is replaced before its key: the mismatched pair is
+	// rejected and the first one kept until the key follows.
+	second := issue(t, "second.test", nil)
+	writeFile(t, certFile, second.certPEM, start.Add(time.Second))
+	if _, err := r.Reload(ctx); err == nil {
+		t.Error("Reload() error = nil with a mismatched key")
+	}
+	if got := serving(); got != "first.test" {
+		t.Errorf("serving %s after a failed reload, want first.test", got)
+	}
+
+	writeFile(t, keyFile, second.keyPEM, start.Add(2*time.Second))
+	if reloaded, err := r.Reload(ctx); !reloaded || err != nil {
+		t.Fatalf("Reload() = %v, %v, want true, nil", reloaded, err)
+	}
+	if got := serving(); got != "second.test" {
+		t.Errorf("serving %s after reload, want second.test", got)
+	}
+}
+
+func TestReloader_TLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+	start := time.Now()
+
+	ca := issue(t, "ca.test", nil)
+	server := issue(t, "server.test", ca)
+	writeFile(t, certFile, server.certPEM, start)
+	writeFile(t, keyFile, server.keyPEM, start)
+	writeFile(t, caFile, ca.certPEM, start)
+	r, err := NewReloader(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatalf("NewReloader() error = %v", err)
+	}
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.TLS = r.TLSConfig(tls.VersionTLS13)
+	ts.StartTLS()
+	defer ts.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	client := issue(t, "client.test", ca)
+	clientCert, err := tls.X509KeyPair(client.certPEM, client.keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	strangerPair := issue(t, "stranger.test", issue(t, "other-ca.test", nil))
+	stranger, err := tls.X509KeyPair(strangerPair.certPEM, strangerPair.keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		certs      []tls.Certificate
+		maxVersion uint16
+		wantErr    bool
+	}{
+		{name: "client certificate from the CA", certs: []tls.Certificate{clientCert}},
+		{name: "no client certificate", wantErr: true},
+		{name: "client certificate from another CA", certs: []tls.Certificate{stranger}, wantErr: true},
+		{name: "below the minimum version", certs: []tls.Certificate{clientCert}, maxVersion: tls.VersionTLS12, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+				RootCAs:      roots,
+				ServerName:   "server.test",
+				Certificates: tt.certs,
+				MaxVersion:   tt.maxVersion,
+			}}}
+			resp, err := c.Get(ts.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GET error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package clock
+
+import (
+	"time"
+
+	"simplesurance/internal/domain"
+)
+
+// System reads the wall clock.
+type System struct{}
+
+func (System) Now() time.Time {
+	return time.Now()
+}
+func (System) NewTicker(d time.Duration) domain.Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+type systemTicker struct {
+	*time.Ticker
+}
+
+func (t systemTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
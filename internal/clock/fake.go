@@ -0,0 +1,101 @@
+package clock
+
+import (
+	"sync"
+	"time"
+
+	"simplesurance/internal/domain"
+)
+
+// Fake is a Clock that only moves when Advance or Set is called. Tickers
+// created from it fire as the fake time passes their deadlines and, like
+// time.Ticker, drop ticks the receiver is too slow to take.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.now
+}
+func (f *Fake) NewTicker(d time.Duration) domain.Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t := &fakeTicker{
+		clock:  f,
+		c:      make(chan time.Time, 1),
+		period: d,
+		next:   f.now.Add(d),
+	}
+	f.tickers = append(f.tickers, t)
+	return t
+}
+
+// Advance moves the clock forward by d and fires any tickers that became due.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.setLocked(f.now.Add(d))
+}
+
+// Set moves the clock to now, which must not be before the current fake time.
+func (f *Fake) Set(now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.setLocked(now)
+}
+
+func (f *Fake) setLocked(now time.Time) {
+	if now.Before(f.now) {
+		panic("clock: fake time cannot move backwards")
+	}
+	f.now = now
+	for _, t := range f.tickers {
+		for !t.next.After(now) {
+			select {
+			case t.c <- t.next:
+			default:
+			}
+			t.next = t.next.Add(t.period)
+		}
+	}
+}
+
+func (f *Fake) remove(t *fakeTicker) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i, other := range f.tickers {
+		if other == t {
+			f.tickers = append(f.tickers[:i], f.tickers[i+1:]...)
+			return
+		}
+	}
+}
+
+type fakeTicker struct {
+	clock  *Fake
+	c      chan time.Time
+	period time.Duration
+	next   time.Time
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+func (t *fakeTicker) Stop() {
+	t.clock.remove(t)
+}
@@ -0,0 +1,53 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFake_Advance(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	clock := NewFake(start)
+
+	clock.Advance(1500 * time.Millisecond)
+	if got, want := clock.Now(), start.Add(1500*time.Millisecond); !got.Equal(want) {
+		t.Errorf("Now() = %v, want %v", got, want)
+	}
+}
+
+func TestFake_Ticker(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	clock := NewFake(start)
+	ticker := clock.NewTicker(time.Second)
+
+	clock.Advance(999 * time.Millisecond)
+	select {
+	case tick := <-ticker.C():
+		t.Fatalf("ticker fired early at %v", tick)
+	default:
+	}
+
+	// Ticks the receiver missed are dropped, as with time.Ticker.
+	clock.Advance(2 * time.Second)
+	select {
+	case tick := <-ticker.C():
+		if want := start.Add(time.Second); !tick.Equal(want) {
+			t.Errorf("tick = %v, want %v", tick, want)
+		}
+	default:
+		t.Fatal("ticker did not fire")
+	}
+	select {
+	case tick := <-ticker.C():
+		t.Fatalf("unexpected second tick %v", tick)
+	default:
+	}
+
+	ticker.Stop()
+	clock.Advance(time.Hour)
+	select {
+	case tick := <-ticker.C():
+		t.Fatalf("stopped ticker fired at %v", tick)
+	default:
+	}
+}
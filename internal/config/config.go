@@ -1,42 +1,650 @@
 package config
 
 import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"net"
+	"net/netip"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
+
+	"simplesurance/internal/domain"
 )
+// WriteTimeout is how long the server has to answer a request. Requests held
+// by the leaky bucket must be answered within it too.
+const WriteTimeout = 10 * time.Second
+
 type Config struct {
 	Filename  string
 	Address   string
 	Route     string
 	Port      string
-	Threshold int
+	Threshold time.Duration
+	Limit     int
+	// MaxWait caps how long the leaky bucket holds a request, below
+	// WriteTimeout so the response still gets out.
+	MaxWait time.Duration
+	// AdminAddr is the address of the plaintext listener for POST
+	// /admin/reload and the probes, or empty for none.
+	AdminAddr string
+
+	PersistenceMode    string
+	CompactInterval    time.Duration
+	Durability         string
+	DurabilityInterval time.Duration
+	Recovery           string
+	Format             string
+	Store              string
+	BucketSize         time.Duration
+	// Shards is the number of shards of the sharded store, or 0 for one per
+	// GOMAXPROCS.
+	Shards int
+	// SyncMode is "request" to sync every hit before answering, or
+	// "background" to leave syncing to the maintenance worker.
+	SyncMode       string
+	WorkerInterval time.Duration
+
+	Strategy           string
+	Counters           map[string]Counter
+	AutoCreateCounters bool
+	MaxCounters        int
+
+	// KeyBy is "ip", "header" or "query" when the main route counts every
+	// client separately, with KeyName naming the header or query parameter.
+	KeyBy          string
+	KeyName        string
+	TrustedProxies []netip.Prefix
+	MaxKeys        int
+
+	// TLSCertFile and TLSKeyFile turn on HTTPS; plain HTTP is not served
+	// then. With TLSClientCAFile, clients must present a certificate.
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSClientCAFile string
+	TLSMinVersion   uint16
+	// RequireTLS refuses to start without TLSCertFile, so a missing
+	// setting cannot silently serve plain HTTP.
+	RequireTLS bool
+
+	MaxSyncFailures int
+	ShutdownDelay   time.Duration
+
+	LogFormat string
+	LogLevel  string
+
+	// TraceExporter is "otlp" or "file" to export spans, or empty to only
+	// propagate trace IDs.
+	TraceExporter    string
+	TraceEndpoint    string
+	TraceFile        string
+	TraceServiceName string
+
+	// values holds the raw value of every setting, by environment variable.
+	values map[string]string
+}
+// Counter is a named counter declared in COUNTERS. Empty fields use the
+// global THRESHOLD and STRATEGY.
+type Counter struct {
+	Threshold time.Duration
+	Strategy  string
+}
+
+// setting is a configuration value, named by its environment variable. The
+// config file uses the lowercase name, e.g. "persistence_mode", and the
+// command line the lowercase name with dashes, e.g. --persistence-mode.
+type setting struct {
+	env          string
+	defaultValue string
+	usage        string
+}
+
+var settings = []setting{
+	{"FILENAME", "timestamps.log", "file the main counter is kept in"},
+	{"ADDRESS", "localhost", "host name shown in the startup log"},
+	{"ROUTE", "/", "route that records hits"},
+	{"PORT", "8000", "port to listen on"},
+	{"ADMIN_ADDR", "127.0.0.1:8001", "address of the plaintext admin and probe listener, or empty for none"},
+	{"THRESHOLD", "60", "window length, in seconds or as a duration"},
+	{"LIMIT", "0", "maximum hits per window, or 0 for no limit"},
+	{"STRATEGY", "sliding-log", "counting algorithm"},
+	{"MAX_WAIT", "5s", "longest the leaky bucket holds a request before rejecting it instead"},
+	{"STORE", "ring", "in-memory layout: slice, ring, bucket or sharded"},
+	{"BUCKET_SIZE", "1", "bucket width for the bucket store"},
+	{"SHARDS", "0", "shards of the sharded store, or 0 for one per CPU"},
+	{"PERSISTENCE_MODE", "rewrite", "rewrite or wal"},
+	{"COMPACT_INTERVAL", "1m", "how often the log is compacted in wal mode"},
+	{"SYNC_MODE", "request", "sync every hit before answering (request) or from the worker (background)"},
+	{"WORKER_INTERVAL", "1s", "how often expired hits are dropped and counters synced in the background"},
+	{"DURABILITY", "always", "always, batch, interval=<duration> or none"},
+	{"RECOVERY", "quarantine", "strict or quarantine"},
+	{"FORMAT", "binary", "text or binary"},
+	{"COUNTERS", "", "named counters, e.g. logins=30s:token-bucket,signups"},
+	{"AUTO_CREATE_COUNTERS", "false", "create undeclared counters on first request"},
+	{"MAX_COUNTERS", "100", "maximum number of open named counters"},
+	{"KEY_BY", "", "count clients separately: ip, header:<name> or query:<name>"},
+	{"TRUSTED_PROXIES", "", "addresses and prefixes whose X-Forwarded-For is trusted"},
+	{"MAX_KEYS", "10000", "maximum number of clients counted at once"},
+	{"LOG_FORMAT", "text", "text or json"},
+	{"LOG_LEVEL", "info", "debug, info, warn or error"},
+	{"TRACE_EXPORTER", "", "otlp, file, or empty to export nothing"},
+	{"TRACE_ENDPOINT", "http://localhost:4318/v1/traces", "OTLP/HTTP traces endpoint"},
+	{"TRACE_FILE", "traces.jsonl", "file spans are appended to"},
+	{"TRACE_SERVICE_NAME", "go-http-server", "service.name reported with spans"},
+	{"TLS_CERT_FILE", "", "PEM certificate chain to serve HTTPS with"},
+	{"TLS_KEY_FILE", "", "PEM private key of TLS_CERT_FILE"},
+	{"TLS_CLIENT_CA_FILE", "", "PEM CAs that client certificates must chain to, for mutual TLS"},
+	{"TLS_MIN_VERSION", "1.2", "minimum TLS version: 1.2 or 1.3"},
+	{"REQUIRE_TLS", "false", "refuse to start without TLS_CERT_FILE or with ADMIN_ADDR off loopback"},
+	{"MAX_SYNC_FAILURES", "3", "failed syncs of one file in a row after which /readyz fails"},
+	{"SHUTDOWN_DELAY", "0s", "how long to keep serving before shutting down"},
+}
+
+func (s setting) fileKey() string {
+	return strings.ToLower(s.env)
+}
+func (s setting) flagName() string {
+	return strings.ReplaceAll(strings.ToLower(s.env), "_", "-")
+}
+
+// Load reads the configuration from the command line args, the environment
+// and the file named by --config, in that order of precedence, falling back
+// to defaults. It reports every invalid value at once.
+func Load(args []string) (*Config, error) {
+	values, err := lookup(args)
+	if err != nil {
+		return nil, err
+	}
+	return parse(values)
+}
+
+// lookup merges the raw values of every setting. Environment variables that
+// are set but empty count as set, so they are validated rather than replaced
+// by a default.
+func lookup(args []string) (map[string]string, error) {
+	flags := flag.NewFlagSet("server", flag.ContinueOnError)
+	configFile := flags.String("config", "", "JSON config file")
+	flagValues := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		flagValues[s.env] = flags.String(s.flagName(), s.defaultValue, s.usage+" (env "+s.env+")")
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", flags.Arg(0))
+	}
+
+	values := make(map[string]string, len(settings))
+	for _, s := range settings {
+		values[s.env] = s.defaultValue
+	}
+	if *configFile != "" {
+		if err := readFile(*configFile, values); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(s.env); ok {
+			values[s.env] = value
+		}
+	}
+	flags.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flagName() == f.Name {
+				values[s.env] = *flagValues[s.env]
+			}
+		}
+	})
+	return values, nil
+}
+
+// readFile overlays values with a JSON object keyed by lowercase setting
+// names. Values may be strings, numbers or booleans, and lists of strings
+// for COUNTERS and TRUSTED_PROXIES.
+func readFile(fileName string, values map[string]string) error {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", fileName, err)
+	}
+
+	keys := make(map[string]string, len(settings))
+	for _, s := range settings {
+		keys[s.fileKey()] = s.env
+	}
+	var errs []error
+	for key, value := range raw {
+		env, ok := keys[key]
+		if !ok {
+			errs = append(errs, fmt.Errorf("unknown setting %q in config file", key))
+			continue
+		}
+		str, err := fileValue(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s in config file: %w", key, err))
+			continue
+		}
+		values[env] = str
+	}
+	return errors.Join(errs...)
 }
-func Load() (*Config, error) {
+func fileValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			str, ok := item.(string)
+			if !ok {
+				return "", fmt.Errorf("list items must be strings, got %v", item)
+			}
+			items = append(items, str)
+		}
+		return strings.Join(items, ","), nil
+	}
+	return "", fmt.Errorf("unsupported value %v", value)
+}
+
+func parse(values map[string]string) (*Config, error) {
 	cfg := &Config{
-		Filename:  getEnv("FILENAME", "timestamps.log"),
-		Address:   getEnv("ADDRESS", "localhost"),
-		Route:     getEnv("ROUTE", "/"),
-		Port:      getEnv("PORT", "8000"),
-		Threshold: 60,
+		Filename:  values["FILENAME"],
+		Address:   values["ADDRESS"],
+		Route:     values["ROUTE"],
+		Port:      values["PORT"],
+		AdminAddr: values["ADMIN_ADDR"],
+
+		PersistenceMode: values["PERSISTENCE_MODE"],
+		Recovery:        values["RECOVERY"],
+		Format:          values["FORMAT"],
+		Store:           values["STORE"],
+		SyncMode:        values["SYNC_MODE"],
+		Strategy:        values["STRATEGY"],
+		LogFormat:       values["LOG_FORMAT"],
+		LogLevel:        values["LOG_LEVEL"],
+
+		TraceExporter:    values["TRACE_EXPORTER"],
+		TraceEndpoint:    values["TRACE_ENDPOINT"],
+		TraceFile:        values["TRACE_FILE"],
+		TraceServiceName: values["TRACE_SERVICE_NAME"],
+
+		TLSCertFile:     values["TLS_CERT_FILE"],
+		TLSKeyFile:      values["TLS_KEY_FILE"],
+		TLSClientCAFile: values["TLS_CLIENT_CA_FILE"],
+
+		values: values,
+	}
+	var errs []error
+
+	if port, err := strconv.Atoi(cfg.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("invalid port %q: must be between 1 and 65535", cfg.Port))
+	}
+	if cfg.AdminAddr != "" {
+		if _, port, err := net.SplitHostPort(cfg.AdminAddr); err != nil || port == cfg.Port {
+			errs = append(errs, fmt.Errorf("invalid admin address %q: must be host:port, on another port than PORT", cfg.AdminAddr))
+		}
+	}
+	if !strings.HasPrefix(cfg.Route, "/") {
+		errs = append(errs, fmt.Errorf("invalid route %q: must start with \"/\"", cfg.Route))
+	}
+	if err := checkWritable(cfg.Filename); err != nil {
+		errs = append(errs, fmt.Errorf("invalid filename %q: %w", cfg.Filename, err))
 	}
 
-	thresholdStr := getEnv("THRESHOLD", "60")
-	threshold, err := strconv.Atoi(thresholdStr)
+	threshold, err := parseWindow(values["THRESHOLD"])
 	if err != nil {
-		return nil, fmt.Errorf("invalid threshold value: %w", err)
+		errs = append(errs, fmt.Errorf("invalid threshold value: %w", err))
 	}
 	cfg.Threshold = threshold
 
+	limit, err := strconv.Atoi(values["LIMIT"])
+	if err != nil || limit < 0 {
+		errs = append(errs, fmt.Errorf("invalid limit %q: must be a non-negative integer", values["LIMIT"]))
+	}
+	cfg.Limit = limit
+
+	maxWait, err := time.ParseDuration(values["MAX_WAIT"])
+	if err != nil || maxWait <= 0 || maxWait >= WriteTimeout {
+		errs = append(errs, fmt.Errorf("invalid max wait %q: must be a positive duration below %s", values["MAX_WAIT"], WriteTimeout))
+	}
+	cfg.MaxWait = maxWait
+
+	switch cfg.PersistenceMode {
+	case "rewrite", "wal":
+	default:
+		errs = append(errs, fmt.Errorf("invalid persistence mode %q: must be \"rewrite\" or \"wal\"", cfg.PersistenceMode))
+	}
+
+	switch cfg.Recovery {
+	case "strict", "quarantine":
+	default:
+		errs = append(errs, fmt.Errorf("invalid recovery mode %q: must be \"strict\" or \"quarantine\"", cfg.Recovery))
+	}
+
+	switch cfg.Format {
+	case "text", "binary":
+	default:
+		errs = append(errs, fmt.Errorf("invalid format %q: must be \"text\" or \"binary\"", cfg.Format))
+	}
+
+	switch cfg.LogFormat {
+	case "text", "json":
+	default:
+		errs = append(errs, fmt.Errorf("invalid log format %q: must be \"text\" or \"json\"", cfg.LogFormat))
+	}
+
+	switch cfg.LogLevel {
+	case "debug", "info", "warn", "error":
+	default:
+		errs = append(errs, fmt.Errorf("invalid log level %q: must be \"debug\", \"info\", \"warn\" or \"error\"", cfg.LogLevel))
+	}
+
+	switch cfg.TraceExporter {
+	case "", "otlp":
+	case "file":
+		if err := checkWritable(cfg.TraceFile); err != nil {
+			errs = append(errs, fmt.Errorf("invalid trace file %q: %w", cfg.TraceFile, err))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("invalid trace exporter %q: must be \"otlp\", \"file\" or empty", cfg.TraceExporter))
+	}
+
+	switch cfg.Store {
+	case "slice", "ring", "bucket", "sharded":
+	default:
+		errs = append(errs, fmt.Errorf("invalid store %q: must be \"slice\", \"ring\", \"bucket\" or \"sharded\"", cfg.Store))
+	}
+
+	bucketSize, err := parseWindow(values["BUCKET_SIZE"])
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid bucket size value: %w", err))
+	}
+	cfg.BucketSize = bucketSize
+
+	shards, err := strconv.Atoi(values["SHARDS"])
+	if err != nil || shards < 0 {
+		errs = append(errs, fmt.Errorf("invalid shards %q: must be a non-negative integer", values["SHARDS"]))
+	}
+	cfg.Shards = shards
+
+	compactInterval, err := time.ParseDuration(values["COMPACT_INTERVAL"])
+	if err != nil || compactInterval <= 0 {
+		errs = append(errs, fmt.Errorf("invalid compact interval %q: must be a positive duration", values["COMPACT_INTERVAL"]))
+	}
+	cfg.CompactInterval = compactInterval
+
+	switch cfg.SyncMode {
+	case "request", "background":
+	default:
+		errs = append(errs, fmt.Errorf("invalid sync mode %q: must be \"request\" or \"background\"", cfg.SyncMode))
+	}
+	workerInterval, err := time.ParseDuration(values["WORKER_INTERVAL"])
+	if err != nil || workerInterval <= 0 {
+		errs = append(errs, fmt.Errorf("invalid worker interval %q: must be a positive duration", values["WORKER_INTERVAL"]))
+	}
+	cfg.WorkerInterval = workerInterval
+
+	if err := cfg.parseDurability(values["DURABILITY"]); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := cfg.validateStrategy(cfg.Strategy); err != nil {
+		errs = append(errs, err)
+	}
+	if err := cfg.parseCounters(values["COUNTERS"]); err != nil {
+		errs = append(errs, err)
+	}
+	autoCreate, err := strconv.ParseBool(values["AUTO_CREATE_COUNTERS"])
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid auto create counters %q: must be \"true\" or \"false\"", values["AUTO_CREATE_COUNTERS"]))
+	}
+	cfg.AutoCreateCounters = autoCreate
+	maxCounters, err := strconv.Atoi(values["MAX_COUNTERS"])
+	if err != nil || maxCounters <= 0 {
+		errs = append(errs, fmt.Errorf("invalid max counters %q: must be a positive integer", values["MAX_COUNTERS"]))
+	}
+	cfg.MaxCounters = maxCounters
+
+	if err := cfg.parseKeyBy(values["KEY_BY"]); err != nil {
+		errs = append(errs, err)
+	}
+	if err := cfg.parseTrustedProxies(values["TRUSTED_PROXIES"]); err != nil {
+		errs = append(errs, err)
+	}
+	maxKeys, err := strconv.Atoi(values["MAX_KEYS"])
+	if err != nil || maxKeys <= 0 {
+		errs = append(errs, fmt.Errorf("invalid max keys %q: must be a positive integer", values["MAX_KEYS"]))
+	}
+	cfg.MaxKeys = maxKeys
+
+	requireTLS, err := strconv.ParseBool(values["REQUIRE_TLS"])
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid require TLS %q: must be \"true\" or \"false\"", values["REQUIRE_TLS"]))
+	}
+	cfg.RequireTLS = requireTLS
+	if err := cfg.parseTLS(values["TLS_MIN_VERSION"]); err != nil {
+		errs = append(errs, err)
+	}
+
+	maxSyncFailures, err := strconv.Atoi(values["MAX_SYNC_FAILURES"])
+	if err != nil || maxSyncFailures <= 0 {
+		errs = append(errs, fmt.Errorf("invalid max sync failures %q: must be a positive integer", values["MAX_SYNC_FAILURES"]))
+	}
+	cfg.MaxSyncFailures = maxSyncFailures
+	shutdownDelay, err := time.ParseDuration(values["SHUTDOWN_DELAY"])
+	if err != nil || shutdownDelay < 0 {
+		errs = append(errs, fmt.Errorf("invalid shutdown delay %q: must be a non-negative duration", values["SHUTDOWN_DELAY"]))
+	}
+	cfg.ShutdownDelay = shutdownDelay
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
 	return cfg, nil
 }
+
+// Changed returns the environment variable names of the settings whose raw
+// values differ in next, in the order they are documented.
+func (c *Config) Changed(next *Config) []string {
+	var changed []string
+	for _, s := range settings {
+		if c.values[s.env] != next.values[s.env] {
+			changed = append(changed, s.env)
+		}
+	}
+	return changed
+}
 func (c *Config) ServerAddr() string {
 	return fmt.Sprintf(":%s", c.Port)
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// parseDurability accepts "always", "batch", "none" or "interval=<duration>".
+func (c *Config) parseDurability(value string) error {
+	mode, arg, hasArg := strings.Cut(value, "=")
+	switch {
+	case mode == "interval" && hasArg:
+		interval, err := time.ParseDuration(arg)
+		if err != nil || interval <= 0 {
+			return fmt.Errorf("invalid durability interval %q: must be a positive duration", arg)
+		}
+		c.DurabilityInterval = interval
+	case !hasArg && (mode == "always" || mode == "batch" || mode == "none"):
+	default:
+		return fmt.Errorf("invalid durability %q: must be \"always\", \"batch\", \"interval=<duration>\" or \"none\"", value)
+	}
+	if mode != "always" && c.PersistenceMode != "wal" {
+		return fmt.Errorf("durability %q requires PERSISTENCE_MODE=wal; rewrites are always fsynced", value)
+	}
+
+	c.Durability = mode
+	return nil
+}
+
+// parseTLS checks that the TLS files are set together, that REQUIRE_TLS
+// leaves nothing in plaintext off loopback, and parses the minimum version,
+// "1.2" or "1.3".
+func (c *Config) parseTLS(minVersion string) error {
+	var errs []error
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		errs = append(errs, errors.New("invalid TLS configuration: TLS_CERT_FILE and TLS_KEY_FILE must be set together"))
+	}
+	if c.RequireTLS && c.TLSCertFile == "" {
+		errs = append(errs, errors.New("invalid TLS configuration: REQUIRE_TLS is set but TLS_CERT_FILE is not"))
+	}
+	if c.RequireTLS && c.AdminAddr != "" && !isLoopback(c.AdminAddr) {
+		// The admin listener is always plaintext, so it must not be
+		// reachable from other hosts either.
+		errs = append(errs, fmt.Errorf("invalid TLS configuration: REQUIRE_TLS is set but ADMIN_ADDR %q is not a loopback address", c.AdminAddr))
+	}
+	if c.TLSClientCAFile != "" && c.TLSCertFile == "" {
+		errs = append(errs, errors.New("invalid TLS configuration: TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE"))
+	}
+	switch minVersion {
+	case "1.2":
+		c.TLSMinVersion = tls.VersionTLS12
+	case "1.3":
+		c.TLSMinVersion = tls.VersionTLS13
+	default:
+		errs = append(errs, fmt.Errorf("invalid TLS min version %q: must be \"1.2\" or \"1.3\"", minVersion))
+	}
+	return errors.Join(errs...)
+}
+
+// isLoopback reports whether addr listens on loopback only. Addresses that
+// are not host:port are reported as the invalid admin address instead.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil || host == "localhost" {
+		return true
+	}
+	ip, err := netip.ParseAddr(host)
+	return err == nil && ip.IsLoopback()
+}
+
+// parseCounters accepts a comma-separated list of counter names, each
+// optionally followed by "=<threshold>" and ":<strategy>", e.g.
+// "logins=30s:token-bucket,signups".
+func (c *Config) parseCounters(value string) error {
+	c.Counters = make(map[string]Counter)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		entry, strategy, hasStrategy := strings.Cut(entry, ":")
+		name, thresholdStr, hasThreshold := strings.Cut(entry, "=")
+		if err := domain.ValidateCounterName(name); err != nil {
+			return fmt.Errorf("invalid counter: %w", err)
+		}
+		var counter Counter
+		if hasThreshold {
+			threshold, err := parseWindow(thresholdStr)
+			if err != nil {
+				return fmt.Errorf("invalid threshold for counter %q: %w", name, err)
+			}
+			counter.Threshold = threshold
+		}
+		if hasStrategy {
+			if err := c.validateStrategy(strategy); err != nil {
+				return fmt.Errorf("invalid counter %q: %w", name, err)
+			}
+			counter.Strategy = strategy
+		}
+		c.Counters[name] = counter
+	}
+	return nil
+}
+
+// parseKeyBy accepts "", "ip", "header:<name>" or "query:<name>".
+func (c *Config) parseKeyBy(value string) error {
+	source, name, hasName := strings.Cut(value, ":")
+	switch {
+	case value == "", source == "ip" && !hasName:
+	case (source == "header" || source == "query") && name != "":
+	default:
+		return fmt.Errorf("invalid key by %q: must be \"ip\", \"header:<name>\" or \"query:<name>\"", value)
+	}
+	c.KeyBy = source
+	c.KeyName = name
+	return nil
+}
+
+// parseTrustedProxies accepts a comma-separated list of addresses and CIDR
+// prefixes, e.g. "10.0.0.0/8,192.0.2.1".
+func (c *Config) parseTrustedProxies(value string) error {
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(entry)
+			if addrErr != nil {
+				return fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		c.TrustedProxies = append(c.TrustedProxies, prefix.Masked())
+	}
+	return nil
+}
+
+// validateStrategy checks a strategy name; all but the sliding log need a LIMIT.
+func (c *Config) validateStrategy(strategy string) error {
+	switch strategy {
+	case "sliding-log":
+		return nil
+	case "sliding-window", "fixed-window", "token-bucket", "leaky-bucket":
+		if c.Limit == 0 {
+			return fmt.Errorf("strategy %q requires LIMIT", strategy)
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid strategy %q: must be \"sliding-log\", \"sliding-window\", \"fixed-window\", \"token-bucket\" or \"leaky-bucket\"", strategy)
+}
+
+// parseWindow accepts a bare integer as seconds, for compatibility with older
+// configurations, or a Go duration such as "1500ms".
+func parseWindow(value string) (time.Duration, error) {
+	var d time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		d = time.Duration(seconds) * time.Second
+	} else if d, err = time.ParseDuration(value); err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("%q must be positive", value)
+	}
+	return d, nil
+}
+
+// checkWritable reports whether fileName can be created or replaced, which
+// takes a writable directory since files are replaced through a temp file.
+func checkWritable(fileName string) error {
+	if fileName == "" {
+		return errors.New("must not be empty")
+	}
+	if info, err := os.Stat(fileName); err == nil && info.IsDir() {
+		return errors.New("is a directory")
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(fileName), ".config-check-*")
+	if err != nil {
+		return fmt.Errorf("directory is not writable: %w", err)
 	}
-	return defaultValue
+	tmp.Close()
+	return os.Remove(tmp.Name())
 }
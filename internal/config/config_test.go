@@ -0,0 +1,217 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	fileName := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(fileName, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	dir := t.TempDir()
+	configFile := writeConfig(t, `{
+		"filename": "`+filepath.Join(dir, "file.log")+`",
+		"port": 9000,
+		"limit": 5,
+		"threshold": "30s",
+		"trusted_proxies": ["10.0.0.0/8", "192.0.2.1"]
+	}`)
+
+	tests := []struct {
+		name          string
+		env           map[string]string
+		args          []string
+		wantPort      string
+		wantLimit     int
+		wantThreshold time.Duration
+	}{
+		{
+			name:          "file over defaults",
+			args:          []string{"--config", configFile},
+			wantPort:      "9000",
+			wantLimit:     5,
+			wantThreshold: 30 * time.Second,
+		},
+		{
+			name:          "env over file",
+			env:           map[string]string{"PORT": "9100", "LIMIT": "7"},
+			args:          []string{"--config", configFile},
+			wantPort:      "9100",
+			wantLimit:     7,
+			wantThreshold: 30 * time.Second,
+		},
+		{
+			name:          "flags over env",
+			env:           map[string]string{"PORT": "9100", "LIMIT": "7"},
+			args:          []string{"--config", configFile, "--port", "9200", "--threshold=1m"},
+			wantPort:      "9200",
+			wantLimit:     7,
+			wantThreshold: time.Minute,
+		},
+		{
+			name:          "defaults",
+			args:          []string{"--filename", filepath.Join(dir, "flag.log")},
+			wantPort:      "8000",
+			wantThreshold: time.Minute,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			cfg, err := Load(tt.args)
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if cfg.Port != tt.wantPort || cfg.Limit != tt.wantLimit || cfg.Threshold != tt.wantThreshold {
+				t.Errorf("port, limit, threshold = %s, %d, %v, want %s, %d, %v",
+					cfg.Port, cfg.Limit, cfg.Threshold, tt.wantPort, tt.wantLimit, tt.wantThreshold)
+			}
+		})
+	}
+
+	cfg, err := Load([]string{"--config", configFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.TrustedProxies) != 2 {
+		t.Errorf("trusted proxies = %v, want 2 from the list in the file", cfg.TrustedProxies)
+	}
+}
+
+func TestLoad_ReportsEveryError(t *testing.T) {
+	t.Setenv("PORT", "")
+	_, err := Load([]string{
+		"--route", "",
+		"--threshold", "-5",
+		"--sync-mode", "later",
+		"--worker-interval", "0s",
+		"--compact-interval", "0s",
+		"--max-wait", "10s",
+		"--admin-addr", "localhost",
+		"--tls-cert-file", "server.crt",
+		"--tls-min-version", "1.1",
+		"--require-tls", "maybe",
+		"--filename", filepath.Join(t.TempDir(), "missing", "timestamps.log"),
+	})
+	if err == nil {
+		t.Fatal("Load() error = nil, want validation errors")
+	}
+	for _, want := range []string{"invalid port", "invalid route", "invalid threshold", "invalid filename", "invalid sync mode", "invalid worker interval", "invalid compact interval", "invalid max wait", "invalid admin address", "invalid require TLS",
+		"TLS_CERT_FILE and TLS_KEY_FILE must be set together", "invalid TLS min version"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Load() error = %q, want it to contain %q", err, want)
+		}
+	}
+}
+
+func TestLoad_RequireTLS(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "timestamps.log")
+	_, err := Load([]string{"--filename", filename, "--require-tls", "true"})
+	if err == nil || !strings.Contains(err.Error(), "REQUIRE_TLS is set but TLS_CERT_FILE is not") {
+		t.Errorf("Load() error = %v, want REQUIRE_TLS to refuse plain HTTP", err)
+	}
+	cfg, err := Load([]string{"--filename", filename, "--require-tls", "true", "--tls-cert-file", "server.crt", "--tls-key-file", "server.key"})
+	if err != nil {
+		t.Fatalf("Load() with TLS files error = %v", err)
+	}
+	if !cfg.RequireTLS {
+		t.Error("RequireTLS = false, want true")
+	}
+
+	tlsArgs := []string{"--filename", filename, "--require-tls", "true", "--tls-cert-file", "server.crt", "--tls-key-file", "server.key"}
+	tests := []struct {
+		adminAddr string
+		wantErr   bool
+	}{
+		{adminAddr: "127.0.0.1:8001", wantErr: false},
+		{adminAddr: "[::1]:8001", wantErr: false},
+		{adminAddr: "localhost:8001", wantErr: false},
+		{adminAddr: "", wantErr: false},
+		{adminAddr: "0.0.0.0:8001", wantErr: true},
+		{adminAddr: ":8001", wantErr: true},
+		{adminAddr: "[::]:8001", wantErr: true},
+		{adminAddr: "10.0.0.5:8001", wantErr: true},
+	}
+	for _, tt := range tests {
+		_, err := Load(append(tlsArgs, "--admin-addr", tt.adminAddr))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Load(--admin-addr %q) error = %v, wantErr %v", tt.adminAddr, err, tt.wantErr)
+		}
+		if err != nil && !strings.Contains(err.Error(), "is not a loopback address") {
+			t.Errorf("Load(--admin-addr %q) error = %q, want the plaintext admin listener refused", tt.adminAddr, err)
+		}
+	}
+}
+
+func TestLoad_CounterNames(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "timestamps.log")
+	tests := []struct {
+		counters string
+		wantErr  bool
+	}{
+		{counters: "logins=30s,sign_ups,api-v2", wantErr: false},
+		{counters: "../etc/passwd", wantErr: true},
+		{counters: "logins,a b", wantErr: true},
+		{counters: "=30s", wantErr: true},
+		{counters: strings.Repeat("a", 65), wantErr: true},
+	}
+	for _, tt := range tests {
+		_, err := Load([]string{"--filename", filename, "--counters", tt.counters})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Load(--counters %q) error = %v, wantErr %v", tt.counters, err, tt.wantErr)
+		}
+		if err != nil && !strings.Contains(err.Error(), "invalid counter") {
+			t.Errorf("Load(--counters %q) error = %q, want it to name the counter", tt.counters, err)
+		}
+	}
+}
+
+func TestLoad_ConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{name: "unknown key", content: `{"prot": 8000}`, want: `unknown setting "prot"`},
+		{name: "unsupported value", content: `{"port": {"number": 8000}}`, want: "invalid port in config file"},
+		{name: "malformed", content: `{"port": `, want: "failed to parse config file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load([]string{"--config", writeConfig(t, tt.content)})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load() error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_Changed(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "timestamps.log")
+	current, err := Load([]string{"--filename", fileName, "--limit", "5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := Load([]string{"--filename", fileName, "--limit", "10", "--port", "9000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(current.Changed(next), ","); got != "PORT,LIMIT" {
+		t.Errorf("Changed() = %s, want PORT,LIMIT", got)
+	}
+	if got := current.Changed(current); len(got) != 0 {
+		t.Errorf("Changed() of itself = %v, want none", got)
+	}
+}
@@ -0,0 +1,14 @@
+package domain
+
+import "time"
+
+// Clock is the source of the current time for expiry and background work.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
@@ -0,0 +1,18 @@
+package domain
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// CounterNamePattern is the form of a counter name. Names become part of the
+// counter's file names, so they are limited to characters safe in a path.
+var CounterNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ValidateCounterName reports a name that does not match CounterNamePattern.
+func ValidateCounterName(name string) error {
+	if !CounterNamePattern.MatchString(name) {
+		return fmt.Errorf("%w: counter name %q must be 1-64 letters, digits, '-' or '_'", ErrInvalidInput, name)
+	}
+	return nil
+}
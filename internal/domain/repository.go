@@ -1,12 +1,42 @@
 package domain
 
-import "context"
+import (
+	"context"
+	"time"
+)
 type TimestampRepository interface {
-	Store(ctx context.Context, timestamp int) error
-	View(ctx context.Context) ([]int, error)
+	// Record expires the entries threshold old, then stores a timestamp
+	// unless limit is positive and the window already holds limit entries,
+	// and counts the window, all as one step. now is read inside that step,
+	// so concurrent calls store their timestamps in order and each sees the
+	// count it produced. The stored timestamp is only durable after Sync.
+	//
+	// A store may instead order concurrent calls by something other than
+	// now, and count entries that expired but were not dropped yet, if it
+	// documents so. Each call must still see the count it produced in that
+	// order, and limit must never be exceeded.
+	Record(ctx context.Context, now func() time.Time, threshold time.Duration, limit int) (Recorded, error)
+	Store(ctx context.Context, timestamp time.Time) error
+	View(ctx context.Context) ([]time.Time, error)
 	Count(ctx context.Context) (int, error)
+	// CountUnexpired counts the entries RemoveExpired would keep, without
+	// removing the others.
+	CountUnexpired(ctx context.Context, current time.Time, threshold time.Duration) (int, error)
+	// Oldest returns the oldest timestamp in the window, or the zero time if it is empty.
+	Oldest(ctx context.Context) (time.Time, error)
 	Load(ctx context.Context) error
-	RemoveExpired(ctx context.Context, current, threshold int) error
+	RemoveExpired(ctx context.Context, current time.Time, threshold time.Duration) error
 	Sync(ctx context.Context) error
 	Close() error
 }
+
+// Recorded is the outcome of TimestampRepository.Record.
+type Recorded struct {
+	// Timestamp is the time read from now, whether or not it was stored.
+	Timestamp time.Time
+	Stored    bool
+	// Count is the number of entries in the window after the call.
+	Count int
+	// Oldest is the oldest entry in the window, or the zero time if it is empty.
+	Oldest time.Time
+}
@@ -0,0 +1,11 @@
+package domain
+
+import "context"
+
+// StateStore persists the fixed-size state of a counting strategy that does
+// not keep individual timestamps.
+type StateStore interface {
+	Load(ctx context.Context) ([]int64, error)
+	Save(ctx context.Context, state []int64) error
+	Close() error
+}
@@ -0,0 +1,260 @@
+package persistence
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+
+	"simplesurance/internal/logging"
+)
+
+// Binary log layout:
+//
+//	header: magic "TSLG" | version (1 byte)
+//	record: payload length (uvarint) | payload | CRC-32C of payload (4 bytes, little endian)
+//	payload: count (uvarint) | first timestamp (varint) | count-1 deltas (varint)
+//
+// Version 2 stores Unix nanoseconds; version 1 stored Unix seconds and is
+// still read. Every record carries an absolute first timestamp, so appends
+// never need to read what is already in the file.
+const (
+	binaryVersion        = 2
+	binaryVersionSeconds = 1
+	maxRecordEntries     = 4096
+	maxPayloadSize       = 1 << 20
+)
+
+var (
+	binaryMagic = []byte("TSLG")
+	crcTable    = crc32.MakeTable(crc32.Castagnoli)
+
+	errTruncatedRecord = errors.New("truncated record")
+)
+
+type BinaryPersistence struct {
+	*FilePersistenceImpl
+}
+
+func NewBinaryPersistence(opts ...Option) *BinaryPersistence {
+	return &BinaryPersistence{FilePersistenceImpl: NewFilePersistence(opts...)}
+}
+func (b *BinaryPersistence) Append(ctx context.Context, timestamp int64, filename string) error {
+	file, err := b.fs.OpenFile(filename, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	header := make([]byte, len(binaryMagic)+1)
+	n, err := io.ReadFull(file, header)
+	switch {
+	case n == 0:
+		return b.appendTo(file, filename, func(w io.Writer) error {
+			return writeRecords(ctx, w, []int64{timestamp}, true)
+		})
+	case err == nil && bytes.HasPrefix(header, binaryMagic):
+		switch version := header[len(binaryMagic)]; version {
+		case binaryVersion:
+			return b.appendTo(file, filename, func(w io.Writer) error {
+				return writeRecords(ctx, w, []int64{timestamp}, false)
+			})
+		case binaryVersionSeconds:
+		default:
+			return fmt.Errorf("%w: unsupported format version %d", ErrCorruptFile, version)
+		}
+	}
+
+	// The file is in the text or an older binary format: migrate it in one go.
+	file.Close()
+	timestamps, err := b.ReadAll(ctx, filename)
+	if err != nil {
+		return fmt.Errorf("failed to read legacy file: %w", err)
+	}
+	return b.Rewrite(ctx, append(timestamps, timestamp), filename)
+}
+func (b *BinaryPersistence) Rewrite(ctx context.Context, timestamps []int64, filename string) error {
+	return b.replaceFile(filename, func(w io.Writer) error {
+		return writeRecords(ctx, w, timestamps, true)
+	})
+}
+
+// ReadAll reads the binary format, falling back to the legacy text format
+// for files written before it existed.
+func (b *BinaryPersistence) ReadAll(ctx context.Context, filename string) ([]int64, error) {
+	if !b.FileExists(filename) {
+		return []int64{}, nil
+	}
+
+	data, err := b.readFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, binaryMagic) {
+		return b.FilePersistenceImpl.ReadAll(ctx, filename)
+	}
+	scale := int64(1)
+	switch {
+	case len(data) <= len(binaryMagic):
+		return nil, fmt.Errorf("%w: missing format version", ErrCorruptFile)
+	case data[len(binaryMagic)] == binaryVersionSeconds:
+		scale = int64(time.Second)
+	case data[len(binaryMagic)] != binaryVersion:
+		return nil, fmt.Errorf("%w: unsupported format version %d", ErrCorruptFile, data[len(binaryMagic)])
+	}
+
+	timestamps := []int64{}
+	offset := len(binaryMagic) + 1
+	for offset < len(data) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+
+		records, n, err := decodeRecord(data[offset:])
+		if err != nil {
+			if b.recovery != RecoveryQuarantine {
+				return nil, fmt.Errorf("%w: record at offset %d: %v", ErrCorruptFile, offset, err)
+			}
+			return timestamps, b.recoverTail(ctx, filename, data[offset:], offset, timestamps, err)
+		}
+		for _, timestamp := range records {
+			timestamps = append(timestamps, timestamp*scale)
+		}
+		offset += n
+	}
+
+	return timestamps, nil
+}
+
+// recoverTail handles an undecodable record. Record boundaries cannot be
+// trusted past that point, so the file is rewritten without the rest, which
+// keeps later appends readable; anything other than a torn final write is
+// then copied into the sidecar file.
+func (b *BinaryPersistence) recoverTail(ctx context.Context, filename string, tail []byte, offset int, loaded []int64, cause error) error {
+	logger := logging.FromContext(ctx)
+	if err := b.Rewrite(ctx, loaded, filename); err != nil {
+		return fmt.Errorf("failed to rewrite recovered file: %w", err)
+	}
+	if errors.Is(cause, errTruncatedRecord) {
+		logger.Warn("recovered damaged file", "file", filename, "loaded", len(loaded), "truncated_offset", offset)
+		return nil
+	}
+	if err := b.quarantineBytes(filename, tail); err != nil {
+		return err
+	}
+	logger.Warn("recovered damaged file", "file", filename, "loaded", len(loaded),
+		"quarantined_bytes", len(tail), "offset", offset, "sidecar", filename+corruptSuffix, "error", cause)
+	return nil
+}
+
+func (b *BinaryPersistence) readFile(filename string) ([]byte, error) {
+	file, err := b.fs.OpenFile(filename, os.O_RDONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file for reading: %w", err)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	return data, nil
+}
+
+func writeRecords(ctx context.Context, w io.Writer, timestamps []int64, withHeader bool) error {
+	writer := bufio.NewWriter(w)
+	if withHeader {
+		writer.Write(binaryMagic)
+		writer.WriteByte(binaryVersion)
+	}
+
+	for start := 0; start < len(timestamps); start += maxRecordEntries {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		end := min(start+maxRecordEntries, len(timestamps))
+		if _, err := writer.Write(encodeRecord(timestamps[start:end])); err != nil {
+			return fmt.Errorf("failed to write record: %w", err)
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("failed to flush writer: %w", err)
+	}
+	return nil
+}
+
+func encodeRecord(timestamps []int64) []byte {
+	payload := binary.AppendUvarint(nil, uint64(len(timestamps)))
+	previous := int64(0)
+	for i, timestamp := range timestamps {
+		if i == 0 {
+			payload = binary.AppendVarint(payload, timestamp)
+		} else {
+			payload = binary.AppendVarint(payload, timestamp-previous)
+		}
+		previous = timestamp
+	}
+
+	record := binary.AppendUvarint(nil, uint64(len(payload)))
+	record = append(record, payload...)
+	return binary.LittleEndian.AppendUint32(record, crc32.Checksum(payload, crcTable))
+}
+
+// decodeRecord returns the timestamps in the record at the start of data and
+// the number of bytes it occupies.
+func decodeRecord(data []byte) ([]int64, int, error) {
+	size, n := binary.Uvarint(data)
+	switch {
+	case n == 0:
+		return nil, 0, errTruncatedRecord
+	case n < 0 || size > maxPayloadSize:
+		return nil, 0, errors.New("invalid record length")
+	}
+	end := n + int(size) + crc32.Size
+	if end > len(data) {
+		return nil, 0, errTruncatedRecord
+	}
+
+	payload := data[n : n+int(size)]
+	if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(data[n+int(size):end]) {
+		return nil, 0, errors.New("checksum mismatch")
+	}
+
+	count, read := binary.Uvarint(payload)
+	if read <= 0 || count > maxRecordEntries {
+		return nil, 0, errors.New("invalid entry count")
+	}
+	payload = payload[read:]
+
+	timestamps := make([]int64, 0, count)
+	previous := int64(0)
+	for i := uint64(0); i < count; i++ {
+		value, read := binary.Varint(payload)
+		if read <= 0 {
+			return nil, 0, errors.New("invalid entry")
+		}
+		payload = payload[read:]
+		if i > 0 {
+			value += previous
+		}
+		previous = value
+		timestamps = append(timestamps, value)
+	}
+	if len(payload) != 0 {
+		return nil, 0, errors.New("trailing bytes in record")
+	}
+
+	return timestamps, end, nil
+}
@@ -0,0 +1,354 @@
+package persistence
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBinaryPersistence_RoundTrip(t *testing.T) {
+	now := time.Now().UnixNano()
+	many := make([]int64, maxRecordEntries+10)
+	for i := range many {
+		many[i] = now - int64(len(many)-i)*int64(time.Millisecond)
+	}
+
+	tests := []struct {
+		name       string
+		rewrite    []int64
+		appends    []int64
+		wantResult []int64
+	}{
+		{
+			name:       "rewrite empty",
+			rewrite:    []int64{},
+			wantResult: []int64{},
+		},
+		{
+			name:       "rewrite with non monotonic timestamps",
+			rewrite:    []int64{now, now - 10, now + 5, 0, -now},
+			wantResult: []int64{now, now - 10, now + 5, 0, -now},
+		},
+		{
+			name:       "rewrite spanning several records",
+			rewrite:    many,
+			wantResult: many,
+		},
+		{
+			name:       "append to new file",
+			appends:    []int64{now, now + 1},
+			wantResult: []int64{now, now + 1},
+		},
+		{
+			name:       "append after rewrite",
+			rewrite:    []int64{now - 2, now - 1},
+			appends:    []int64{now},
+			wantResult: []int64{now - 2, now - 1, now},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "timestamps.log")
+			persister := NewBinaryPersistence()
+			ctx := context.Background()
+
+			if tt.rewrite != nil {
+				if err := persister.Rewrite(ctx, tt.rewrite, filename); err != nil {
+					t.Fatalf("Rewrite() error = %v", err)
+				}
+			}
+			for _, ts := range tt.appends {
+				if err := persister.Append(ctx, ts, filename); err != nil {
+					t.Fatalf("Append() error = %v", err)
+				}
+			}
+
+			got, err := persister.ReadAll(ctx, filename)
+			if err != nil {
+				t.Fatalf("ReadAll() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.wantResult) {
+				t.Errorf("ReadAll() = %v entries, want %v entries", len(got), len(tt.wantResult))
+			}
+		})
+	}
+}
+
+func TestBinaryPersistence_IsSmallerThanText(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now().UnixNano()
+	timestamps := make([]int64, 1000)
+	for i := range timestamps {
+		timestamps[i] = now + int64(i)*int64(time.Millisecond)
+	}
+	ctx := context.Background()
+
+	textFile := filepath.Join(dir, "text.log")
+	binaryFile := filepath.Join(dir, "binary.log")
+	if err := NewFilePersistence().Rewrite(ctx, timestamps, textFile); err != nil {
+		t.Fatalf("Rewrite() error = %v", err)
+	}
+	if err := NewBinaryPersistence().Rewrite(ctx, timestamps, binaryFile); err != nil {
+		t.Fatalf("Rewrite() error = %v", err)
+	}
+
+	textInfo, _ := os.Stat(textFile)
+	binaryInfo, _ := os.Stat(binaryFile)
+	if binaryInfo.Size()*4 > textInfo.Size() {
+		t.Errorf("binary size = %d, want at most a quarter of text size %d", binaryInfo.Size(), textInfo.Size())
+	}
+}
+
+func TestBinaryPersistence_MigratesLegacyText(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "timestamps.log")
+	ctx := context.Background()
+	if err := os.WriteFile(filename, []byte("100\n200\n"), 0644); err != nil {
+		t.Fatalf("failed to setup test file: %v", err)
+	}
+
+	persister := NewBinaryPersistence()
+	got, err := persister.ReadAll(ctx, filename)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, []int64{100 * sec, 200 * sec}) {
+		t.Errorf("ReadAll() of legacy file = %v, want seconds converted to nanoseconds", got)
+	}
+
+	if err := persister.Append(ctx, 300*sec, filename); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if !bytes.HasPrefix(data, binaryMagic) {
+		t.Errorf("file was not migrated to the binary format")
+	}
+
+	got, err = persister.ReadAll(ctx, filename)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, []int64{100 * sec, 200 * sec, 300 * sec}) {
+		t.Errorf("ReadAll() after migration = %v, want [100s 200s 300s]", got)
+	}
+}
+
+// TestFilePersistence_ReadsBinary switches FORMAT back from binary to text:
+// the text persister must read the binary file rather than quarantine it.
+func TestFilePersistence_ReadsBinary(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "timestamps.log")
+	ctx := context.Background()
+	want := []int64{100 * sec, 200*sec + 5, 300 * sec}
+	if err := NewBinaryPersistence().Rewrite(ctx, want[:2], filename); err != nil {
+		t.Fatalf("Rewrite() error = %v", err)
+	}
+	if err := NewBinaryPersistence().Append(ctx, want[2], filename); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+
+	persister := NewFilePersistence(WithRecovery(RecoveryQuarantine))
+	got, err := persister.ReadAll(ctx, filename)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadAll() of binary file = %v, want %v", got, want)
+	}
+	if _, err := os.Stat(filename + corruptSuffix); !os.IsNotExist(err) {
+		t.Errorf("binary records were quarantined, stat error = %v", err)
+	}
+
+	if err := persister.Append(ctx, 400*sec, filename); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if !bytes.HasPrefix(data, []byte(textHeader+"\n")) {
+		t.Errorf("file was not converted to text, starts with %q", data[:min(len(data), 8)])
+	}
+	got, err = persister.ReadAll(ctx, filename)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if want := append(want, 400*sec); !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadAll() after conversion = %v, want %v", got, want)
+	}
+}
+
+func TestBinaryPersistence_ReadsSecondPrecisionVersion(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "timestamps.log")
+	ctx := context.Background()
+
+	data := append([]byte(nil), binaryMagic...)
+	data = append(data, binaryVersionSeconds)
+	data = append(data, encodeRecord([]int64{100, 101})...)
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		t.Fatalf("failed to setup test file: %v", err)
+	}
+
+	persister := NewBinaryPersistence()
+	if err := persister.Append(ctx, 102*sec+5, filename); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	got, err := persister.ReadAll(ctx, filename)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if want := []int64{100 * sec, 101 * sec, 102*sec + 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadAll() = %v, want %v", got, want)
+	}
+}
+
+const sec = int64(time.Second)
+
+func TestBinaryPersistence_Corruption(t *testing.T) {
+	tests := []struct {
+		name        string
+		recovery    RecoveryMode
+		damage      func(data []byte) []byte
+		want        []int64
+		wantErr     bool
+		wantCorrupt bool
+	}{
+		{
+			name:     "strict fails on flipped bit",
+			recovery: RecoveryStrict,
+			damage:   flipLastRecordBit,
+			wantErr:  true,
+		},
+		{
+			name:        "quarantine keeps records before flipped bit",
+			recovery:    RecoveryQuarantine,
+			damage:      flipLastRecordBit,
+			want:        []int64{1, 2},
+			wantCorrupt: true,
+		},
+		{
+			name:     "strict fails on truncated record",
+			recovery: RecoveryStrict,
+			damage:   func(data []byte) []byte { return data[:len(data)-2] },
+			wantErr:  true,
+		},
+		{
+			name:     "quarantine skips truncated record",
+			recovery: RecoveryQuarantine,
+			damage:   func(data []byte) []byte { return data[:len(data)-2] },
+			want:     []int64{1, 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "timestamps.log")
+			ctx := context.Background()
+			setup := NewBinaryPersistence()
+			if err := setup.Rewrite(ctx, []int64{1, 2}, filename); err != nil {
+				t.Fatalf("Rewrite() error = %v", err)
+			}
+			if err := setup.Append(ctx, 3, filename); err != nil {
+				t.Fatalf("Append() error = %v", err)
+			}
+			data, _ := os.ReadFile(filename)
+			if err := os.WriteFile(filename, tt.damage(data), 0644); err != nil {
+				t.Fatalf("failed to damage file: %v", err)
+			}
+
+			got, err := NewBinaryPersistence(WithRecovery(tt.recovery)).ReadAll(ctx, filename)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadAll() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !errors.Is(err, ErrCorruptFile) {
+					t.Errorf("ReadAll() error = %v, want %v", err, ErrCorruptFile)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadAll() = %v, want %v", got, tt.want)
+			}
+			sidecar, err := os.ReadFile(filename + corruptSuffix)
+			if (err == nil) != tt.wantCorrupt {
+				t.Errorf("sidecar exists = %v, want %v", err == nil, tt.wantCorrupt)
+			}
+
+			// The recovered file is clean, so loading it again quarantines nothing more.
+			if again, err := NewBinaryPersistence(WithRecovery(tt.recovery)).ReadAll(ctx, filename); err != nil || !reflect.DeepEqual(again, tt.want) {
+				t.Errorf("second ReadAll() = %v, %v, want %v", again, err, tt.want)
+			}
+			if again, _ := os.ReadFile(filename + corruptSuffix); !bytes.Equal(again, sidecar) {
+				t.Errorf("sidecar grew from %d to %d bytes on the second load", len(sidecar), len(again))
+			}
+		})
+	}
+}
+
+func TestBinaryPersistence_AppendAfterDamage(t *testing.T) {
+	tests := []struct {
+		name   string
+		damage func(t *testing.T, filename string)
+		want   []int64
+	}{
+		{
+			name: "torn record recovered on load",
+			damage: func(t *testing.T, filename string) {
+				data, _ := os.ReadFile(filename)
+				os.WriteFile(filename, data[:len(data)-2], 0644)
+				if _, err := NewBinaryPersistence(WithRecovery(RecoveryQuarantine)).ReadAll(context.Background(), filename); err != nil {
+					t.Fatalf("ReadAll() error = %v", err)
+				}
+			},
+			want: []int64{1, 2, 4},
+		},
+		{
+			name: "failed append",
+			damage: func(t *testing.T, filename string) {
+				failing := &BinaryPersistence{FilePersistenceImpl: &FilePersistenceImpl{fs: &faultyFS{failOn: "partialwrite"}}}
+				if err := failing.Append(context.Background(), 99, filename); !errors.Is(err, errInjected) {
+					t.Fatalf("Append() error = %v, want %v", err, errInjected)
+				}
+			},
+			want: []int64{1, 2, 3, 4},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "timestamps.log")
+			ctx := context.Background()
+			persister := NewBinaryPersistence()
+			if err := persister.Rewrite(ctx, []int64{1, 2}, filename); err != nil {
+				t.Fatalf("Rewrite() error = %v", err)
+			}
+			if err := persister.Append(ctx, 3, filename); err != nil {
+				t.Fatalf("Append() error = %v", err)
+			}
+			tt.damage(t, filename)
+
+			if err := persister.Append(ctx, 4, filename); err != nil {
+				t.Fatalf("Append() error = %v", err)
+			}
+			got, err := persister.ReadAll(ctx, filename)
+			if err != nil {
+				t.Fatalf("strict ReadAll() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadAll() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func flipLastRecordBit(data []byte) []byte {
+	data[len(data)-crc32.Size-1] ^= 0x01
+	return data
+}
@@ -2,83 +2,327 @@ package persistence
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
+
+	"simplesurance/internal/logging"
+)
+
+type RecoveryMode string
+
+const (
+	// RecoveryStrict fails ReadAll on the first line that cannot be parsed.
+	RecoveryStrict RecoveryMode = "strict"
+	// RecoveryQuarantine skips a truncated last line and moves unparseable
+	// lines into a ".corrupt" sidecar file instead of failing.
+	RecoveryQuarantine RecoveryMode = "quarantine"
 )
-type FilePersistenceImpl struct{}
-func NewFilePersistence() *FilePersistenceImpl {
-	return &FilePersistenceImpl{}
+
+const corruptSuffix = ".corrupt"
+
+// textHeader marks text files holding Unix nanoseconds. Files without it
+// predate sub-second precision and hold Unix seconds.
+const textHeader = "# unit=ns"
+
+type Option func(*FilePersistenceImpl)
+
+func WithRecovery(mode RecoveryMode) Option {
+	return func(f *FilePersistenceImpl) {
+		f.recovery = mode
+	}
 }
-func (f *FilePersistenceImpl) Append(ctx context.Context, timestamp int, filename string) error {
-	return f.WriteToFile(ctx, []int{timestamp}, filename, true)
+
+type FilePersistenceImpl struct {
+	fs       fileSystem
+	recovery RecoveryMode
+}
+
+func NewFilePersistence(opts ...Option) *FilePersistenceImpl {
+	f := &FilePersistenceImpl{
+		fs:       osFS{},
+		recovery: RecoveryStrict,
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
 }
-func (f *FilePersistenceImpl) Rewrite(ctx context.Context, timestamps []int, filename string) error {
-	if err := f.truncateFile(ctx, filename); err != nil {
-		return fmt.Errorf("failed to truncate file: %w", err)
+func (f *FilePersistenceImpl) Append(ctx context.Context, timestamp int64, filename string) error {
+	file, err := f.fs.OpenFile(filename, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	header := make([]byte, len(textHeader)+1)
+	n, err := io.ReadFull(file, header)
+	switch {
+	case n == 0:
+		return f.appendTo(file, filename, func(w io.Writer) error {
+			return writeTimestamps(ctx, w, []int64{timestamp}, true)
+		})
+	case err == nil && string(header) == textHeader+"\n":
+		return f.appendTo(file, filename, func(w io.Writer) error {
+			return writeTimestamps(ctx, w, []int64{timestamp}, false)
+		})
+	}
+
+	// The file holds second-precision timestamps: convert it in one go.
+	file.Close()
+	timestamps, err := f.ReadAll(ctx, filename)
+	if err != nil {
+		return fmt.Errorf("failed to read legacy file: %w", err)
 	}
-	return f.WriteToFile(ctx, timestamps, filename, false)
+	return f.Rewrite(ctx, append(timestamps, timestamp), filename)
 }
-func (f *FilePersistenceImpl) ReadAll(ctx context.Context, filename string) ([]int, error) {
+
+// appendTo writes to the end of file, and cuts a partly written entry off
+// again if the write fails, since entries appended after it could not be read.
+func (f *FilePersistenceImpl) appendTo(file file, filename string, write func(w io.Writer) error) error {
+	info, err := f.fs.Stat(filename)
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	if err := write(file); err != nil {
+		if truncErr := file.Truncate(info.Size()); truncErr != nil {
+			return errors.Join(err, fmt.Errorf("failed to truncate partial write: %w", truncErr))
+		}
+		return err
+	}
+	return nil
+}
+
+// Rewrite replaces the file atomically: the timestamps are written to a
+// sibling temp file which is fsynced and renamed over the target, and the
+// directory is then fsynced so the rename itself survives a crash.
+func (f *FilePersistenceImpl) Rewrite(ctx context.Context, timestamps []int64, filename string) error {
+	return f.replaceFile(filename, func(w io.Writer) error {
+		return writeTimestamps(ctx, w, timestamps, true)
+	})
+}
+
+// WriteToFile appends the timestamps, given as Unix seconds, or replaces the
+// file with them if appendMode is false.
+//
+// Deprecated: Use Append or Rewrite, which it now calls with the timestamps
+// converted to nanoseconds.
+func (f *FilePersistenceImpl) WriteToFile(ctx context.Context, timestamps []int, filename string, appendMode bool) error {
+	nanos := make([]int64, len(timestamps))
+	for i, timestamp := range timestamps {
+		nanos[i] = int64(timestamp) * int64(time.Second)
+	}
+	if !appendMode {
+		return f.Rewrite(ctx, nanos, filename)
+	}
+	for _, timestamp := range nanos {
+		if err := f.Append(ctx, timestamp, filename); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadAll returns the timestamps as Unix nanoseconds, converting files
+// written with second precision. Files in the binary format are decoded as
+// binary, and Append then converts them to text.
+func (f *FilePersistenceImpl) ReadAll(ctx context.Context, filename string) ([]int64, error) {
 	if !f.FileExists(filename) {
-		return []int{}, nil
+		return []int64{}, nil
 	}
 
-	file, err := os.OpenFile(filename, os.O_RDONLY, 0644)
+	file, err := f.fs.OpenFile(filename, os.O_RDONLY, 0644)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open file for reading: %w", err)
 	}
 	defer file.Close()
 
-	var timestamps []int
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
+	reader := bufio.NewReader(file)
+	if magic, _ := reader.Peek(len(binaryMagic)); bytes.Equal(magic, binaryMagic) {
+		// Written while FORMAT was binary: read it as such, as the binary
+		// reader reads text, instead of quarantining every record.
+		file.Close()
+		return (&BinaryPersistence{FilePersistenceImpl: f}).ReadAll(ctx, filename)
+	}
+
+	var (
+		timestamps []int64
+		corrupt    []string
+		truncated  bool
+		scale      = int64(time.Second)
+	)
+	for lineNo := 1; ; lineNo++ {
 		select {
 		case <-ctx.Done():
 			return nil, ctx.Err()
 		default:
 		}
 
-		line := scanner.Text()
+		raw, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("error while scanning file: %w", err)
+		}
+		if raw == "" {
+			break
+		}
+		complete := strings.HasSuffix(raw, "\n")
+		line := strings.TrimSuffix(raw, "\n")
+
+		if !complete {
+			// Every record is written with a trailing newline, so a last
+			// line without one is a torn write, whose digits would parse
+			// as a bogus timestamp.
+			if f.recovery != RecoveryQuarantine {
+				return nil, fmt.Errorf("%w: line %d: unterminated line %q from a torn write", ErrCorruptFile, lineNo, line)
+			}
+			truncated = true
+			break
+		}
+		if lineNo == 1 && line == textHeader {
+			scale = 1
+			continue
+		}
 		if line == "" {
 			continue
 		}
 
-		timestamp, err := strconv.Atoi(line)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse timestamp '%s': %w", line, err)
+		timestamp, parseErr := strconv.ParseInt(line, 10, 64)
+		if parseErr != nil {
+			if f.recovery != RecoveryQuarantine {
+				return nil, fmt.Errorf("%w: line %d: failed to parse timestamp '%s': %v", ErrCorruptFile, lineNo, line, parseErr)
+			}
+			corrupt = append(corrupt, line)
+			continue
+		}
+		timestamps = append(timestamps, timestamp*scale)
+
+		if err == io.EOF {
+			break
 		}
-		timestamps = append(timestamps, timestamp)
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error while scanning file: %w", err)
+	if len(corrupt) > 0 || truncated {
+		// The damaged lines are only quarantined once the file no longer
+		// holds them, so a failed rewrite cannot copy them into the sidecar
+		// again on every load. It also keeps appends off a torn last line.
+		if err := f.Rewrite(ctx, timestamps, filename); err != nil {
+			return nil, fmt.Errorf("failed to rewrite recovered file: %w", err)
+		}
+	}
+	if len(corrupt) > 0 {
+		if err := f.quarantine(ctx, filename, corrupt); err != nil {
+			return nil, err
+		}
+	}
+	if len(corrupt) > 0 || truncated {
+		logging.FromContext(ctx).Warn("recovered damaged file",
+			"file", filename, "loaded", len(timestamps), "quarantined_lines", len(corrupt),
+			"sidecar", filename+corruptSuffix, "truncated", truncated)
 	}
 
 	return timestamps, nil
 }
 func (f *FilePersistenceImpl) FileExists(filename string) bool {
-	_, err := os.Stat(filename)
+	_, err := f.fs.Stat(filename)
 	return !os.IsNotExist(err)
 }
-func (f *FilePersistenceImpl) WriteToFile(ctx context.Context, timestamps []int, filename string, append bool) error {
-	flags := os.O_CREATE | os.O_WRONLY
-	if append {
-		flags |= os.O_APPEND
-	} else {
-		flags |= os.O_TRUNC
+func (f *FilePersistenceImpl) Fsync(ctx context.Context, filename string) error {
+	file, err := f.fs.OpenFile(filename, os.O_RDONLY, 0644)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to open file for fsync: %w", err)
 	}
+	defer file.Close()
 
-	file, err := os.OpenFile(filename, flags, 0644)
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to fsync file: %w", err)
+	}
+	return nil
+}
+func (f *FilePersistenceImpl) replaceFile(filename string, write func(w io.Writer) error) error {
+	dir := filepath.Dir(filename)
+	tmp, err := f.fs.CreateTemp(dir, "."+filepath.Base(filename)+".tmp-*")
 	if err != nil {
-		return fmt.Errorf("failed to open file: %w", err)
+		return fmt.Errorf("failed to create temp file: %w", err)
 	}
-	defer file.Close()
+	renamed := false
+	defer func() {
+		if !renamed {
+			tmp.Close()
+			f.fs.Remove(tmp.Name())
+		}
+	}()
+
+	if err := tmp.Chmod(0644); err != nil {
+		return fmt.Errorf("failed to set temp file mode: %w", err)
+	}
+	if err := write(tmp); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("failed to fsync temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+	if err := f.fs.Rename(tmp.Name(), filename); err != nil {
+		return fmt.Errorf("failed to rename temp file: %w", err)
+	}
+	renamed = true
 
-	writer := bufio.NewWriter(file)
-	defer writer.Flush()
+	if err := f.syncDir(dir); err != nil {
+		return fmt.Errorf("failed to fsync directory: %w", err)
+	}
+	return nil
+}
+
+// quarantine appends unparseable lines to the sidecar file so they can be
+// inspected. The main file must already have been rewritten without them.
+func (f *FilePersistenceImpl) quarantine(ctx context.Context, filename string, lines []string) error {
+	var data []byte
+	for _, line := range lines {
+		data = append(data, line...)
+		data = append(data, '\n')
+	}
+	return f.quarantineBytes(filename, data)
+}
+
+func (f *FilePersistenceImpl) quarantineBytes(filename string, data []byte) error {
+	sidecar, err := f.fs.OpenFile(filename+corruptSuffix, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open quarantine file: %w", err)
+	}
+	defer sidecar.Close()
+
+	if _, err := sidecar.Write(data); err != nil {
+		return fmt.Errorf("failed to write quarantine file: %w", err)
+	}
+	return sidecar.Sync()
+}
 
+func (f *FilePersistenceImpl) syncDir(dir string) error {
+	d, err := f.fs.OpenFile(dir, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func writeTimestamps(ctx context.Context, w io.Writer, timestamps []int64, withHeader bool) error {
+	writer := bufio.NewWriter(w)
+	if withHeader {
+		writer.WriteString(textHeader + "\n")
+	}
 	for _, timestamp := range timestamps {
 		select {
 		case <-ctx.Done():
@@ -97,14 +341,3 @@ func (f *FilePersistenceImpl) WriteToFile(ctx context.Context, timestamps []int,
 
 	return nil
 }
-
-func (f *FilePersistenceImpl) truncateFile(ctx context.Context, filename string) error {
-	file, err := os.OpenFile(filename, os.O_TRUNC|os.O_WRONLY, 0644)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil // File doesn't exist, nothing to truncate
-		}
-		return fmt.Errorf("failed to truncate file: %w", err)
-	}
-	return file.Close()
-}
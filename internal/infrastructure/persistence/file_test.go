@@ -2,7 +2,10 @@ package persistence
 
 import (
 	"context"
+	"errors"
 	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -10,12 +13,12 @@ import (
 func TestFilePersistence_Append(t *testing.T) {
 	tests := []struct {
 		name      string
-		timestamp int
+		timestamp int64
 		wantErr   bool
 	}{
 		{
 			name:      "append valid timestamp",
-			timestamp: int(time.Now().Unix()),
+			timestamp: time.Now().UnixNano(),
 			wantErr:   false,
 		},
 		{
@@ -57,22 +60,22 @@ func TestFilePersistence_Append(t *testing.T) {
 func TestFilePersistence_Rewrite(t *testing.T) {
 	tests := []struct {
 		name       string
-		timestamps []int
+		timestamps []int64
 		wantErr    bool
 	}{
 		{
 			name:       "rewrite with empty slice",
-			timestamps: []int{},
+			timestamps: []int64{},
 			wantErr:    false,
 		},
 		{
 			name:       "rewrite with single timestamp",
-			timestamps: []int{int(time.Now().Unix())},
+			timestamps: []int64{time.Now().UnixNano()},
 			wantErr:    false,
 		},
 		{
 			name:       "rewrite with multiple timestamps",
-			timestamps: []int{int(time.Now().Unix()), int(time.Now().Unix()) - 10, int(time.Now().Unix()) - 20},
+			timestamps: []int64{time.Now().UnixNano(), time.Now().UnixNano() - 10, time.Now().UnixNano() - 20},
 			wantErr:    false,
 		},
 	}
@@ -114,28 +117,28 @@ func TestFilePersistence_ReadAll(t *testing.T) {
 	tests := []struct {
 		name       string
 		setupFile  bool
-		fileData   []int
+		fileData   []int64
 		wantCount  int
 		wantErr    bool
 	}{
 		{
 			name:       "read from existing file",
 			setupFile:  true,
-			fileData:   []int{int(time.Now().Unix()), int(time.Now().Unix()) - 10},
+			fileData:   []int64{time.Now().UnixNano(), time.Now().UnixNano() - 10},
 			wantCount:  2,
 			wantErr:    false,
 		},
 		{
 			name:       "read from non-existent file",
 			setupFile:  false,
-			fileData:   []int{},
+			fileData:   []int64{},
 			wantCount:  0,
 			wantErr:    false,
 		},
 		{
 			name:       "read from empty file",
 			setupFile:  true,
-			fileData:   []int{},
+			fileData:   []int64{},
 			wantCount:  0,
 			wantErr:    false,
 		},
@@ -203,7 +206,7 @@ func TestFilePersistence_FileExists(t *testing.T) {
 			ctx := context.Background()
 
 			if tt.setup {
-				if err := persister.Append(ctx, int(time.Now().Unix()), tt.filename); err != nil {
+				if err := persister.Append(ctx, time.Now().UnixNano(), tt.filename); err != nil {
 					t.Fatalf("Failed to setup test file: %v", err)
 				}
 			}
@@ -215,3 +218,297 @@ func TestFilePersistence_FileExists(t *testing.T) {
 		})
 	}
 }
+
+var errInjected = errors.New("injected failure")
+
+// faultyFS wraps the real filesystem and fails the named step of a write.
+type faultyFS struct {
+	osFS
+	failOn string
+}
+
+type faultyFile struct {
+	file
+	fs *faultyFS
+}
+
+func (fs *faultyFS) OpenFile(name string, flag int, perm os.FileMode) (file, error) {
+	f, err := fs.osFS.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &faultyFile{file: f, fs: fs}, nil
+}
+
+func (fs *faultyFS) CreateTemp(dir, pattern string) (file, error) {
+	if fs.failOn == "create" {
+		return nil, errInjected
+	}
+	f, err := fs.osFS.CreateTemp(dir, pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &faultyFile{file: f, fs: fs}, nil
+}
+
+func (fs *faultyFS) Rename(oldpath, newpath string) error {
+	if fs.failOn == "rename" {
+		return errInjected
+	}
+	return fs.osFS.Rename(oldpath, newpath)
+}
+
+func (f *faultyFile) Write(p []byte) (int, error) {
+	if f.fs.failOn == "write" {
+		return 0, errInjected
+	}
+	if f.fs.failOn == "partialwrite" {
+		n, _ := f.file.Write(p[:len(p)/2])
+		return n, errInjected
+	}
+	return f.file.Write(p)
+}
+
+func (f *faultyFile) Sync() error {
+	info, err := os.Stat(f.Name())
+	if err == nil && info.IsDir() && f.fs.failOn == "syncdir" {
+		return errInjected
+	}
+	if err == nil && !info.IsDir() && f.fs.failOn == "fsync" {
+		return errInjected
+	}
+	return f.file.Sync()
+}
+
+func (f *faultyFile) Close() error {
+	err := f.file.Close()
+	if f.fs.failOn == "close" {
+		return errInjected
+	}
+	return err
+}
+
+func TestFilePersistence_RewriteFailures(t *testing.T) {
+	original := []int64{1, 2, 3}
+	replacement := []int64{4, 5}
+
+	tests := []struct {
+		name   string
+		failOn string
+		want   []int64
+	}{
+		{name: "fail creating temp file", failOn: "create", want: original},
+		{name: "fail writing temp file", failOn: "write", want: original},
+		{name: "fail fsyncing temp file", failOn: "fsync", want: original},
+		{name: "fail closing temp file", failOn: "close", want: original},
+		{name: "fail renaming temp file", failOn: "rename", want: original},
+		{name: "fail fsyncing directory", failOn: "syncdir", want: replacement},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			filename := filepath.Join(dir, "timestamps.log")
+			ctx := context.Background()
+
+			if err := NewFilePersistence().Rewrite(ctx, original, filename); err != nil {
+				t.Fatalf("failed to setup test file: %v", err)
+			}
+
+			persister := &FilePersistenceImpl{fs: &faultyFS{failOn: tt.failOn}}
+			err := persister.Rewrite(ctx, replacement, filename)
+			if !errors.Is(err, errInjected) {
+				t.Fatalf("Rewrite() error = %v, want %v", err, errInjected)
+			}
+
+			got, err := NewFilePersistence().ReadAll(ctx, filename)
+			if err != nil {
+				t.Fatalf("ReadAll() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadAll() = %v, want %v", got, tt.want)
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatalf("ReadDir() error = %v", err)
+			}
+			if len(entries) != 1 {
+				t.Errorf("directory has %d entries, want only the target file", len(entries))
+			}
+		})
+	}
+}
+
+func TestFilePersistence_ReadAllRecovery(t *testing.T) {
+	tests := []struct {
+		name        string
+		recovery    RecoveryMode
+		content     string
+		want        []int64
+		wantCorrupt string
+		wantErr     bool
+	}{
+		{
+			name:     "strict fails on corrupt line",
+			recovery: RecoveryStrict,
+			content:  "# unit=ns\n1\nbad\n3\n",
+			wantErr:  true,
+		},
+		{
+			name:     "strict fails on truncated last line",
+			recovery: RecoveryStrict,
+			content:  "# unit=ns\n1\n2\n17297",
+			wantErr:  true,
+		},
+		{
+			name:        "quarantine moves corrupt lines to sidecar",
+			recovery:    RecoveryQuarantine,
+			content:     "# unit=ns\n1\nbad\n3\n4x\n5\n",
+			want:        []int64{1, 3, 5},
+			wantCorrupt: "bad\n4x\n",
+		},
+		{
+			name:     "quarantine skips truncated last line",
+			recovery: RecoveryQuarantine,
+			content:  "# unit=ns\n1\n2\n17",
+			want:     []int64{1, 2},
+		},
+		{
+			name:     "quarantine keeps clean file untouched",
+			recovery: RecoveryQuarantine,
+			content:  "# unit=ns\n1\n2\n",
+			want:     []int64{1, 2},
+		},
+		{
+			name:     "second precision file is converted",
+			recovery: RecoveryQuarantine,
+			content:  "1709848461\n1709848465\n",
+			want:     []int64{1709848461 * int64(time.Second), 1709848465 * int64(time.Second)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "timestamps.log")
+			if err := os.WriteFile(filename, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("failed to setup test file: %v", err)
+			}
+
+			persister := NewFilePersistence(WithRecovery(tt.recovery))
+			got, err := persister.ReadAll(context.Background(), filename)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadAll() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !errors.Is(err, ErrCorruptFile) {
+					t.Errorf("ReadAll() error = %v, want %v", err, ErrCorruptFile)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadAll() = %v, want %v", got, tt.want)
+			}
+
+			corrupt, err := os.ReadFile(filename + corruptSuffix)
+			if tt.wantCorrupt == "" {
+				if !os.IsNotExist(err) {
+					t.Errorf("sidecar file should not exist, got content %q", corrupt)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to read sidecar file: %v", err)
+			}
+			if string(corrupt) != tt.wantCorrupt {
+				t.Errorf("sidecar content = %q, want %q", corrupt, tt.wantCorrupt)
+			}
+		})
+	}
+}
+
+func TestFilePersistence_WriteToFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "timestamps.log")
+	ctx := context.Background()
+	persister := NewFilePersistence()
+
+	steps := []struct {
+		timestamps []int
+		appendMode bool
+		want       []int64
+	}{
+		{timestamps: []int{1, 2}, appendMode: true, want: []int64{1 * sec, 2 * sec}},
+		{timestamps: []int{3}, appendMode: true, want: []int64{1 * sec, 2 * sec, 3 * sec}},
+		{timestamps: []int{4, 5}, appendMode: false, want: []int64{4 * sec, 5 * sec}},
+	}
+	for _, step := range steps {
+		if err := persister.WriteToFile(ctx, step.timestamps, filename, step.appendMode); err != nil {
+			t.Fatalf("WriteToFile() error = %v", err)
+		}
+		got, err := persister.ReadAll(ctx, filename)
+		if err != nil {
+			t.Fatalf("ReadAll() error = %v", err)
+		}
+		if !reflect.DeepEqual(got, step.want) {
+			t.Errorf("ReadAll() after WriteToFile(%v, %v) = %v, want %v", step.timestamps, step.appendMode, got, step.want)
+		}
+	}
+}
+
+func TestFilePersistence_QuarantinesOnce(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "timestamps.log")
+	ctx := context.Background()
+	if err := os.WriteFile(filename, []byte("# unit=ns\n1\nbad\n2\n3"), 0644); err != nil {
+		t.Fatalf("failed to setup test file: %v", err)
+	}
+
+	failing := &FilePersistenceImpl{fs: &faultyFS{failOn: "rename"}, recovery: RecoveryQuarantine}
+	if _, err := failing.ReadAll(ctx, filename); !errors.Is(err, errInjected) {
+		t.Fatalf("ReadAll() error = %v, want %v", err, errInjected)
+	}
+	if _, err := os.Stat(filename + corruptSuffix); !os.IsNotExist(err) {
+		t.Error("sidecar written although the file still holds the corrupt line")
+	}
+
+	persister := NewFilePersistence(WithRecovery(RecoveryQuarantine))
+	for i := 0; i < 2; i++ {
+		got, err := persister.ReadAll(ctx, filename)
+		if err != nil {
+			t.Fatalf("ReadAll() error = %v", err)
+		}
+		if want := []int64{1, 2}; !reflect.DeepEqual(got, want) {
+			t.Errorf("ReadAll() = %v, want %v", got, want)
+		}
+	}
+	if corrupt, _ := os.ReadFile(filename + corruptSuffix); string(corrupt) != "bad\n" {
+		t.Errorf("sidecar content = %q, want the corrupt line once", corrupt)
+	}
+	if err := persister.Append(ctx, 4, filename); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	if got, _ := persister.ReadAll(ctx, filename); !reflect.DeepEqual(got, []int64{1, 2, 4}) {
+		t.Errorf("ReadAll() after Append() = %v, want [1 2 4] without the torn line", got)
+	}
+}
+
+func TestFilePersistence_AppendConvertsSecondPrecisionFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "timestamps.log")
+	ctx := context.Background()
+	if err := os.WriteFile(filename, []byte("100\n200\n"), 0644); err != nil {
+		t.Fatalf("failed to setup test file: %v", err)
+	}
+
+	persister := NewFilePersistence()
+	if err := persister.Append(ctx, 300*int64(time.Second)+1, filename); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+
+	got, err := persister.ReadAll(ctx, filename)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	want := []int64{100 * int64(time.Second), 200 * int64(time.Second), 300*int64(time.Second) + 1}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadAll() = %v, want %v", got, want)
+	}
+}
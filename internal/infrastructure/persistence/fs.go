@@ -0,0 +1,50 @@
+package persistence
+
+import (
+	"io"
+	"os"
+)
+
+// fileSystem is the subset of the os package used by FilePersistenceImpl.
+// It exists so tests can inject failures at each step of a write.
+type fileSystem interface {
+	OpenFile(name string, flag int, perm os.FileMode) (file, error)
+	CreateTemp(dir, pattern string) (file, error)
+	Rename(oldpath, newpath string) error
+	Remove(name string) error
+	Stat(name string) (os.FileInfo, error)
+}
+
+type file interface {
+	io.ReadWriteCloser
+	Name() string
+	Sync() error
+	Chmod(mode os.FileMode) error
+	Truncate(size int64) error
+}
+
+type osFS struct{}
+
+func (osFS) OpenFile(name string, flag int, perm os.FileMode) (file, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+func (osFS) CreateTemp(dir, pattern string) (file, error) {
+	f, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+func (osFS) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+func (osFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
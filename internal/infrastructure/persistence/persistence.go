@@ -9,12 +9,15 @@ import (
 var (
 	ErrFileNotFound = errors.New("file not found")
 	ErrFileOperationFailed = errors.New("file operation failed")
+	ErrCorruptFile = errors.New("corrupt file")
 )
+// FilePersistence stores timestamps as Unix nanoseconds.
 type FilePersistence interface {
-	Append(ctx context.Context, timestamp int, filename string) error
-	Rewrite(ctx context.Context, timestamps []int, filename string) error
-	ReadAll(ctx context.Context, filename string) ([]int, error)
+	Append(ctx context.Context, timestamp int64, filename string) error
+	Rewrite(ctx context.Context, timestamps []int64, filename string) error
+	ReadAll(ctx context.Context, filename string) ([]int64, error)
 	FileExists(filename string) bool
+	Fsync(ctx context.Context, filename string) error
 }
 type FileReader interface {
 	Read(ctx context.Context, filename string) (io.ReadCloser, error)
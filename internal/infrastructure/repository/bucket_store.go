@@ -0,0 +1,303 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"simplesurance/internal/domain"
+	"simplesurance/internal/infrastructure/persistence"
+)
+
+// BucketStore counts hits per fixed-size time bucket in a circular array, so
+// its memory is proportional to threshold/bucketSize rather than to the
+// request rate. Counts are exact at bucket granularity: a bucket expires once
+// the newest timestamp it could hold has expired, so the count may include up
+// to one bucket of hits that MemoryStore would already have dropped.
+//
+// Its file holds a start and a count per bucket, so it is as small as the
+// ring. In WAL mode single timestamps are appended after them until the next
+// compaction.
+type BucketStore struct {
+	bucketSize int64
+	starts     []int64
+	counts     []int
+	total      int
+	journal    *journal
+	mu         sync.RWMutex
+}
+
+func NewBucketStore(fileName string, persister persistence.FilePersistence, threshold, bucketSize time.Duration, opts ...Option) *BucketStore {
+	if bucketSize <= 0 {
+		bucketSize = time.Second
+	}
+	buckets := bucketCount(threshold, int64(bucketSize))
+	s := &BucketStore{
+		bucketSize: int64(bucketSize),
+		starts:     make([]int64, buckets),
+		counts:     make([]int, buckets),
+	}
+	s.journal = newJournal(fileName, persister, newOptions(opts), &s.mu, &s.mu, s.state)
+	return s
+}
+func (s *BucketStore) Record(ctx context.Context, now func() time.Time, threshold time.Duration, limit int) (domain.Recorded, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current := now()
+	s.removeExpiredLocked(current, threshold)
+	recorded := domain.Recorded{Timestamp: current}
+	if limit <= 0 || s.total < limit {
+		s.add(current.UnixNano(), 1)
+		s.journal.record(current.UnixNano())
+		recorded.Stored = true
+	}
+	recorded.Count = s.total
+	recorded.Oldest = s.oldestLocked()
+	return recorded, nil
+}
+func (s *BucketStore) Store(ctx context.Context, timestamp time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.add(timestamp.UnixNano(), 1)
+	s.journal.record(timestamp.UnixNano())
+	return nil
+}
+
+// View returns one entry per hit, each set to the start of its bucket. It
+// takes memory proportional to the count, so nothing but tests calls it.
+func (s *BucketStore) View(ctx context.Context) ([]time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return toTimes(s.expand()), nil
+}
+func (s *BucketStore) Count(ctx context.Context) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.total, nil
+}
+func (s *BucketStore) CountUnexpired(ctx context.Context, current time.Time, threshold time.Duration) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := current.UnixNano()
+	count := 0
+	for i, n := range s.counts {
+		if n > 0 && now-(s.starts[i]+s.bucketSize-1) < int64(threshold) {
+			count += n
+		}
+	}
+	return count, nil
+}
+// Oldest returns the newest instant of the oldest bucket, which is when that
+// bucket expires relative to the threshold.
+func (s *BucketStore) Oldest(ctx context.Context) (time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.oldestLocked(), nil
+}
+func (s *BucketStore) oldestLocked() time.Time {
+	var oldest int64
+	found := false
+	for i, count := range s.counts {
+		if count > 0 && (!found || s.starts[i] < oldest) {
+			oldest = s.starts[i]
+			found = true
+		}
+	}
+	if !found {
+		return time.Time{}
+	}
+	return time.Unix(0, oldest+s.bucketSize-1)
+}
+func (s *BucketStore) Load(ctx context.Context) error {
+	entries, err := s.journal.read(ctx)
+	if err != nil {
+		return err
+	}
+	pairs, timestamps, err := decodeBucketState(entries)
+	if err != nil {
+		return fmt.Errorf("failed to load timestamps: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.counts {
+		s.counts[i] = 0
+	}
+	s.total = 0
+	for i := 0; i < len(pairs); i += 2 {
+		s.add(pairs[i], int(pairs[i+1]))
+	}
+	for _, timestamp := range timestamps {
+		s.add(timestamp, 1)
+	}
+	s.journal.reset()
+	return nil
+}
+// RemoveExpired also resizes the ring when threshold differs from the one
+// the store was sized for, keeping the hits it holds.
+func (s *BucketStore) RemoveExpired(ctx context.Context, current time.Time, threshold time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.removeExpiredLocked(current, threshold)
+	return nil
+}
+func (s *BucketStore) removeExpiredLocked(current time.Time, threshold time.Duration) {
+	if buckets := bucketCount(threshold, s.bucketSize); buckets != len(s.counts) {
+		s.resize(buckets)
+	}
+	now := current.UnixNano()
+	expired := 0
+	for i, count := range s.counts {
+		if count > 0 && now-(s.starts[i]+s.bucketSize-1) >= int64(threshold) {
+			expired += count
+			s.counts[i] = 0
+		}
+	}
+	s.total -= expired
+	s.journal.expire(expired)
+}
+func (s *BucketStore) Sync(ctx context.Context) error {
+	return s.journal.sync(ctx)
+}
+func (s *BucketStore) Close() error {
+	return s.journal.close(context.Background())
+}
+
+// bucketCount includes one extra bucket for the partially elapsed bucket at
+// the window's edge.
+func bucketCount(threshold time.Duration, bucketSize int64) int {
+	return int((int64(threshold)+bucketSize-1)/bucketSize) + 1
+}
+
+// resize moves the buckets to a ring of n buckets, oldest first, so a
+// smaller ring keeps the newest. The caller must hold mu.
+func (s *BucketStore) resize(n int) {
+	order := s.order()
+	starts, counts := s.starts, s.counts
+	s.starts = make([]int64, n)
+	s.counts = make([]int, n)
+	s.total = 0
+	for _, i := range order {
+		s.add(starts[i], counts[i])
+	}
+	s.journal.dirty = true
+}
+
+// add counts n hits at timestamp.
+func (s *BucketStore) add(timestamp int64, n int) {
+	start := floorDiv(timestamp, s.bucketSize) * s.bucketSize
+	idx := int(floorMod(start/s.bucketSize, int64(len(s.counts))))
+	switch {
+	case s.counts[idx] == 0 || s.starts[idx] < start:
+		// The slot holds nothing or a bucket that is a full ring older.
+		s.total -= s.counts[idx]
+		s.counts[idx] = 0
+		s.starts[idx] = start
+	case s.starts[idx] > start:
+		// Too old for the window the ring covers.
+		return
+	}
+	s.counts[idx] += n
+	s.total += n
+}
+
+// order returns the indexes of the non-empty buckets, oldest first. The
+// caller must hold mu.
+func (s *BucketStore) order() []int {
+	order := make([]int, 0, len(s.counts))
+	for i, count := range s.counts {
+		if count > 0 {
+			order = append(order, i)
+		}
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return s.starts[order[a]] < s.starts[order[b]]
+	})
+	return order
+}
+
+// bucketStateTag starts a file holding buckets. It cannot be mistaken for a
+// timestamp, which files written before buckets were saved hold one per hit.
+const bucketStateTag int64 = math.MinInt64
+
+// state encodes the window for the journal: the tag, the number of buckets,
+// then the start and count of each, oldest first. The caller must hold mu.
+func (s *BucketStore) state() []int64 {
+	order := s.order()
+	state := make([]int64, 0, 2+2*len(order))
+	state = append(state, bucketStateTag, int64(len(order)))
+	for _, i := range order {
+		state = append(state, s.starts[i], int64(s.counts[i]))
+	}
+	return state
+}
+
+// decodeBucketState splits a file into its start and count pairs and the
+// single timestamps that follow them.
+func decodeBucketState(entries []int64) (pairs, timestamps []int64, err error) {
+	if len(entries) == 0 || entries[0] != bucketStateTag {
+		return nil, entries, nil
+	}
+	if len(entries) < 2 || entries[1] < 0 || entries[1] > int64(len(entries)-2)/2 {
+		return nil, nil, fmt.Errorf("%w: truncated bucket state", persistence.ErrCorruptFile)
+	}
+	end := 2 + 2*int(entries[1])
+	for i := 3; i < end; i += 2 {
+		if entries[i] < 0 {
+			return nil, nil, fmt.Errorf("%w: negative bucket count", persistence.ErrCorruptFile)
+		}
+	}
+	return entries[2:end], entries[end:], nil
+}
+
+// expandBucketState returns a file as one timestamp per hit, at the start of
+// its bucket for a file written by BucketStore, so the other stores can load
+// it after STORE changed.
+func expandBucketState(entries []int64) ([]int64, error) {
+	pairs, timestamps, err := decodeBucketState(entries)
+	if err != nil || pairs == nil {
+		return timestamps, err
+	}
+	var result []int64
+	for i := 0; i < len(pairs); i += 2 {
+		for n := int64(0); n < pairs[i+1]; n++ {
+			result = append(result, pairs[i])
+		}
+	}
+	return append(result, timestamps...), nil
+}
+
+// expand returns the window as individual timestamps in bucket order. The
+// caller must hold mu.
+func (s *BucketStore) expand() []int64 {
+	result := make([]int64, 0, s.total)
+	for _, i := range s.order() {
+		for n := 0; n < s.counts[i]; n++ {
+			result = append(result, s.starts[i])
+		}
+	}
+	return result
+}
+
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+func floorMod(a, b int64) int64 {
+	return a - floorDiv(a, b)*b
+}
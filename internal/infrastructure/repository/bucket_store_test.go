@@ -0,0 +1,338 @@
+package repository
+
+import (
+	"context"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"simplesurance/internal/domain"
+	"simplesurance/internal/infrastructure/persistence"
+)
+
+var bucketBase = time.Unix(1_700_000_000, 0)
+
+func at(seconds int) time.Time {
+	return bucketBase.Add(time.Duration(seconds) * time.Second)
+}
+
+func TestBucketStore_MatchesRoundedSlidingLog(t *testing.T) {
+	const threshold = 60 * time.Second
+	ctx := context.Background()
+	rng := rand.New(rand.NewSource(1))
+	memory := NewMemoryStore("test_bucket_ref.log", persistence.NewFilePersistence())
+	buckets := NewBucketStore("test_bucket.log", persistence.NewFilePersistence(), threshold, time.Second)
+
+	// The bucket store behaves like a sliding log whose timestamps are
+	// rounded up to the last instant of their bucket.
+	endOfBucket := time.Second - time.Nanosecond
+	current := 0
+	for step := 0; step < 2000; step++ {
+		current += rng.Intn(3)
+		burst := rng.Intn(4)
+		for store, offset := range map[domain.TimestampRepository]time.Duration{memory: endOfBucket, buckets: 0} {
+			if err := store.RemoveExpired(ctx, at(current), threshold); err != nil {
+				t.Fatalf("RemoveExpired() error = %v", err)
+			}
+			for i := 0; i < burst; i++ {
+				if err := store.Store(ctx, at(current).Add(offset)); err != nil {
+					t.Fatalf("Store() error = %v", err)
+				}
+			}
+		}
+
+		want, _ := memory.Count(ctx)
+		got, _ := buckets.Count(ctx)
+		if got != want {
+			t.Fatalf("step %d: Count() = %d, want %d", step, got, want)
+		}
+	}
+}
+
+func TestBucketStore_Granularity(t *testing.T) {
+	tests := []struct {
+		name       string
+		bucketSize time.Duration
+		timestamps []time.Time
+		current    time.Time
+		threshold  time.Duration
+		wantCount  int
+	}{
+		{
+			name:       "hits in same bucket share expiry",
+			bucketSize: 10 * time.Second,
+			timestamps: []time.Time{at(100), at(105), at(109)},
+			current:    at(169),
+			threshold:  60 * time.Second,
+			wantCount:  3,
+		},
+		{
+			name:       "bucket expires once its newest instant expired",
+			bucketSize: 10 * time.Second,
+			timestamps: []time.Time{at(100), at(105), at(109), at(110)},
+			current:    at(170),
+			threshold:  60 * time.Second,
+			wantCount:  1,
+		},
+		{
+			name:       "millisecond buckets",
+			bucketSize: 100 * time.Millisecond,
+			timestamps: []time.Time{at(0), at(0).Add(250 * time.Millisecond), at(1)},
+			current:    at(1).Add(600 * time.Millisecond),
+			threshold:  1500 * time.Millisecond,
+			wantCount:  2,
+		},
+		{
+			name:       "all buckets expired",
+			bucketSize: 5 * time.Second,
+			timestamps: []time.Time{at(100), at(101), at(102)},
+			current:    at(200),
+			threshold:  60 * time.Second,
+			wantCount:  0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewBucketStore("test_bucket.log", persistence.NewFilePersistence(), tt.threshold, tt.bucketSize)
+			ctx := context.Background()
+
+			for _, ts := range tt.timestamps {
+				if err := store.Store(ctx, ts); err != nil {
+					t.Fatalf("Store() error = %v", err)
+				}
+			}
+			if err := store.RemoveExpired(ctx, tt.current, tt.threshold); err != nil {
+				t.Fatalf("RemoveExpired() error = %v", err)
+			}
+
+			count, err := store.Count(ctx)
+			if err != nil {
+				t.Fatalf("Count() error = %v", err)
+			}
+			if count != tt.wantCount {
+				t.Errorf("Count() = %v, want %v", count, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestBucketStore_Oldest(t *testing.T) {
+	store := NewBucketStore("test_bucket.log", persistence.NewFilePersistence(), 60*time.Second, 10*time.Second)
+	ctx := context.Background()
+
+	if oldest, _ := store.Oldest(ctx); !oldest.IsZero() {
+		t.Errorf("Oldest() on empty store = %v, want zero time", oldest)
+	}
+	for _, ts := range []time.Time{at(125), at(103), at(131)} {
+		if err := store.Store(ctx, ts); err != nil {
+			t.Fatalf("Store() error = %v", err)
+		}
+	}
+
+	// The oldest bucket expires together with its newest possible hit.
+	want := at(110).Add(-time.Nanosecond)
+	if oldest, _ := store.Oldest(ctx); !oldest.Equal(want) {
+		t.Errorf("Oldest() = %v, want %v", oldest, want)
+	}
+}
+
+func TestBucketStore_BoundedMemory(t *testing.T) {
+	store := NewBucketStore("test_bucket.log", persistence.NewFilePersistence(), 60*time.Second, time.Second)
+	ctx := context.Background()
+
+	for ts := 0; ts < 10_000; ts++ {
+		for i := 0; i < 10; i++ {
+			if err := store.Store(ctx, at(ts)); err != nil {
+				t.Fatalf("Store() error = %v", err)
+			}
+		}
+	}
+
+	if len(store.counts) != 61 {
+		t.Errorf("bucket count = %d, want 61", len(store.counts))
+	}
+	count, _ := store.Count(ctx)
+	if count != 610 {
+		t.Errorf("Count() = %d, want 610 (the ring only holds the last 61 buckets)", count)
+	}
+}
+
+func TestBucketStore_ResizesWithThreshold(t *testing.T) {
+	store := NewBucketStore("", nil, 10*time.Second, time.Second)
+	ctx := context.Background()
+
+	for ts := 0; ts < 10; ts++ {
+		if err := store.Store(ctx, at(ts)); err != nil {
+			t.Fatalf("Store() error = %v", err)
+		}
+	}
+	// A longer window keeps the hits and has room for the older ones.
+	if err := store.RemoveExpired(ctx, at(10), 30*time.Second); err != nil {
+		t.Fatalf("RemoveExpired() error = %v", err)
+	}
+	for ts := 10; ts < 30; ts++ {
+		if err := store.Store(ctx, at(ts)); err != nil {
+			t.Fatalf("Store() error = %v", err)
+		}
+	}
+	if count, _ := store.Count(ctx); count != 30 || len(store.counts) != 31 {
+		t.Errorf("Count() = %d with %d buckets, want 30 with 31", count, len(store.counts))
+	}
+
+	// A shorter window keeps the newest hits.
+	if err := store.RemoveExpired(ctx, at(30), 5*time.Second); err != nil {
+		t.Fatalf("RemoveExpired() error = %v", err)
+	}
+	if count, _ := store.Count(ctx); count != 5 || len(store.counts) != 6 {
+		t.Errorf("Count() = %d with %d buckets, want 5 with 6", count, len(store.counts))
+	}
+}
+
+func TestBucketStore_SyncAndLoad(t *testing.T) {
+	filename := "test_bucket_sync.log"
+	defer os.Remove(filename)
+
+	ctx := context.Background()
+	store := NewBucketStore(filename, persistence.NewFilePersistence(), 60*time.Second, 10*time.Second)
+	for _, ts := range []time.Time{at(100), at(101), at(115), at(130)} {
+		if err := store.Store(ctx, ts); err != nil {
+			t.Fatalf("Store() error = %v", err)
+		}
+	}
+	if err := store.Sync(ctx); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	loaded := NewBucketStore(filename, persistence.NewFilePersistence(), 60*time.Second, 10*time.Second)
+	if err := loaded.Load(ctx); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	view, err := loaded.View(ctx)
+	if err != nil {
+		t.Fatalf("View() error = %v", err)
+	}
+	want := []time.Time{at(100), at(100), at(110), at(130)}
+	if len(view) != len(want) {
+		t.Fatalf("View() = %v, want %v", view, want)
+	}
+	for i := range want {
+		if !view[i].Equal(want[i]) {
+			t.Errorf("View()[%d] = %v, want %v", i, view[i], want[i])
+		}
+	}
+}
+
+func TestBucketStore_PersistsBuckets(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		// wantEntries counts the header, two entries per bucket and the
+		// timestamps appended since the last compaction.
+		wantEntries int
+	}{
+		{name: "rewrite", wantEntries: 2 + 2*61},
+		{name: "wal", opts: []Option{WithWAL(time.Hour)}, wantEntries: 2 + 2*60 + 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "buckets.log")
+			persister := persistence.NewBinaryPersistence()
+			ctx := context.Background()
+			store := NewBucketStore(filename, persister, 60*time.Second, time.Second, tt.opts...)
+			if err := store.Load(ctx); err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+
+			for ts := 0; ts < 100; ts++ {
+				for i := 0; i < 100; i++ {
+					store.Store(ctx, at(ts))
+				}
+			}
+			store.RemoveExpired(ctx, at(100), 60*time.Second)
+			if err := store.Sync(ctx); err != nil {
+				t.Fatalf("Sync() error = %v", err)
+			}
+			for i := 0; i < 5; i++ {
+				store.Store(ctx, at(100))
+			}
+			if err := store.Sync(ctx); err != nil {
+				t.Fatalf("Sync() error = %v", err)
+			}
+
+			entries, err := persister.ReadAll(ctx, filename)
+			if err != nil {
+				t.Fatalf("ReadAll() error = %v", err)
+			}
+			if len(entries) != tt.wantEntries {
+				t.Errorf("file holds %d entries for 10005 hits, want %d", len(entries), tt.wantEntries)
+			}
+
+			loaded := NewBucketStore(filename, persister, 60*time.Second, time.Second)
+			if err := loaded.Load(ctx); err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			want, _ := store.Count(ctx)
+			if count, _ := loaded.Count(ctx); count != want {
+				t.Errorf("Count() after Load() = %d, want %d", count, want)
+			}
+			if oldest, _ := loaded.Oldest(ctx); !oldest.Equal(at(41).Add(-time.Nanosecond)) {
+				t.Errorf("Oldest() after Load() = %v, want the end of the bucket at 40s", oldest)
+			}
+		})
+	}
+}
+
+func TestBucketStore_LoadsTimestampFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "timestamps.log")
+	persister := persistence.NewFilePersistence()
+	ctx := context.Background()
+	if err := persister.Rewrite(ctx, []int64{at(100).UnixNano(), at(101).UnixNano(), at(115).UnixNano()}, filename); err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewBucketStore(filename, persister, 60*time.Second, 10*time.Second)
+	if err := store.Load(ctx); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if count, _ := store.Count(ctx); count != 3 {
+		t.Errorf("Count() = %d, want 3", count)
+	}
+	if err := store.Sync(ctx); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	entries, _ := persister.ReadAll(ctx, filename)
+	want := []int64{bucketStateTag, 2, at(100).UnixNano(), 2, at(110).UnixNano(), 1}
+	if !slices.Equal(entries, want) {
+		t.Errorf("file after Sync() = %v, want the buckets %v", entries, want)
+	}
+}
+
+func TestBucketStore_FileLoadsIntoOtherStores(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "buckets.log")
+	persister := persistence.NewBinaryPersistence()
+	ctx := context.Background()
+	buckets := NewBucketStore(filename, persister, 60*time.Second, 10*time.Second)
+	for _, ts := range []time.Time{at(100), at(101), at(115)} {
+		buckets.Store(ctx, ts)
+	}
+	if err := buckets.Sync(ctx); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	for name, store := range map[string]domain.TimestampRepository{
+		"ring":    NewMemoryStore(filename, persister, WithRingBuffer()),
+		"sharded": NewShardedStore(filename, persister, 2),
+	} {
+		if err := store.Load(ctx); err != nil {
+			t.Fatalf("%s: Load() error = %v", name, err)
+		}
+		view, _ := store.View(ctx)
+		if want := []time.Time{at(100), at(100), at(110)}; !slices.EqualFunc(view, want, time.Time.Equal) {
+			t.Errorf("%s: View() = %v, want %v", name, view, want)
+		}
+	}
+}
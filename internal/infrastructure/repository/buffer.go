@@ -0,0 +1,152 @@
+package repository
+
+import (
+	"slices"
+	"sort"
+)
+
+// timestampBuffer holds the in-memory window of a MemoryStore.
+type timestampBuffer interface {
+	push(timestamp int64)
+	// expire drops entries with current-timestamp >= threshold and returns how many were dropped.
+	expire(current, threshold int64) int
+	// unexpired counts the entries expire would keep, leaving them all in place.
+	unexpired(current, threshold int64) int
+	len() int
+	// oldest returns the smallest timestamp, or false if the buffer is empty.
+	oldest() (int64, bool)
+	snapshot() []int64
+	reset(timestamps []int64)
+}
+
+// sliceBuffer scans the whole window on every expiry. It makes no assumption
+// about ordering.
+type sliceBuffer struct {
+	timestamps []int64
+}
+
+func (b *sliceBuffer) push(timestamp int64) {
+	b.timestamps = append(b.timestamps, timestamp)
+}
+func (b *sliceBuffer) expire(current, threshold int64) int {
+	validTimestamps := make([]int64, 0, len(b.timestamps))
+	for _, timestamp := range b.timestamps {
+		if current-timestamp < threshold {
+			validTimestamps = append(validTimestamps, timestamp)
+		}
+	}
+	expired := len(b.timestamps) - len(validTimestamps)
+	if len(validTimestamps) < cap(validTimestamps) {
+		trimmed := make([]int64, len(validTimestamps))
+		copy(trimmed, validTimestamps)
+		b.timestamps = trimmed
+	} else {
+		b.timestamps = validTimestamps
+	}
+	return expired
+}
+func (b *sliceBuffer) unexpired(current, threshold int64) int {
+	count := 0
+	for _, timestamp := range b.timestamps {
+		if current-timestamp < threshold {
+			count++
+		}
+	}
+	return count
+}
+func (b *sliceBuffer) len() int {
+	return len(b.timestamps)
+}
+func (b *sliceBuffer) oldest() (int64, bool) {
+	if len(b.timestamps) == 0 {
+		return 0, false
+	}
+	return slices.Min(b.timestamps), true
+}
+func (b *sliceBuffer) snapshot() []int64 {
+	result := make([]int64, len(b.timestamps))
+	copy(result, b.timestamps)
+	return result
+}
+func (b *sliceBuffer) reset(timestamps []int64) {
+	b.timestamps = timestamps
+}
+
+const minRingCapacity = 16
+
+// ringBuffer keeps its entries in order, so expiry only pops from the
+// front: a request costs amortized O(1) plus the number of expired entries.
+// A timestamp older than the newest entry, e.g. after the wall clock was
+// stepped back, is inserted in place, which also costs the number of newer
+// entries it is moved past.
+type ringBuffer struct {
+	items []int64
+	head  int
+	size  int
+}
+
+func (b *ringBuffer) push(timestamp int64) {
+	if b.size == len(b.items) {
+		b.resize(max(2*len(b.items), minRingCapacity))
+	}
+	i := b.size
+	for ; i > 0 && b.items[(b.head+i-1)%len(b.items)] > timestamp; i-- {
+		b.items[(b.head+i)%len(b.items)] = b.items[(b.head+i-1)%len(b.items)]
+	}
+	b.items[(b.head+i)%len(b.items)] = timestamp
+	b.size++
+}
+func (b *ringBuffer) expire(current, threshold int64) int {
+	expired := 0
+	for b.size > 0 && current-b.items[b.head] >= threshold {
+		b.head = (b.head + 1) % len(b.items)
+		b.size--
+		expired++
+	}
+	if b.size == 0 {
+		b.head = 0
+	}
+	if len(b.items) > minRingCapacity && b.size < len(b.items)/4 {
+		b.resize(max(len(b.items)/2, minRingCapacity))
+	}
+	return expired
+}
+func (b *ringBuffer) unexpired(current, threshold int64) int {
+	expired := sort.Search(b.size, func(i int) bool {
+		return current-b.items[(b.head+i)%len(b.items)] < threshold
+	})
+	return b.size - expired
+}
+func (b *ringBuffer) len() int {
+	return b.size
+}
+func (b *ringBuffer) oldest() (int64, bool) {
+	if b.size == 0 {
+		return 0, false
+	}
+	return b.items[b.head], true
+}
+func (b *ringBuffer) snapshot() []int64 {
+	result := make([]int64, b.size)
+	n := copy(result, b.items[b.head:min(b.head+b.size, len(b.items))])
+	copy(result[n:], b.items[:b.size-n])
+	return result
+}
+func (b *ringBuffer) reset(timestamps []int64) {
+	b.items = make([]int64, max(len(timestamps), minRingCapacity))
+	copy(b.items, timestamps)
+	// Files written concurrently may hold slightly out-of-order entries.
+	slices.Sort(b.items[:len(timestamps)])
+	b.head = 0
+	b.size = len(timestamps)
+}
+
+func (b *ringBuffer) resize(capacity int) {
+	items := make([]int64, capacity)
+	if b.size > 0 {
+		n := copy(items, b.items[b.head:min(b.head+b.size, len(b.items))])
+		copy(items[n:], b.items[:b.size-n])
+	}
+	b.items = items
+	b.head = 0
+}
@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"math/rand"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestRingBuffer_MatchesSliceBuffer(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold int64
+		steps     int
+		maxBurst  int
+		// stepBack, if set, makes the clock go back by up to that much now
+		// and then, like a wall clock corrected by NTP.
+		stepBack int64
+	}{
+		{name: "short window", threshold: 3, steps: 2000, maxBurst: 5},
+		{name: "long window", threshold: 200, steps: 2000, maxBurst: 20},
+		{name: "idle gaps empty the window", threshold: 2, steps: 500, maxBurst: 1},
+		{name: "clock stepped back", threshold: 50, steps: 2000, maxBurst: 5, stepBack: 80},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rng := rand.New(rand.NewSource(1))
+			slice := &sliceBuffer{}
+			ring := &ringBuffer{}
+			current := int64(0)
+
+			for step := 0; step < tt.steps; step++ {
+				current += rng.Int63n(3)
+				if tt.stepBack > 0 && rng.Intn(20) == 0 {
+					current -= rng.Int63n(tt.stepBack)
+				}
+				for i := rng.Intn(tt.maxBurst + 1); i > 0; i-- {
+					slice.push(current)
+					ring.push(current)
+				}
+
+				if got, want := ring.unexpired(current, tt.threshold), slice.unexpired(current, tt.threshold); got != want {
+					t.Fatalf("step %d: unexpired() = %d, want %d", step, got, want)
+				}
+				sliceExpired := slice.expire(current, tt.threshold)
+				ringExpired := ring.expire(current, tt.threshold)
+				if sliceExpired != ringExpired {
+					t.Fatalf("step %d: expire() = %d, want %d", step, ringExpired, sliceExpired)
+				}
+				if ring.len() != slice.len() {
+					t.Fatalf("step %d: len() = %d, want %d", step, ring.len(), slice.len())
+				}
+				ringOldest, ringOK := ring.oldest()
+				sliceOldest, sliceOK := slice.oldest()
+				if ringOldest != sliceOldest || ringOK != sliceOK {
+					t.Fatalf("step %d: oldest() = %d, %v, want %d, %v", step, ringOldest, ringOK, sliceOldest, sliceOK)
+				}
+			}
+
+			want := slice.snapshot()
+			slices.Sort(want)
+			if !reflect.DeepEqual(ring.snapshot(), want) {
+				t.Errorf("snapshot() = %v, want %v", ring.snapshot(), want)
+			}
+		})
+	}
+}
+
+func TestRingBuffer_Reset(t *testing.T) {
+	ring := &ringBuffer{}
+	ring.reset([]int64{5, 3, 4, 10})
+
+	if got := ring.snapshot(); !reflect.DeepEqual(got, []int64{3, 4, 5, 10}) {
+		t.Errorf("snapshot() after reset() = %v, want sorted entries", got)
+	}
+	if expired := ring.expire(10, 6); expired != 2 {
+		t.Errorf("expire() = %d, want 2", expired)
+	}
+	if got := ring.snapshot(); !reflect.DeepEqual(got, []int64{5, 10}) {
+		t.Errorf("snapshot() after expire() = %v, want [5 10]", got)
+	}
+}
+
+const benchWindow = 1_000_000
+
+func newFullBuffer(b *testing.B, buffer timestampBuffer) timestampBuffer {
+	b.Helper()
+	for i := int64(0); i < benchWindow; i++ {
+		buffer.push(i)
+	}
+	return buffer
+}
+
+// BenchmarkBuffer_Record simulates one request against a window holding 1M
+// entries: one entry expires and one is stored.
+func BenchmarkBuffer_Record(b *testing.B) {
+	buffers := map[string]func() timestampBuffer{
+		"slice": func() timestampBuffer { return &sliceBuffer{} },
+		"ring":  func() timestampBuffer { return &ringBuffer{} },
+	}
+	for name, newBuffer := range buffers {
+		b.Run(name, func(b *testing.B) {
+			buffer := newFullBuffer(b, newBuffer())
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				current := benchWindow + int64(i)
+				buffer.expire(current, benchWindow)
+				buffer.push(current)
+			}
+		})
+	}
+}
+
+// BenchmarkBuffer_ExpireNothing measures the cost of a request when nothing
+// in the 1M entry window has expired.
+func BenchmarkBuffer_ExpireNothing(b *testing.B) {
+	buffers := map[string]func() timestampBuffer{
+		"slice": func() timestampBuffer { return &sliceBuffer{} },
+		"ring":  func() timestampBuffer { return &ringBuffer{} },
+	}
+	for name, newBuffer := range buffers {
+		b.Run(name, func(b *testing.B) {
+			buffer := newFullBuffer(b, newBuffer())
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				buffer.expire(benchWindow, 2*benchWindow)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"simplesurance/internal/domain"
+)
+
+type DurabilityMode string
+
+const (
+	// DurabilityAlways fsyncs the log before every Sync returns.
+	DurabilityAlways DurabilityMode = "always"
+	// DurabilityBatch shares a single fsync between concurrent Sync callers.
+	DurabilityBatch DurabilityMode = "batch"
+	// DurabilityInterval fsyncs in the background every Interval; Sync never waits.
+	DurabilityInterval DurabilityMode = "interval"
+	// DurabilityNone leaves flushing to the operating system.
+	DurabilityNone DurabilityMode = "none"
+)
+
+type Durability struct {
+	Mode     DurabilityMode
+	Interval time.Duration
+}
+
+// WithDurability sets how appended timestamps are flushed to stable storage.
+// It only affects ModeWAL; rewrites are always fsynced.
+func WithDurability(d Durability) Option {
+	return func(o *options) {
+		o.durability = d
+	}
+}
+
+// committer makes appended data durable according to a Durability policy.
+type committer interface {
+	commit(ctx context.Context) error
+	close() error
+}
+
+func newCommitter(d Durability, clock domain.Clock, fsync func(context.Context) error) committer {
+	switch d.Mode {
+	case DurabilityBatch:
+		return &groupCommitter{fsync: fsync}
+	case DurabilityInterval:
+		return newIntervalCommitter(d.Interval, clock, fsync)
+	case DurabilityNone:
+		return noopCommitter{}
+	default:
+		return syncCommitter{fsync: fsync}
+	}
+}
+
+type syncCommitter struct {
+	fsync func(context.Context) error
+}
+
+func (c syncCommitter) commit(ctx context.Context) error {
+	return c.fsync(ctx)
+}
+func (c syncCommitter) close() error {
+	return nil
+}
+
+type noopCommitter struct{}
+
+func (noopCommitter) commit(ctx context.Context) error {
+	return nil
+}
+func (noopCommitter) close() error {
+	return nil
+}
+
+// groupCommitter coalesces concurrent commits: callers that arrive while an
+// fsync is running join the next batch and share its result.
+type groupCommitter struct {
+	fsync    func(context.Context) error
+	mu       sync.Mutex
+	next     *commitBatch
+	flushing bool
+}
+
+type commitBatch struct {
+	done chan struct{}
+	err  error
+}
+
+func (c *groupCommitter) commit(ctx context.Context) error {
+	c.mu.Lock()
+	if c.next == nil {
+		c.next = &commitBatch{done: make(chan struct{})}
+	}
+	batch := c.next
+	if !c.flushing {
+		c.flushing = true
+		go c.flushLoop()
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-batch.done:
+		return batch.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+func (c *groupCommitter) close() error {
+	return nil
+}
+
+func (c *groupCommitter) flushLoop() {
+	for {
+		c.mu.Lock()
+		batch := c.next
+		if batch == nil {
+			c.flushing = false
+			c.mu.Unlock()
+			return
+		}
+		c.next = nil
+		c.mu.Unlock()
+
+		batch.err = c.fsync(context.Background())
+		close(batch.done)
+	}
+}
+
+// intervalCommitter fsyncs from a background goroutine. A failed background
+// fsync is reported to the next caller of commit.
+type intervalCommitter struct {
+	fsync func(context.Context) error
+	stop  chan struct{}
+	done  chan struct{}
+	mu    sync.Mutex
+	dirty bool
+	err   error
+}
+
+func newIntervalCommitter(interval time.Duration, clock domain.Clock, fsync func(context.Context) error) *intervalCommitter {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	c := &intervalCommitter{
+		fsync: fsync,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go c.run(clock.NewTicker(interval))
+	return c
+}
+
+func (c *intervalCommitter) commit(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.dirty = true
+	err := c.err
+	c.err = nil
+	return err
+}
+func (c *intervalCommitter) close() error {
+	close(c.stop)
+	<-c.done
+	return c.flush()
+}
+
+func (c *intervalCommitter) run(ticker domain.Ticker) {
+	defer close(c.done)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C():
+			if err := c.flush(); err != nil {
+				c.mu.Lock()
+				c.err = err
+				c.mu.Unlock()
+			}
+		}
+	}
+}
+
+func (c *intervalCommitter) flush() error {
+	c.mu.Lock()
+	dirty := c.dirty
+	c.dirty = false
+	c.mu.Unlock()
+
+	if !dirty {
+		return nil
+	}
+	if err := c.fsync(context.Background()); err != nil {
+		c.mu.Lock()
+		c.dirty = true
+		c.mu.Unlock()
+		return err
+	}
+	return nil
+}
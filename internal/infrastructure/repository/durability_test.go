@@ -0,0 +1,155 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"simplesurance/internal/clock"
+)
+
+func TestCommitter_Modes(t *testing.T) {
+	tests := []struct {
+		name       string
+		durability Durability
+		commits    int
+		wantInline int32
+		wantClosed int32
+	}{
+		{
+			name:       "always fsyncs every commit",
+			durability: Durability{Mode: DurabilityAlways},
+			commits:    3,
+			wantInline: 3,
+			wantClosed: 3,
+		},
+		{
+			name:       "interval defers fsync to close",
+			durability: Durability{Mode: DurabilityInterval, Interval: time.Hour},
+			commits:    3,
+			wantInline: 0,
+			wantClosed: 1,
+		},
+		{
+			name:       "none never fsyncs",
+			durability: Durability{Mode: DurabilityNone},
+			commits:    3,
+			wantInline: 0,
+			wantClosed: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fsyncs atomic.Int32
+			c := newCommitter(tt.durability, clock.System{}, func(ctx context.Context) error {
+				fsyncs.Add(1)
+				return nil
+			})
+
+			for i := 0; i < tt.commits; i++ {
+				if err := c.commit(context.Background()); err != nil {
+					t.Fatalf("commit() error = %v", err)
+				}
+			}
+			if got := fsyncs.Load(); got != tt.wantInline {
+				t.Errorf("fsyncs after commit() = %v, want %v", got, tt.wantInline)
+			}
+
+			if err := c.close(); err != nil {
+				t.Fatalf("close() error = %v", err)
+			}
+			if got := fsyncs.Load(); got != tt.wantClosed {
+				t.Errorf("fsyncs after close() = %v, want %v", got, tt.wantClosed)
+			}
+		})
+	}
+}
+
+func TestGroupCommitter_CoalescesConcurrentCommits(t *testing.T) {
+	var fsyncs atomic.Int32
+	release := make(chan struct{})
+	c := newCommitter(Durability{Mode: DurabilityBatch}, clock.System{}, func(ctx context.Context) error {
+		if fsyncs.Add(1) == 1 {
+			<-release
+		}
+		return nil
+	})
+
+	first := make(chan error, 1)
+	go func() { first <- c.commit(context.Background()) }()
+	for fsyncs.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	const callers = 50
+	var wg sync.WaitGroup
+	errs := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- c.commit(context.Background())
+		}()
+	}
+	// Give the callers time to queue behind the in-flight fsync.
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	wg.Wait()
+	close(errs)
+	if err := <-first; err != nil {
+		t.Fatalf("commit() error = %v", err)
+	}
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("commit() error = %v", err)
+		}
+	}
+	if got := fsyncs.Load(); got != 2 {
+		t.Errorf("fsyncs = %v, want 2 (one in flight, one shared by the waiting batch)", got)
+	}
+}
+
+func TestGroupCommitter_PropagatesError(t *testing.T) {
+	wantErr := errors.New("fsync failed")
+	c := newCommitter(Durability{Mode: DurabilityBatch}, clock.System{}, func(ctx context.Context) error {
+		return wantErr
+	})
+
+	if err := c.commit(context.Background()); !errors.Is(err, wantErr) {
+		t.Errorf("commit() error = %v, want %v", err, wantErr)
+	}
+}
+
+func TestIntervalCommitter_ReportsBackgroundError(t *testing.T) {
+	wantErr := errors.New("fsync failed")
+	fake := clock.NewFake(time.Unix(1_700_000_000, 0))
+	c := newCommitter(Durability{Mode: DurabilityInterval, Interval: time.Second}, fake, func(ctx context.Context) error {
+		return wantErr
+	})
+	defer c.close()
+
+	if err := c.commit(context.Background()); err != nil {
+		t.Fatalf("first commit() error = %v", err)
+	}
+	if err := c.commit(context.Background()); err != nil {
+		t.Fatalf("commit() before the interval elapsed error = %v", err)
+	}
+	fake.Advance(time.Second)
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if err := c.commit(context.Background()); err != nil {
+			if !errors.Is(err, wantErr) {
+				t.Fatalf("commit() error = %v, want %v", err, wantErr)
+			}
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Error("commit() never reported the background fsync error")
+}
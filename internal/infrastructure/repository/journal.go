@@ -0,0 +1,326 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"simplesurance/internal/clock"
+	"simplesurance/internal/domain"
+	"simplesurance/internal/infrastructure/persistence"
+	"simplesurance/internal/logging"
+	"simplesurance/internal/tracing"
+)
+
+type PersistenceMode string
+
+const (
+	// ModeRewrite rewrites the whole file on every Sync.
+	ModeRewrite PersistenceMode = "rewrite"
+	// ModeWAL appends new timestamps on Sync and rewrites the file only when compacting.
+	ModeWAL PersistenceMode = "wal"
+)
+
+const DefaultCompactInterval = time.Minute
+
+type options struct {
+	mode            PersistenceMode
+	compactInterval time.Duration
+	durability      Durability
+	ringBuffer      bool
+	clock           domain.Clock
+	metrics         *storeMetrics
+	onSync          func(error)
+}
+
+type Option func(*options)
+
+// WithWAL appends on Sync and compacts the file on the first Sync after
+// compactInterval, whether or not anything is pending, so a periodic Sync
+// keeps an idle file compacted. A non-positive interval keeps
+// DefaultCompactInterval.
+func WithWAL(compactInterval time.Duration) Option {
+	return func(o *options) {
+		o.mode = ModeWAL
+		if compactInterval > 0 {
+			o.compactInterval = compactInterval
+		}
+	}
+}
+
+// WithClock sets the clock used to schedule compactions and interval fsyncs.
+func WithClock(c domain.Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+// WithSyncObserver calls fn with the outcome of every sync that writes to the
+// file or fails. Syncs with nothing to write are not reported, so they do not
+// pass for a working disk.
+func WithSyncObserver(fn func(err error)) Option {
+	return func(o *options) {
+		o.onSync = fn
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		mode:            ModeRewrite,
+		compactInterval: DefaultCompactInterval,
+		durability:      Durability{Mode: DurabilityAlways},
+		clock:           clock.System{},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// journal writes a store's timestamps to its file, either by rewriting it on
+// every sync or by appending new entries and compacting periodically. A nil
+// persister keeps the store in memory only.
+type journal struct {
+	fileName        string
+	persister       persistence.FilePersistence
+	mode            PersistenceMode
+	compactInterval time.Duration
+	committer       committer
+	clock           domain.Clock
+	metrics         *storeMetrics
+	onSync          func(error)
+
+	// lock guards pending and dirty. snapshotLock is held while snapshot is
+	// called and must hold lock as well, so a compaction never loses or
+	// duplicates entries. Stores with one mutex pass it as both.
+	lock         sync.Locker
+	snapshotLock sync.Locker
+	snapshot     func() []int64
+	// order puts a snapshot in file order after the locks are released, if
+	// snapshot does not return it so.
+	order   func([]int64)
+	pending []int64
+	// dirty is set while the window differs from the last rewrite, so
+	// rewrite mode skips writing an unchanged window. Guarded by lock.
+	dirty bool
+
+	syncMu         sync.Mutex
+	lastCompaction time.Time
+}
+
+func newJournal(fileName string, persister persistence.FilePersistence, o options, lock, snapshotLock sync.Locker, snapshot func() []int64) *journal {
+	j := &journal{
+		fileName:        fileName,
+		persister:       persister,
+		mode:            o.mode,
+		compactInterval: o.compactInterval,
+		clock:           o.clock,
+		metrics:         o.metrics,
+		onSync:          o.onSync,
+		lock:            lock,
+		snapshotLock:    snapshotLock,
+		snapshot:        snapshot,
+		dirty:           true,
+	}
+	if j.mode == ModeWAL && persister != nil {
+		j.committer = newCommitter(o.durability, o.clock, func(ctx context.Context) error {
+			return traceFile(ctx, "fsync", fileName, func(ctx context.Context) error {
+				return persister.Fsync(ctx, fileName)
+			})
+		})
+	}
+	return j
+}
+
+// record queues a stored timestamp for the next append. The caller must hold lock.
+func (j *journal) record(timestamp int64) {
+	j.dirty = true
+	if j.mode == ModeWAL && j.persister != nil {
+		j.pending = append(j.pending, timestamp)
+	}
+}
+
+// expire counts n timestamps dropped from the window. The caller must hold lock.
+func (j *journal) expire(n int) {
+	if n > 0 {
+		j.dirty = true
+	}
+	j.metrics.expire(n)
+}
+
+func (j *journal) read(ctx context.Context) ([]int64, error) {
+	if j.persister == nil {
+		return nil, nil
+	}
+	j.syncMu.Lock()
+	defer j.syncMu.Unlock()
+
+	var timestamps []int64
+	err := traceFile(ctx, "read", j.fileName, func(ctx context.Context) (err error) {
+		timestamps, err = j.persister.ReadAll(ctx, j.fileName)
+		return err
+	})
+	if j.metrics.failed("load", err) != nil {
+		return nil, fmt.Errorf("failed to load timestamps: %w", err)
+	}
+	// Entries on disk may already be expired; force the next WAL sync to compact.
+	j.lastCompaction = time.Time{}
+	return timestamps, nil
+}
+
+// reset drops queued appends after the store reloaded its window. The caller must hold lock.
+func (j *journal) reset() {
+	j.pending = nil
+}
+
+func (j *journal) sync(ctx context.Context) error {
+	if j.persister == nil {
+		return nil
+	}
+	ctx, span := tracing.Start(ctx, "repository.sync", tracing.Attribute{Key: "persistence.mode", Value: string(j.mode)})
+	defer span.End()
+
+	start := j.clock.Now()
+	wrote, err := j.flush(ctx)
+	err = j.metrics.failed("sync", err)
+	j.metrics.observeSync(j.clock.Now().Sub(start))
+	if j.onSync != nil && (wrote || err != nil) {
+		j.onSync(err)
+	}
+	span.RecordError(err)
+	return err
+}
+
+// flush reports whether it wrote to the file, which it does not when
+// nothing changed since the last flush.
+func (j *journal) flush(ctx context.Context) (bool, error) {
+	if j.mode != ModeWAL {
+		j.syncMu.Lock()
+		defer j.syncMu.Unlock()
+		return j.rewrite(ctx)
+	}
+
+	wrote, err := j.appendPending(ctx)
+	if err != nil {
+		return wrote, err
+	}
+	// Committing outside syncMu lets concurrent callers share an fsync.
+	if err := j.committer.commit(ctx); err != nil {
+		return wrote, fmt.Errorf("failed to commit timestamps: %w", err)
+	}
+	return wrote, nil
+}
+
+func (j *journal) compact(ctx context.Context) error {
+	if j.persister == nil {
+		return nil
+	}
+	j.syncMu.Lock()
+	defer j.syncMu.Unlock()
+
+	return j.metrics.failed("compact", j.compactLocked(ctx))
+}
+
+func (j *journal) close(ctx context.Context) error {
+	if j.persister == nil {
+		return nil
+	}
+	if j.mode != ModeWAL {
+		return j.sync(ctx)
+	}
+
+	compactErr := j.compact(ctx)
+	if err := j.metrics.failed("close", j.committer.close()); err != nil && compactErr == nil {
+		return fmt.Errorf("failed to commit timestamps: %w", err)
+	}
+	return compactErr
+}
+
+func (j *journal) appendPending(ctx context.Context) (bool, error) {
+	j.syncMu.Lock()
+	defer j.syncMu.Unlock()
+
+	// Checked before anything is pending too: the worker's periodic Sync is
+	// what compacts a file that no longer receives hits.
+	if j.clock.Now().Sub(j.lastCompaction) >= j.compactInterval {
+		return true, j.compactLocked(ctx)
+	}
+
+	j.lock.Lock()
+	pending := j.pending
+	j.pending = nil
+	j.lock.Unlock()
+
+	for i, timestamp := range pending {
+		err := traceFile(ctx, "append", j.fileName, func(ctx context.Context) error {
+			return j.persister.Append(ctx, timestamp, j.fileName)
+		})
+		if err != nil {
+			j.requeue(pending[i:])
+			logging.FromContext(ctx).Warn("requeued unwritten timestamps", "file", j.fileName, "pending", len(pending)-i, "error", err)
+			return true, fmt.Errorf("failed to append timestamp: %w", err)
+		}
+	}
+
+	return len(pending) > 0, nil
+}
+
+func (j *journal) compactLocked(ctx context.Context) error {
+	j.snapshotLock.Lock()
+	timestamps := j.snapshot()
+	pending := j.pending
+	j.pending = nil
+	j.snapshotLock.Unlock()
+	if j.order != nil {
+		j.order(timestamps)
+	}
+
+	if err := j.writeAll(ctx, timestamps); err != nil {
+		j.requeue(pending)
+		return fmt.Errorf("failed to compact timestamps: %w", err)
+	}
+	logging.FromContext(ctx).Debug("compacted log", "file", j.fileName, "entries", len(timestamps))
+
+	j.lastCompaction = j.clock.Now()
+	return nil
+}
+
+func (j *journal) rewrite(ctx context.Context) (bool, error) {
+	j.lock.Lock()
+	dirty := j.dirty
+	j.lock.Unlock()
+	if !dirty {
+		return false, nil
+	}
+
+	j.snapshotLock.Lock()
+	j.dirty = false
+	timestamps := j.snapshot()
+	j.snapshotLock.Unlock()
+	if j.order != nil {
+		j.order(timestamps)
+	}
+
+	if err := j.writeAll(ctx, timestamps); err != nil {
+		j.lock.Lock()
+		j.dirty = true
+		j.lock.Unlock()
+		return true, fmt.Errorf("failed to sync timestamps: %w", err)
+	}
+
+	return true, nil
+}
+
+func (j *journal) writeAll(ctx context.Context, timestamps []int64) error {
+	return traceFile(ctx, "rewrite", j.fileName, func(ctx context.Context) error {
+		return j.persister.Rewrite(ctx, timestamps, j.fileName)
+	})
+}
+
+func (j *journal) requeue(timestamps []int64) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	j.pending = append(append([]int64(nil), timestamps...), j.pending...)
+}
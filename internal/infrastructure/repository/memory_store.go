@@ -4,45 +4,99 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"time"
 
+	"simplesurance/internal/domain"
 	"simplesurance/internal/infrastructure/persistence"
 )
+
+// WithRingBuffer keeps the window in a ring buffer so expiry only pops
+// expired entries from the front instead of scanning the whole window.
+// Timestamps must be stored in non-decreasing order.
+func WithRingBuffer() Option {
+	return func(o *options) {
+		o.ringBuffer = true
+	}
+}
+
 type MemoryStore struct {
-	timestamps []int
-	fileName   string
-	persister  persistence.FilePersistence
-	mu         sync.RWMutex
-}
-func NewMemoryStore(fileName string, persister persistence.FilePersistence) *MemoryStore {
-	return &MemoryStore{
-		timestamps: make([]int, 0),
-		fileName:   fileName,
-		persister:  persister,
+	buffer  timestampBuffer
+	journal *journal
+	mu      sync.RWMutex
+}
+
+func NewMemoryStore(fileName string, persister persistence.FilePersistence, opts ...Option) *MemoryStore {
+	o := newOptions(opts)
+	s := &MemoryStore{
+		buffer: &sliceBuffer{timestamps: make([]int64, 0)},
+	}
+	if o.ringBuffer {
+		s.buffer = &ringBuffer{}
 	}
+	s.journal = newJournal(fileName, persister, o, &s.mu, &s.mu, s.buffer.snapshot)
+	return s
 }
-func (s *MemoryStore) Store(ctx context.Context, timestamp int) error {
+func (s *MemoryStore) Record(ctx context.Context, now func() time.Time, threshold time.Duration, limit int) (domain.Recorded, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.timestamps = append(s.timestamps, timestamp)
+	current := now()
+	s.journal.expire(s.buffer.expire(current.UnixNano(), int64(threshold)))
+	recorded := domain.Recorded{Timestamp: current}
+	if limit <= 0 || s.buffer.len() < limit {
+		s.buffer.push(current.UnixNano())
+		s.journal.record(current.UnixNano())
+		recorded.Stored = true
+	}
+	recorded.Count = s.buffer.len()
+	recorded.Oldest = s.oldestLocked()
+	return recorded, nil
+}
+func (s *MemoryStore) Store(ctx context.Context, timestamp time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.buffer.push(timestamp.UnixNano())
+	s.journal.record(timestamp.UnixNano())
 	return nil
 }
-func (s *MemoryStore) View(ctx context.Context) ([]int, error) {
+func (s *MemoryStore) View(ctx context.Context) ([]time.Time, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	result := make([]int, len(s.timestamps))
-	copy(result, s.timestamps)
-	return result, nil
+	return toTimes(s.buffer.snapshot()), nil
 }
 func (s *MemoryStore) Count(ctx context.Context) (int, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	return len(s.timestamps), nil
+	return s.buffer.len(), nil
+}
+func (s *MemoryStore) CountUnexpired(ctx context.Context, current time.Time, threshold time.Duration) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.buffer.unexpired(current.UnixNano(), int64(threshold)), nil
+}
+func (s *MemoryStore) Oldest(ctx context.Context) (time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.oldestLocked(), nil
+}
+func (s *MemoryStore) oldestLocked() time.Time {
+	oldest, ok := s.buffer.oldest()
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(0, oldest)
 }
 func (s *MemoryStore) Load(ctx context.Context) error {
-	timestamps, err := s.persister.ReadAll(ctx, s.fileName)
+	entries, err := s.journal.read(ctx)
+	if err != nil {
+		return err
+	}
+	timestamps, err := expandBucketState(entries)
 	if err != nil {
 		return fmt.Errorf("failed to load timestamps: %w", err)
 	}
@@ -50,43 +104,34 @@ func (s *MemoryStore) Load(ctx context.Context) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.timestamps = timestamps
+	s.buffer.reset(timestamps)
+	s.journal.reset()
 	return nil
 }
-func (s *MemoryStore) RemoveExpired(ctx context.Context, current, threshold int) error {
+func (s *MemoryStore) RemoveExpired(ctx context.Context, current time.Time, threshold time.Duration) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	validTimestamps := make([]int, 0, len(s.timestamps))
-	for _, timestamp := range s.timestamps {
-		if current-timestamp < threshold {
-			validTimestamps = append(validTimestamps, timestamp)
-		}
-	}
-	if len(validTimestamps) < cap(validTimestamps) {
-		trimmed := make([]int, len(validTimestamps))
-		copy(trimmed, validTimestamps)
-		s.timestamps = trimmed
-	} else {
-		s.timestamps = validTimestamps
-	}
+
+	s.journal.expire(s.buffer.expire(current.UnixNano(), int64(threshold)))
 	return nil
 }
 func (s *MemoryStore) Sync(ctx context.Context) error {
-	s.mu.RLock()
-	timestamps, err := s.View(ctx)
-	s.mu.RUnlock()
-
-	if err != nil {
-		return fmt.Errorf("failed to get timestamps for sync: %w", err)
-	}
-
-	if err := s.persister.Rewrite(ctx, timestamps, s.fileName); err != nil {
-		return fmt.Errorf("failed to sync timestamps: %w", err)
-	}
+	return s.journal.sync(ctx)
+}
 
-	return nil
+// Compact rewrites the file with the timestamps currently held in memory,
+// dropping entries that expired since the last compaction.
+func (s *MemoryStore) Compact(ctx context.Context) error {
+	return s.journal.compact(ctx)
 }
 func (s *MemoryStore) Close() error {
-	ctx := context.Background()
-	return s.Sync(ctx)
+	return s.journal.close(context.Background())
+}
+
+func toTimes(timestamps []int64) []time.Time {
+	result := make([]time.Time, len(timestamps))
+	for i, timestamp := range timestamps {
+		result[i] = time.Unix(0, timestamp)
+	}
+	return result
 }
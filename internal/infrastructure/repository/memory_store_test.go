@@ -3,26 +3,29 @@ package repository
 import (
 	"context"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"simplesurance/internal/clock"
+	"simplesurance/internal/domain"
 	"simplesurance/internal/infrastructure/persistence"
 )
 
 func TestMemoryStore_Store(t *testing.T) {
 	tests := []struct {
 		name      string
-		timestamp int
+		timestamp time.Time
 		wantErr   bool
 	}{
 		{
 			name:      "store valid timestamp",
-			timestamp: int(time.Now().Unix()),
+			timestamp: time.Now(),
 			wantErr:   false,
 		},
 		{
 			name:      "store zero timestamp",
-			timestamp: 0,
+			timestamp: time.Unix(0, 0),
 			wantErr:   false,
 		},
 	}
@@ -53,22 +56,22 @@ func TestMemoryStore_Store(t *testing.T) {
 func TestMemoryStore_View(t *testing.T) {
 	tests := []struct {
 		name       string
-		timestamps []int
+		timestamps []time.Time
 		wantCount  int
 	}{
 		{
 			name:       "view empty store",
-			timestamps: []int{},
+			timestamps: []time.Time{},
 			wantCount:  0,
 		},
 		{
 			name:       "view with one timestamp",
-			timestamps: []int{int(time.Now().Unix())},
+			timestamps: []time.Time{time.Now()},
 			wantCount:  1,
 		},
 		{
 			name:       "view with multiple timestamps",
-			timestamps: []int{int(time.Now().Unix()), int(time.Now().Unix()) - 10, int(time.Now().Unix()) - 20},
+			timestamps: []time.Time{time.Now(), time.Now().Add(-10 * time.Second), time.Now().Add(-20 * time.Second)},
 			wantCount:  3,
 		},
 	}
@@ -94,9 +97,9 @@ func TestMemoryStore_View(t *testing.T) {
 				t.Errorf("View() length = %v, want %v", len(view), tt.wantCount)
 			}
 			if len(view) > 0 {
-				view[0] = 999999
+				view[0] = time.Unix(999999, 0)
 				storeView, _ := store.View(ctx)
-				if len(storeView) > 0 && storeView[0] == 999999 {
+				if len(storeView) > 0 && storeView[0].Equal(time.Unix(999999, 0)) {
 					t.Error("View() returned reference instead of copy")
 				}
 			}
@@ -107,22 +110,22 @@ func TestMemoryStore_View(t *testing.T) {
 func TestMemoryStore_Count(t *testing.T) {
 	tests := []struct {
 		name       string
-		timestamps []int
+		timestamps []time.Time
 		wantCount  int
 	}{
 		{
 			name:       "count empty store",
-			timestamps: []int{},
+			timestamps: []time.Time{},
 			wantCount:  0,
 		},
 		{
 			name:       "count single timestamp",
-			timestamps: []int{int(time.Now().Unix())},
+			timestamps: []time.Time{time.Now()},
 			wantCount:  1,
 		},
 		{
 			name:       "count multiple timestamps",
-			timestamps: []int{int(time.Now().Unix()), int(time.Now().Unix()) - 10},
+			timestamps: []time.Time{time.Now(), time.Now().Add(-10 * time.Second)},
 			wantCount:  2,
 		},
 	}
@@ -155,28 +158,28 @@ func TestMemoryStore_Load(t *testing.T) {
 	tests := []struct {
 		name       string
 		setupFile  bool
-		fileData   []int
+		fileData   []int64
 		wantCount  int
 		wantErr    bool
 	}{
 		{
 			name:       "load from existing file",
 			setupFile:  true,
-			fileData:   []int{int(time.Now().Unix()), int(time.Now().Unix()) - 10},
+			fileData:   []int64{time.Now().UnixNano(), time.Now().Add(-10 * time.Second).UnixNano()},
 			wantCount:  2,
 			wantErr:    false,
 		},
 		{
 			name:       "load from non-existent file",
 			setupFile:  false,
-			fileData:   []int{},
+			fileData:   []int64{},
 			wantCount:  0,
 			wantErr:    false,
 		},
 		{
 			name:       "load from empty file",
 			setupFile:  true,
-			fileData:   []int{},
+			fileData:   []int64{},
 			wantCount:  0,
 			wantErr:    false,
 		},
@@ -217,33 +220,33 @@ func TestMemoryStore_Load(t *testing.T) {
 }
 
 func TestMemoryStore_RemoveExpired(t *testing.T) {
-	now := int(time.Now().Unix())
+	now := time.Now()
 	tests := []struct {
 		name       string
-		timestamps []int
-		current    int
-		threshold  int
+		timestamps []time.Time
+		current    time.Time
+		threshold  time.Duration
 		wantCount  int
 	}{
 		{
 			name:       "remove expired timestamps",
-			timestamps: []int{now, now - 30, now - 70},
+			timestamps: []time.Time{now, now.Add(-30 * time.Second), now.Add(-70 * time.Second)},
 			current:    now,
-			threshold:  60,
+			threshold:  60 * time.Second,
 			wantCount:  2, // now and now-30 should remain
 		},
 		{
 			name:       "keep all timestamps within threshold",
-			timestamps: []int{now, now - 30, now - 40},
+			timestamps: []time.Time{now, now.Add(-30 * time.Second), now.Add(-40 * time.Second)},
 			current:    now,
-			threshold:  60,
+			threshold:  60 * time.Second,
 			wantCount:  3,
 		},
 		{
 			name:       "remove all expired timestamps",
-			timestamps: []int{now - 70, now - 80, now - 90},
+			timestamps: []time.Time{now.Add(-70 * time.Second), now.Add(-80 * time.Second), now.Add(-90 * time.Second)},
 			current:    now,
-			threshold:  60,
+			threshold:  60 * time.Second,
 			wantCount:  0,
 		},
 	}
@@ -279,15 +282,15 @@ func TestMemoryStore_RemoveExpired(t *testing.T) {
 func TestMemoryStore_Sync(t *testing.T) {
 	tests := []struct {
 		name       string
-		timestamps []int
+		timestamps []time.Time
 	}{
 		{
 			name:       "sync empty store",
-			timestamps: []int{},
+			timestamps: []time.Time{},
 		},
 		{
 			name:       "sync with timestamps",
-			timestamps: []int{int(time.Now().Unix()), int(time.Now().Unix()) - 10},
+			timestamps: []time.Time{time.Now(), time.Now().Add(-10 * time.Second)},
 		},
 	}
 
@@ -336,3 +339,290 @@ func cleanup(t *testing.T, store *MemoryStore, filename string) {
 	store.Close()
 	os.Remove(filename)
 }
+
+type countingPersister struct {
+	persistence.FilePersistence
+	appends  int
+	rewrites int
+}
+
+func (p *countingPersister) Append(ctx context.Context, timestamp int64, filename string) error {
+	p.appends++
+	return p.FilePersistence.Append(ctx, timestamp, filename)
+}
+
+func (p *countingPersister) Rewrite(ctx context.Context, timestamps []int64, filename string) error {
+	p.rewrites++
+	return p.FilePersistence.Rewrite(ctx, timestamps, filename)
+}
+
+func TestMemoryStore_SyncWAL(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name         string
+		advance      time.Duration
+		records      int
+		wantAppends  int
+		wantRewrites int
+	}{
+		{
+			name:         "appends after initial compaction",
+			advance:      59 * time.Second,
+			records:      2,
+			wantAppends:  1,
+			wantRewrites: 1,
+		},
+		{
+			name:         "compacts on every sync when interval elapsed",
+			advance:      time.Minute,
+			records:      3,
+			wantAppends:  0,
+			wantRewrites: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := "test_wal.log"
+			defer os.Remove(filename)
+
+			persister := &countingPersister{FilePersistence: persistence.NewFilePersistence()}
+			fake := clock.NewFake(now)
+			store := NewMemoryStore(filename, persister, WithWAL(time.Minute), WithClock(fake))
+			ctx := context.Background()
+
+			for i := 0; i < tt.records; i++ {
+				if err := store.Store(ctx, fake.Now()); err != nil {
+					t.Fatalf("Store() error = %v", err)
+				}
+				if err := store.Sync(ctx); err != nil {
+					t.Fatalf("Sync() error = %v", err)
+				}
+				fake.Advance(tt.advance)
+			}
+
+			if persister.appends != tt.wantAppends {
+				t.Errorf("Append() calls = %v, want %v", persister.appends, tt.wantAppends)
+			}
+			if persister.rewrites != tt.wantRewrites {
+				t.Errorf("Rewrite() calls = %v, want %v", persister.rewrites, tt.wantRewrites)
+			}
+
+			newStore := NewMemoryStore(filename, persistence.NewFilePersistence())
+			if err := newStore.Load(ctx); err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			view, err := newStore.View(ctx)
+			if err != nil {
+				t.Fatalf("View() error = %v", err)
+			}
+			if len(view) != tt.records {
+				t.Fatalf("View() after Load() = %v, want %v entries", view, tt.records)
+			}
+			for i, ts := range view {
+				if want := now.Add(time.Duration(i) * tt.advance); !ts.Equal(want) {
+					t.Errorf("View()[%d] = %v, want %v", i, ts, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryStore_ClockSteppedBack(t *testing.T) {
+	start := time.Now()
+	current := start
+	store := NewMemoryStore("", nil, WithRingBuffer())
+	ctx := context.Background()
+	record := func() domain.Recorded {
+		t.Helper()
+		recorded, err := store.Record(ctx, func() time.Time { return current }, time.Minute, 0)
+		if err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+		return recorded
+	}
+
+	for i := 0; i < 3; i++ {
+		record()
+		current = current.Add(10 * time.Second)
+	}
+	// NTP steps the wall clock back by 60s: the hit lands before the others.
+	current = start.Add(-30 * time.Second)
+	if got := record(); got.Count != 4 || !got.Oldest.Equal(got.Timestamp) {
+		t.Errorf("Record() after the step = %+v, want 4 hits with this one the oldest", got)
+	}
+
+	current = start.Add(45 * time.Second)
+	if got := record(); got.Count != 4 || !got.Oldest.Equal(start) {
+		t.Errorf("Record() = %+v, want the stepped-back hit expired, 4 hits from %v", got, start)
+	}
+}
+
+// TestMemoryStore_SyncCompactsIdleWAL syncs without new hits, as the
+// maintenance worker does, and expects the expired entries to leave the file.
+func TestMemoryStore_SyncCompactsIdleWAL(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "idle.log")
+	now := time.Now()
+	fake := clock.NewFake(now)
+	persister := &countingPersister{FilePersistence: persistence.NewFilePersistence()}
+	store := NewMemoryStore(filename, persister, WithWAL(time.Minute), WithClock(fake))
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		store.Store(ctx, fake.Now())
+		if err := store.Sync(ctx); err != nil {
+			t.Fatalf("Sync() error = %v", err)
+		}
+	}
+	fake.Advance(30 * time.Second)
+	store.RemoveExpired(ctx, fake.Now(), 10*time.Second)
+	if err := store.Sync(ctx); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if onDisk, _ := persister.ReadAll(ctx, filename); len(onDisk) != 3 {
+		t.Errorf("ReadAll() before the compact interval = %v, want 3 entries", onDisk)
+	}
+
+	fake.Advance(time.Minute)
+	if err := store.Sync(ctx); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if onDisk, _ := persister.ReadAll(ctx, filename); len(onDisk) != 0 {
+		t.Errorf("ReadAll() after the compact interval = %v, want the expired entries gone", onDisk)
+	}
+	if persister.appends != 2 || persister.rewrites != 2 {
+		t.Errorf("Append(), Rewrite() calls = %d, %d, want 2, 2", persister.appends, persister.rewrites)
+	}
+}
+
+func TestMemoryStore_SyncSkipsUnchangedWindow(t *testing.T) {
+	filename := "test_unchanged.log"
+	defer os.Remove(filename)
+
+	now := time.Now()
+	persister := &countingPersister{FilePersistence: persistence.NewFilePersistence()}
+	store := NewMemoryStore(filename, persister)
+	ctx := context.Background()
+
+	steps := []struct {
+		name         string
+		change       func()
+		wantRewrites int
+	}{
+		{name: "store", change: func() { store.Store(ctx, now) }, wantRewrites: 1},
+		{name: "nothing expired", change: func() { store.RemoveExpired(ctx, now, time.Minute) }, wantRewrites: 1},
+		{name: "expire", change: func() { store.RemoveExpired(ctx, now.Add(2*time.Minute), time.Minute) }, wantRewrites: 2},
+	}
+	for _, step := range steps {
+		step.change()
+		for j := 0; j < 2; j++ {
+			if err := store.Sync(ctx); err != nil {
+				t.Fatalf("Sync() error = %v", err)
+			}
+		}
+		if persister.rewrites != step.wantRewrites {
+			t.Errorf("after %s: Rewrite() calls = %d, want %d", step.name, persister.rewrites, step.wantRewrites)
+		}
+	}
+}
+
+func TestMemoryStore_CompactDropsExpired(t *testing.T) {
+	filename := "test_compact.log"
+	defer os.Remove(filename)
+
+	now := time.Now()
+	store := NewMemoryStore(filename, persistence.NewFilePersistence(), WithWAL(time.Hour))
+	ctx := context.Background()
+
+	for _, ts := range []time.Time{now.Add(-120 * time.Second), now.Add(-90 * time.Second), now} {
+		if err := store.Store(ctx, ts); err != nil {
+			t.Fatalf("Store() error = %v", err)
+		}
+		if err := store.Sync(ctx); err != nil {
+			t.Fatalf("Sync() error = %v", err)
+		}
+	}
+	if err := store.RemoveExpired(ctx, now, 60*time.Second); err != nil {
+		t.Fatalf("RemoveExpired() error = %v", err)
+	}
+
+	persister := persistence.NewFilePersistence()
+	onDisk, err := persister.ReadAll(ctx, filename)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if len(onDisk) != 3 {
+		t.Errorf("ReadAll() before Compact() = %v, want 3 entries", onDisk)
+	}
+
+	if err := store.Compact(ctx); err != nil {
+		t.Fatalf("Compact() error = %v", err)
+	}
+	onDisk, err = persister.ReadAll(ctx, filename)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if len(onDisk) != 1 || onDisk[0] != now.UnixNano() {
+		t.Errorf("ReadAll() after Compact() = %v, want [%v]", onDisk, now.UnixNano())
+	}
+}
+
+func TestMemoryStore_RingBuffer(t *testing.T) {
+	filename := "test_ring.log"
+	defer os.Remove(filename)
+
+	now := time.Now()
+	store := NewMemoryStore(filename, persistence.NewFilePersistence(), WithRingBuffer())
+	ctx := context.Background()
+
+	for _, ts := range []time.Time{now.Add(-90 * time.Second), now.Add(-70 * time.Second), now.Add(-30 * time.Second), now} {
+		if err := store.Store(ctx, ts); err != nil {
+			t.Fatalf("Store() error = %v", err)
+		}
+	}
+	if err := store.RemoveExpired(ctx, now, 60*time.Second); err != nil {
+		t.Fatalf("RemoveExpired() error = %v", err)
+	}
+	if err := store.Sync(ctx); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	newStore := NewMemoryStore(filename, persistence.NewFilePersistence(), WithRingBuffer())
+	if err := newStore.Load(ctx); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	view, err := newStore.View(ctx)
+	if err != nil {
+		t.Fatalf("View() error = %v", err)
+	}
+	if len(view) != 2 || !view[0].Equal(now.Add(-30*time.Second)) || !view[1].Equal(now) {
+		t.Errorf("View() after Load() = %v, want [%v %v]", view, now.Add(-30*time.Second), now)
+	}
+}
+
+func TestMemoryStore_WithoutPersister(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore("", nil, WithRingBuffer(), WithWAL(time.Minute))
+	if err := store.Load(ctx); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := store.Store(ctx, now); err != nil {
+			t.Fatalf("Store() error = %v", err)
+		}
+		if err := store.Sync(ctx); err != nil {
+			t.Fatalf("Sync() error = %v", err)
+		}
+	}
+	if count, _ := store.Count(ctx); count != 3 {
+		t.Errorf("Count() = %v, want 3", count)
+	}
+	if len(store.journal.pending) != 0 {
+		t.Errorf("pending appends = %v, want none", store.journal.pending)
+	}
+	if err := store.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+}
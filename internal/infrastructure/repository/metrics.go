@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"time"
+
+	"simplesurance/internal/metrics"
+)
+
+// Metrics reports what stores do with their windows and files. One value is
+// shared by all stores; WithMetrics labels each store with its counter.
+type Metrics struct {
+	expired     *metrics.CounterVec
+	syncSeconds *metrics.HistogramVec
+	errors      *metrics.CounterVec
+}
+
+func NewMetrics(r *metrics.Registry) *Metrics {
+	return &Metrics{
+		expired: r.NewCounterVec("counter_expired_total",
+			"Timestamps dropped from a window because they expired.", "counter"),
+		syncSeconds: r.NewHistogramVec("counter_sync_duration_seconds",
+			"Time taken to write a counter's window or state to its file.", metrics.DefaultBuckets, "counter"),
+		errors: r.NewCounterVec("counter_persistence_errors_total",
+			"Failed reads and writes of counter files by operation.", "counter", "op"),
+	}
+}
+
+// WithMetrics reports the store's expirations, syncs and persistence errors
+// under the given counter name.
+func WithMetrics(m *Metrics, counter string) Option {
+	return func(o *options) {
+		if m != nil {
+			o.metrics = &storeMetrics{
+				Metrics:     m,
+				counter:     counter,
+				expired:     m.expired.WithLabelValues(counter),
+				syncSeconds: m.syncSeconds.WithLabelValues(counter),
+			}
+		}
+	}
+}
+
+// storeMetrics is one store's view of Metrics. Its methods do nothing on a
+// nil receiver, so stores without metrics need no checks.
+type storeMetrics struct {
+	*Metrics
+	counter     string
+	expired     *metrics.Counter
+	syncSeconds *metrics.Histogram
+}
+
+func (m *storeMetrics) expire(n int) {
+	if m != nil && n > 0 {
+		m.expired.Add(uint64(n))
+	}
+}
+func (m *storeMetrics) observeSync(d time.Duration) {
+	if m != nil {
+		m.syncSeconds.Observe(d.Seconds())
+	}
+}
+
+// failed counts err, if any, against op and returns it unchanged.
+func (m *storeMetrics) failed(op string, err error) error {
+	if m != nil && err != nil {
+		m.errors.WithLabelValues(m.counter, op).Inc()
+	}
+	return err
+}
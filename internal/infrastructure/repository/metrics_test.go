@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"simplesurance/internal/infrastructure/persistence"
+	"simplesurance/internal/metrics"
+)
+
+type failingPersister struct {
+	persistence.FilePersistence
+}
+
+func (failingPersister) Rewrite(ctx context.Context, timestamps []int64, filename string) error {
+	return errors.New("disk full")
+}
+
+func TestWithMetrics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	m := NewMetrics(registry)
+	ctx := context.Background()
+	start := time.Unix(1_700_000_000, 0)
+
+	memory := NewMemoryStore("memory.log", failingPersister{}, WithMetrics(m, "memory"))
+	bucket := NewBucketStore("", nil, 10*time.Second, time.Second, WithMetrics(m, "bucket"))
+	for _, store := range []interface {
+		Store(context.Context, time.Time) error
+		RemoveExpired(context.Context, time.Time, time.Duration) error
+	}{memory, bucket} {
+		for i := 0; i < 3; i++ {
+			store.Store(ctx, start.Add(time.Duration(i)*time.Second))
+		}
+		store.RemoveExpired(ctx, start.Add(11*time.Second), 10*time.Second)
+	}
+	if err := memory.Sync(ctx); err == nil {
+		t.Fatal("Sync() error = nil, want the persister's error")
+	}
+
+	if got := m.expired.WithLabelValues("memory").Value(); got != 2 {
+		t.Errorf("memory expired = %d, want 2", got)
+	}
+	if got := m.expired.WithLabelValues("bucket").Value(); got != 1 {
+		t.Errorf("bucket expired = %d, want 1", got)
+	}
+	if got := m.errors.WithLabelValues("memory", "sync").Value(); got != 1 {
+		t.Errorf("memory sync errors = %d, want 1", got)
+	}
+}
+
+func TestWithSyncObserver(t *testing.T) {
+	var observed []error
+	observe := WithSyncObserver(func(err error) { observed = append(observed, err) })
+	ctx := context.Background()
+
+	NewMemoryStore("memory.log", failingPersister{}, observe).Sync(ctx)
+	NewFileStateStore("memory.log.state", failingPersister{}, observe).Save(ctx, []int64{1})
+	NewMemoryStore("", nil, observe).Sync(ctx)
+
+	if len(observed) != 2 || observed[0] == nil || observed[1] == nil {
+		t.Errorf("observed = %v, want the two failed syncs and nothing for the memory-only store", observed)
+	}
+
+	for _, opts := range [][]Option{{observe}, {WithWAL(time.Hour), observe}} {
+		observed = nil
+		filename := filepath.Join(t.TempDir(), "timestamps.log")
+		store := NewMemoryStore(filename, persistence.NewFilePersistence(), opts...)
+		store.Sync(ctx)
+		store.Sync(ctx)
+		store.Store(ctx, time.Unix(1_700_000_000, 0))
+		store.Sync(ctx)
+		if len(observed) != 2 || observed[0] != nil || observed[1] != nil {
+			t.Errorf("observed = %v, want two successful syncs and nothing for the one with nothing to write", observed)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package repository
+
+import (
+	"context"
+	"runtime"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"simplesurance/internal/domain"
+)
+
+// TestRecord_Linearizable records from many goroutines at once, then replays
+// the calls one by one in the order of the timestamps they read. Since now
+// is read inside Record's critical section, that is the order they took
+// effect in, so every call must have returned what the replay returns.
+//
+// The sharded store orders calls by their reservation instead, which the
+// test cannot observe, so it checks what that order guarantees: every call
+// sees the count it produced, and none goes past the limit.
+func TestRecord_Linearizable(t *testing.T) {
+	const (
+		goroutines   = 16
+		perGoroutine = 500
+		step         = time.Millisecond
+	)
+	start := time.Unix(1_700_000_000, 0)
+
+	tests := []struct {
+		name      string
+		newStore  func() domain.TimestampRepository
+		threshold time.Duration
+		limit     int
+		// unbounded is set when nothing expires or is rejected, so the n-th
+		// call must count n.
+		unbounded bool
+		// reservationOrder is set for stores whose calls take effect in the
+		// order they reserve a slot rather than the order of their timestamps.
+		reservationOrder bool
+	}{
+		{
+			name:      "ring buffer with expiry",
+			newStore:  func() domain.TimestampRepository { return NewMemoryStore("", nil, WithRingBuffer()) },
+			threshold: 50 * step,
+		},
+		{
+			name:      "slice with expiry and limit",
+			newStore:  func() domain.TimestampRepository { return NewMemoryStore("", nil) },
+			threshold: 50 * step,
+			limit:     20,
+		},
+		{
+			name:      "ring buffer without expiry",
+			newStore:  func() domain.TimestampRepository { return NewMemoryStore("", nil, WithRingBuffer()) },
+			threshold: time.Hour,
+			unbounded: true,
+		},
+		{
+			name: "bucket store with expiry and limit",
+			newStore: func() domain.TimestampRepository {
+				return NewBucketStore("", nil, 100*step, 10*step)
+			},
+			threshold: 100 * step,
+			limit:     60,
+		},
+		{
+			name:             "sharded store without expiry",
+			newStore:         func() domain.TimestampRepository { return NewShardedStore("", nil, 4) },
+			threshold:        time.Hour,
+			unbounded:        true,
+			reservationOrder: true,
+		},
+		{
+			name:             "sharded store with expiry and limit",
+			newStore:         func() domain.TimestampRepository { return NewShardedStore("", nil, 4) },
+			threshold:        50 * step,
+			limit:            20,
+			reservationOrder: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := tt.newStore()
+			var ticks atomic.Int64
+			// Yielding after the read widens any gap between reading the
+			// clock and storing the timestamp.
+			now := func() time.Time {
+				current := start.Add(time.Duration(ticks.Add(1)) * step)
+				runtime.Gosched()
+				return current
+			}
+
+			results := make([]domain.Recorded, 0, goroutines*perGoroutine)
+			var mu sync.Mutex
+			var wg sync.WaitGroup
+			for g := 0; g < goroutines; g++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					local := make([]domain.Recorded, 0, perGoroutine)
+					for i := 0; i < perGoroutine; i++ {
+						recorded, err := store.Record(ctx, now, tt.threshold, tt.limit)
+						if err != nil {
+							t.Errorf("Record() error = %v", err)
+							return
+						}
+						local = append(local, recorded)
+					}
+					mu.Lock()
+					results = append(results, local...)
+					mu.Unlock()
+				}()
+			}
+			wg.Wait()
+
+			sort.Slice(results, func(i, j int) bool { return results[i].Timestamp.Before(results[j].Timestamp) })
+			if tt.reservationOrder {
+				checkReservations(t, results, tt.limit, tt.unbounded)
+			} else {
+				replay := tt.newStore()
+				for i, got := range results {
+					want, err := replay.Record(ctx, func() time.Time { return got.Timestamp }, tt.threshold, tt.limit)
+					if err != nil {
+						t.Fatalf("replayed Record() error = %v", err)
+					}
+					if got != want {
+						t.Fatalf("call %d at %v returned %+v, replay returned %+v", i, got.Timestamp.Sub(start), got, want)
+					}
+					if tt.unbounded && got.Count != i+1 {
+						t.Fatalf("call %d counted %d, want %d", i, got.Count, i+1)
+					}
+				}
+			}
+
+			view, err := store.View(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.IsSortedFunc(view, func(a, b time.Time) int { return a.Compare(b) }) {
+				t.Error("View() is out of order after concurrent Record() calls")
+			}
+		})
+	}
+}
+
+// checkReservations checks the counts of a store that orders calls by their
+// reservation: rejected calls saw a full window, stored ones never counted
+// past limit, and without expiry or limit every call counted a different
+// number from 1 up.
+func checkReservations(t *testing.T, results []domain.Recorded, limit int, unbounded bool) {
+	t.Helper()
+	seen := make(map[int]bool, len(results))
+	for i, got := range results {
+		switch {
+		case !got.Stored && got.Count < limit:
+			t.Fatalf("call %d was rejected with count %d under the limit %d", i, got.Count, limit)
+		case got.Stored && (got.Count < 1 || (limit > 0 && got.Count > limit)):
+			t.Fatalf("call %d stored with count %d, want 1 to %d", i, got.Count, limit)
+		case unbounded && seen[got.Count]:
+			t.Fatalf("call %d counted %d, which another call counted too", i, got.Count)
+		}
+		seen[got.Count] = true
+	}
+	if unbounded && len(seen) != len(results) {
+		t.Errorf("%d distinct counts, want %d", len(seen), len(results))
+	}
+}
+
+func TestCountUnexpired_LeavesExpiredEntries(t *testing.T) {
+	const threshold = 60 * time.Second
+	ctx := context.Background()
+	tests := []struct {
+		name     string
+		newStore func() domain.TimestampRepository
+	}{
+		{name: "slice", newStore: func() domain.TimestampRepository { return NewMemoryStore("", nil) }},
+		{name: "ring buffer", newStore: func() domain.TimestampRepository { return NewMemoryStore("", nil, WithRingBuffer()) }},
+		{name: "buckets", newStore: func() domain.TimestampRepository { return NewBucketStore("", nil, threshold, 10*time.Second) }},
+		{name: "sharded", newStore: func() domain.TimestampRepository { return NewShardedStore("", nil, 4) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := tt.newStore()
+			for _, ts := range []time.Time{at(30), at(35), at(45), at(75), at(85)} {
+				if err := store.Store(ctx, ts); err != nil {
+					t.Fatalf("Store() error = %v", err)
+				}
+			}
+			got, err := store.CountUnexpired(ctx, at(100), threshold)
+			if err != nil {
+				t.Fatalf("CountUnexpired() error = %v", err)
+			}
+			if count, _ := store.Count(ctx); count != 5 {
+				t.Errorf("Count() after CountUnexpired() = %d, want all 5 entries kept", count)
+			}
+			if err := store.RemoveExpired(ctx, at(100), threshold); err != nil {
+				t.Fatalf("RemoveExpired() error = %v", err)
+			}
+			if want, _ := store.Count(ctx); got != want {
+				t.Errorf("CountUnexpired() = %d, want %d as left by RemoveExpired()", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"simplesurance/internal/domain"
+	"simplesurance/internal/infrastructure/persistence"
+)
+
+// ShardedStore spreads hits round-robin over shards that each keep a ring
+// buffer under their own lock, so concurrent Record calls rarely wait for
+// each other. The window's size is kept in an atomic total, and the limit is
+// enforced by reserving a slot in it, so it is never exceeded.
+//
+// Unlike MemoryStore, Record is only atomic within a shard. Concurrent calls
+// take effect in the order they reserve their slot, not the order of their
+// timestamps, and each returns the total its reservation produced. That total
+// may still include expired entries of a shard that was busy at the time, so
+// the count errs high, never past the limit.
+type ShardedStore struct {
+	shards []shard
+	next   atomic.Uint64
+	total  atomic.Int64
+	// journalMu is the journal's lock, guarding the entries every shard
+	// appends to. It is taken after a shard's lock. The journal only locks
+	// every shard to take a snapshot, when compacting or rewriting.
+	journalMu sync.Mutex
+	journal   *journal
+}
+
+type shard struct {
+	mu     sync.Mutex
+	buffer ringBuffer
+	// oldest is the shard's oldest timestamp, or zero if it is empty, so
+	// Record can find the window's oldest without locking other shards.
+	oldest atomic.Int64
+	// Padding keeps each shard's lock on its own cache line.
+	_ [64]byte
+}
+
+// NewShardedStore creates a store with the given number of shards, or one
+// per GOMAXPROCS if shards is not positive.
+func NewShardedStore(fileName string, persister persistence.FilePersistence, shards int, opts ...Option) *ShardedStore {
+	if shards <= 0 {
+		shards = runtime.GOMAXPROCS(0)
+	}
+	s := &ShardedStore{shards: make([]shard, shards)}
+	s.journal = newJournal(fileName, persister, newOptions(opts), &s.journalMu, allShards{s}, s.snapshot)
+	s.journal.order = slices.Sort[[]int64]
+	return s
+}
+func (s *ShardedStore) Record(ctx context.Context, now func() time.Time, threshold time.Duration, limit int) (domain.Recorded, error) {
+	sh := &s.shards[s.next.Add(1)%uint64(len(s.shards))]
+	recorded, full := s.record(sh, now, threshold, limit)
+	if full {
+		// Other shards may still hold expired entries that count towards
+		// the total; drop them and try once more.
+		s.expireAll(recorded.Timestamp, threshold)
+		recorded, _ = s.record(sh, now, threshold, limit)
+	}
+	return recorded, nil
+}
+
+// record reports whether the hit was rejected because the window is full.
+func (s *ShardedStore) record(sh *shard, now func() time.Time, threshold time.Duration, limit int) (domain.Recorded, bool) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	current := now()
+	s.expireLocked(sh, current.UnixNano(), int64(threshold))
+	oldest := s.sweep(sh, current.UnixNano(), int64(threshold))
+	recorded := domain.Recorded{Timestamp: current}
+	count, reserved := s.reserve(limit)
+	if reserved {
+		sh.buffer.push(current.UnixNano())
+		sh.updateOldest()
+		s.journalMu.Lock()
+		s.journal.record(current.UnixNano())
+		s.journalMu.Unlock()
+		recorded.Stored = true
+	}
+	recorded.Count = int(count)
+	if own := sh.oldest.Load(); own != 0 && (oldest == 0 || own < oldest) {
+		oldest = own
+	}
+	if oldest != 0 {
+		recorded.Oldest = time.Unix(0, oldest)
+	}
+	return recorded, !recorded.Stored
+}
+
+// reserve counts a new entry in the total unless that would exceed limit,
+// and returns the total as the reservation left it.
+func (s *ShardedStore) reserve(limit int) (int64, bool) {
+	for {
+		total := s.total.Load()
+		if limit > 0 && total >= int64(limit) {
+			return total, false
+		}
+		if s.total.CompareAndSwap(total, total+1) {
+			return total + 1, true
+		}
+	}
+}
+
+// expireLocked drops the shard's expired entries. The caller must hold sh.mu.
+func (s *ShardedStore) expireLocked(sh *shard, current, threshold int64) {
+	expired := sh.buffer.expire(current, threshold)
+	if expired == 0 {
+		return
+	}
+	s.total.Add(-int64(expired))
+	sh.updateOldest()
+	s.journalMu.Lock()
+	s.journal.expire(expired)
+	s.journalMu.Unlock()
+}
+// sweep expires the other shards whose oldest entry has expired and returns
+// the oldest entry left in them, or zero. It skips the shards that are
+// locked, as their holder is expiring them or will count its own hit;
+// waiting would serialize the shards again.
+func (s *ShardedStore) sweep(own *shard, current, threshold int64) int64 {
+	var oldest int64
+	for i := range s.shards {
+		sh := &s.shards[i]
+		if sh == own {
+			continue
+		}
+		o := sh.oldest.Load()
+		if o != 0 && current-o >= threshold && sh.mu.TryLock() {
+			s.expireLocked(sh, current, threshold)
+			sh.mu.Unlock()
+			o = sh.oldest.Load()
+		}
+		if o != 0 && (oldest == 0 || o < oldest) {
+			oldest = o
+		}
+	}
+	return oldest
+}
+func (s *ShardedStore) expireAll(current time.Time, threshold time.Duration) {
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		s.expireLocked(sh, current.UnixNano(), int64(threshold))
+		sh.mu.Unlock()
+	}
+}
+func (s *ShardedStore) oldestOfAll() time.Time {
+	var oldest int64
+	for i := range s.shards {
+		if o := s.shards[i].oldest.Load(); o != 0 && (oldest == 0 || o < oldest) {
+			oldest = o
+		}
+	}
+	if oldest == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, oldest)
+}
+
+// updateOldest must be called with sh.mu held after the buffer changed.
+func (sh *shard) updateOldest() {
+	oldest, _ := sh.buffer.oldest()
+	sh.oldest.Store(oldest)
+}
+func (s *ShardedStore) Store(ctx context.Context, timestamp time.Time) error {
+	sh := &s.shards[s.next.Add(1)%uint64(len(s.shards))]
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	sh.buffer.push(timestamp.UnixNano())
+	sh.updateOldest()
+	s.total.Add(1)
+	s.journalMu.Lock()
+	s.journal.record(timestamp.UnixNano())
+	s.journalMu.Unlock()
+	return nil
+}
+
+// View merges the shards into one window in timestamp order.
+func (s *ShardedStore) View(ctx context.Context) ([]time.Time, error) {
+	lock := allShards{s}
+	lock.Lock()
+	timestamps := s.snapshot()
+	lock.Unlock()
+
+	slices.Sort(timestamps)
+	return toTimes(timestamps), nil
+}
+func (s *ShardedStore) Count(ctx context.Context) (int, error) {
+	return int(s.total.Load()), nil
+}
+
+// CountUnexpired locks one shard at a time, so the count is not a snapshot
+// of the window if hits arrive meanwhile.
+func (s *ShardedStore) CountUnexpired(ctx context.Context, current time.Time, threshold time.Duration) (int, error) {
+	count := 0
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		count += sh.buffer.unexpired(current.UnixNano(), int64(threshold))
+		sh.mu.Unlock()
+	}
+	return count, nil
+}
+func (s *ShardedStore) Oldest(ctx context.Context) (time.Time, error) {
+	return s.oldestOfAll(), nil
+}
+
+// Load deals the timestamps from the file out to the shards in turn.
+func (s *ShardedStore) Load(ctx context.Context) error {
+	entries, err := s.journal.read(ctx)
+	if err != nil {
+		return err
+	}
+	timestamps, err := expandBucketState(entries)
+	if err != nil {
+		return fmt.Errorf("failed to load timestamps: %w", err)
+	}
+	slices.Sort(timestamps)
+
+	lock := allShards{s}
+	lock.Lock()
+	defer lock.Unlock()
+
+	dealt := make([][]int64, len(s.shards))
+	for i, timestamp := range timestamps {
+		dealt[i%len(dealt)] = append(dealt[i%len(dealt)], timestamp)
+	}
+	for i := range s.shards {
+		s.shards[i].buffer.reset(dealt[i])
+		s.shards[i].updateOldest()
+	}
+	s.total.Store(int64(len(timestamps)))
+	s.journal.reset()
+	return nil
+}
+func (s *ShardedStore) RemoveExpired(ctx context.Context, current time.Time, threshold time.Duration) error {
+	s.expireAll(current, threshold)
+	return nil
+}
+func (s *ShardedStore) Sync(ctx context.Context) error {
+	return s.journal.sync(ctx)
+}
+func (s *ShardedStore) Close() error {
+	return s.journal.close(context.Background())
+}
+
+// snapshot copies the shards' entries, each shard in order but not merged.
+// It must be called with every shard locked.
+func (s *ShardedStore) snapshot() []int64 {
+	var timestamps []int64
+	for i := range s.shards {
+		timestamps = append(timestamps, s.shards[i].buffer.snapshot()...)
+	}
+	return timestamps
+}
+
+// allShards locks every shard, in order, and then the journal, so the
+// journal sees a consistent window.
+type allShards struct {
+	s *ShardedStore
+}
+
+func (l allShards) Lock() {
+	for i := range l.s.shards {
+		l.s.shards[i].mu.Lock()
+	}
+	l.s.journalMu.Lock()
+}
+func (l allShards) Unlock() {
+	l.s.journalMu.Unlock()
+	for i := range l.s.shards {
+		l.s.shards[i].mu.Unlock()
+	}
+}
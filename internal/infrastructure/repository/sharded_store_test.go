@@ -0,0 +1,196 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"simplesurance/internal/domain"
+	"simplesurance/internal/infrastructure/persistence"
+)
+
+func TestShardedStore_MatchesMemoryStore(t *testing.T) {
+	const (
+		threshold = 60 * time.Second
+		limit     = 40
+	)
+	ctx := context.Background()
+	rng := rand.New(rand.NewSource(1))
+	memory := NewMemoryStore("", nil, WithRingBuffer())
+	sharded := NewShardedStore("", nil, 4)
+
+	current := bucketBase
+	now := func() time.Time { return current }
+	for step := 0; step < 5000; step++ {
+		current = current.Add(time.Duration(rng.Intn(3000)) * time.Millisecond)
+		want, _ := memory.Record(ctx, now, threshold, limit)
+		got, _ := sharded.Record(ctx, now, threshold, limit)
+		if got != want {
+			t.Fatalf("step %d: Record() = %+v, want %+v", step, got, want)
+		}
+	}
+
+	// A hit after a long pause must not count the other shards' hits.
+	current = current.Add(time.Hour)
+	if got, _ := sharded.Record(ctx, now, threshold, limit); got.Count != 1 {
+		t.Errorf("Record() after the window emptied counted %d, want 1", got.Count)
+	}
+}
+
+func TestShardedStore_ConcurrentLimit(t *testing.T) {
+	const (
+		goroutines   = 16
+		perGoroutine = 200
+		limit        = 1000
+	)
+	ctx := context.Background()
+	store := NewShardedStore("", nil, 8)
+	var ticks atomic.Int64
+	now := func() time.Time { return bucketBase.Add(time.Duration(ticks.Add(1))) }
+
+	var stored atomic.Int64
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perGoroutine; i++ {
+				recorded, err := store.Record(ctx, now, time.Hour, limit)
+				if err != nil {
+					t.Errorf("Record() error = %v", err)
+					return
+				}
+				if recorded.Count > limit {
+					t.Errorf("Record() counted %d, over the limit of %d", recorded.Count, limit)
+				}
+				if recorded.Stored {
+					stored.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := stored.Load(); got != limit {
+		t.Errorf("stored %d hits, want exactly the limit of %d", got, limit)
+	}
+	if count, _ := store.Count(ctx); count != limit {
+		t.Errorf("Count() = %d, want %d", count, limit)
+	}
+}
+
+func TestShardedStore_SyncAndLoad(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "sharded.log")
+	ctx := context.Background()
+	for _, opts := range [][]Option{{WithWAL(time.Hour)}, nil} {
+		store := NewShardedStore(fileName, persistence.NewFilePersistence(), 3, opts...)
+		if err := store.Load(ctx); err != nil {
+			t.Fatalf("Load() error = %v", err)
+		}
+		for i := 0; i < 10; i++ {
+			store.Store(ctx, at(i))
+		}
+		if err := store.Sync(ctx); err != nil {
+			t.Fatalf("Sync() error = %v", err)
+		}
+		store.Close()
+
+		reloaded := NewShardedStore(fileName, persistence.NewFilePersistence(), 5)
+		if err := reloaded.Load(ctx); err != nil {
+			t.Fatalf("Load() error = %v", err)
+		}
+		view, _ := reloaded.View(ctx)
+		if len(view) != 10 || !view[0].Equal(at(0)) || !view[9].Equal(at(9)) {
+			t.Fatalf("View() after Load() = %v, want the 10 stored timestamps in order", view)
+		}
+		reloaded.RemoveExpired(ctx, at(15), 10*time.Second)
+		if count, _ := reloaded.Count(ctx); count != 4 {
+			t.Errorf("Count() after RemoveExpired() = %d, want 4", count)
+		}
+		if oldest, _ := reloaded.Oldest(ctx); !oldest.Equal(at(6)) {
+			t.Errorf("Oldest() = %v, want %v", oldest, at(6))
+		}
+		os.Remove(fileName)
+	}
+}
+
+// BenchmarkRecord measures Record throughput from parallel callers against a
+// 10ms window. Run it with -cpu 1,2,4,8 to see how each store scales with
+// GOMAXPROCS.
+func BenchmarkRecord(b *testing.B) {
+	const threshold = 10 * time.Millisecond
+	stores := []struct {
+		name     string
+		newStore func() domain.TimestampRepository
+	}{
+		{name: "ring", newStore: func() domain.TimestampRepository { return NewMemoryStore("", nil, WithRingBuffer()) }},
+		{name: "bucket", newStore: func() domain.TimestampRepository {
+			return NewBucketStore("", nil, threshold, threshold/100)
+		}},
+	}
+	for _, shards := range []int{4, 16, 64} {
+		shards := shards
+		stores = append(stores, struct {
+			name     string
+			newStore func() domain.TimestampRepository
+		}{
+			name:     fmt.Sprintf("sharded-%d", shards),
+			newStore: func() domain.TimestampRepository { return NewShardedStore("", nil, shards) },
+		})
+	}
+
+	for _, bs := range stores {
+		b.Run(bs.name, func(b *testing.B) {
+			store := bs.newStore()
+			ctx := context.Background()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					store.Record(ctx, time.Now, threshold, 0)
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkRecordSync records and syncs on every hit, as SYNC_MODE=request
+// does, with a binary file behind the store. fsync is left out, so the
+// benchmark measures locking rather than the disk.
+func BenchmarkRecordSync(b *testing.B) {
+	const threshold = 10 * time.Millisecond
+	newStores := map[string]func(fileName string, opts ...Option) domain.TimestampRepository{
+		"ring": func(fileName string, opts ...Option) domain.TimestampRepository {
+			return NewMemoryStore(fileName, persistence.NewBinaryPersistence(), append(opts, WithRingBuffer())...)
+		},
+		"sharded-16": func(fileName string, opts ...Option) domain.TimestampRepository {
+			return NewShardedStore(fileName, persistence.NewBinaryPersistence(), 16, opts...)
+		},
+	}
+	modes := map[string]Option{
+		"rewrite": func(*options) {},
+		"wal":     WithWAL(time.Second),
+	}
+	for storeName, newStore := range newStores {
+		for modeName, mode := range modes {
+			b.Run(storeName+"/"+modeName, func(b *testing.B) {
+				store := newStore(filepath.Join(b.TempDir(), "timestamps.log"), mode, WithDurability(Durability{Mode: DurabilityNone}))
+				defer store.Close()
+				ctx := context.Background()
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						store.Record(ctx, time.Now, threshold, 0)
+						if err := store.Sync(ctx); err != nil {
+							b.Error(err)
+							return
+						}
+					}
+				})
+			})
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"simplesurance/internal/domain"
+	"simplesurance/internal/infrastructure/persistence"
+	"simplesurance/internal/tracing"
+)
+
+// FileStateStore rewrites a strategy's state to its file on every Save. With a
+// nil persister Save and Load do nothing, for counters kept in memory only.
+type FileStateStore struct {
+	fileName  string
+	persister persistence.FilePersistence
+	clock     domain.Clock
+	metrics   *storeMetrics
+	onSync    func(error)
+}
+
+// NewFileStateStore accepts the repository options; only WithClock,
+// WithMetrics and WithSyncObserver apply to it.
+func NewFileStateStore(fileName string, persister persistence.FilePersistence, opts ...Option) *FileStateStore {
+	o := newOptions(opts)
+	return &FileStateStore{
+		fileName:  fileName,
+		persister: persister,
+		clock:     o.clock,
+		metrics:   o.metrics,
+		onSync:    o.onSync,
+	}
+}
+func (s *FileStateStore) Load(ctx context.Context) ([]int64, error) {
+	if s.persister == nil {
+		return nil, nil
+	}
+	state, err := s.persister.ReadAll(ctx, s.fileName)
+	if s.metrics.failed("load", err) != nil {
+		return nil, fmt.Errorf("failed to load state: %w", err)
+	}
+	return state, nil
+}
+func (s *FileStateStore) Save(ctx context.Context, state []int64) error {
+	if s.persister == nil {
+		return nil
+	}
+	ctx, span := tracing.Start(ctx, "repository.sync")
+	defer span.End()
+
+	start := s.clock.Now()
+	err := s.metrics.failed("sync", traceFile(ctx, "rewrite", s.fileName, func(ctx context.Context) error {
+		return s.persister.Rewrite(ctx, state, s.fileName)
+	}))
+	s.metrics.observeSync(s.clock.Now().Sub(start))
+	if s.onSync != nil {
+		s.onSync(err)
+	}
+	span.RecordError(err)
+	if err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	return nil
+}
+func (s *FileStateStore) Close() error {
+	return nil
+}
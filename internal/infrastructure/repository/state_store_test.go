@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+
+	"simplesurance/internal/infrastructure/persistence"
+)
+
+func TestFileStateStore_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		persister persistence.FilePersistence
+		state     []int64
+		want      []int64
+	}{
+		{
+			name:      "text",
+			persister: persistence.NewFilePersistence(),
+			state:     []int64{3, 1_700_000_000_000_000_000, -5, 0},
+			want:      []int64{3, 1_700_000_000_000_000_000, -5, 0},
+		},
+		{
+			name:      "binary",
+			persister: persistence.NewBinaryPersistence(),
+			state:     []int64{3, 1_700_000_000_000_000_000, -5, 0},
+			want:      []int64{3, 1_700_000_000_000_000_000, -5, 0},
+		},
+		{
+			name:  "memory only",
+			state: []int64{3, 42},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := "test_state.log"
+			defer os.Remove(filename)
+			ctx := context.Background()
+
+			if err := NewFileStateStore(filename, tt.persister).Save(ctx, tt.state); err != nil {
+				t.Fatalf("Save() error = %v", err)
+			}
+			got, err := NewFileStateStore(filename, tt.persister).Load(ctx)
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if len(got) != len(tt.want) || (len(got) > 0 && !reflect.DeepEqual(got, tt.want)) {
+				t.Errorf("Load() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
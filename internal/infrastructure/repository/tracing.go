@@ -0,0 +1,17 @@
+package repository
+
+import (
+	"context"
+
+	"simplesurance/internal/tracing"
+)
+
+// traceFile runs a persister call fn in a "file.<op>" span.
+func traceFile(ctx context.Context, op, fileName string, fn func(ctx context.Context) error) error {
+	ctx, span := tracing.Start(ctx, "file."+op, tracing.Attribute{Key: "file.path", Value: fileName})
+	defer span.End()
+
+	err := fn(ctx)
+	span.RecordError(err)
+	return err
+}
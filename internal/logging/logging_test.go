@@ -0,0 +1,70 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		format  string
+		level   string
+		wantErr bool
+		want    string
+	}{
+		{format: "json", level: "info", want: `"msg":"visible"`},
+		{format: "text", level: "warn", want: ""},
+		{format: "text", level: "debug", want: "msg=visible"},
+		{format: "xml", level: "info", wantErr: true},
+		{format: "text", level: "loud", wantErr: true},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		level, err := ParseLevel(tt.level)
+		if err == nil {
+			var logger *slog.Logger
+			logger, err = New(&buf, tt.format, level)
+			if err == nil {
+				logger.Info("visible")
+			}
+		}
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("New(%q, %q) error = %v, wantErr %v", tt.format, tt.level, err, tt.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+		if got := buf.String(); (tt.want == "") != (got == "") || !strings.Contains(got, tt.want) {
+			t.Errorf("New(%q, %q) logged %q, want it to contain %q", tt.format, tt.level, got, tt.want)
+		}
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	if got := FromContext(context.Background()); got != slog.Default() {
+		t.Error("FromContext() without a logger did not return slog.Default()")
+	}
+	logger := Discard()
+	if got := FromContext(WithLogger(context.Background(), logger)); got != logger {
+		t.Error("FromContext() did not return the logger set with WithLogger")
+	}
+}
+
+func TestNew_LevelVar(t *testing.T) {
+	var buf bytes.Buffer
+	var level slog.LevelVar
+	level.Set(slog.LevelWarn)
+	logger, err := New(&buf, "text", &level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("hidden")
+	level.Set(slog.LevelInfo)
+	logger.Info("visible")
+	if got := buf.String(); strings.Contains(got, "hidden") || !strings.Contains(got, "visible") {
+		t.Errorf("logged %q, want only the line after lowering the level", got)
+	}
+}
@@ -0,0 +1,322 @@
+// Package metrics keeps counters, histograms and gauges and writes them in
+// the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ContentType is the media type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets suit latencies from a millisecond to ten seconds.
+var DefaultBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type family interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds metric families and writes them in registration order.
+type Registry struct {
+	mu       sync.Mutex
+	names    map[string]bool
+	families []family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+func (r *Registry) register(f family, names ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, name := range names {
+		if r.names[name] {
+			panic(fmt.Sprintf("metrics: %q registered twice", name))
+		}
+		r.names[name] = true
+	}
+	r.families = append(r.families, f)
+}
+
+// WriteTo writes every family in the text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := append([]family(nil), r.families...)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, f := range families {
+		f.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (d desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, escapeHelp(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.typ)
+}
+
+// writeSample writes one line. extra is appended to the family's labels, for
+// the "le" label of histogram buckets.
+func (d desc) writeSample(w *bufio.Writer, suffix string, labelValues []string, extra string, value float64) {
+	w.WriteString(d.name)
+	w.WriteString(suffix)
+	if len(d.labels) > 0 || extra != "" {
+		w.WriteByte('{')
+		for i, label := range d.labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", label, escapeLabel(labelValues[i]))
+		}
+		if extra != "" {
+			if len(d.labels) > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(extra)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func (d desc) checkLabels(labelValues []string) {
+	if len(labelValues) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", d.name, len(d.labels), len(labelValues)))
+	}
+}
+
+// vec keeps one child per combination of label values, created on first use.
+type vec[T any] struct {
+	mu       sync.Mutex
+	children map[string]*T
+	values   map[string][]string
+	newChild func() *T
+}
+
+func (v *vec[T]) with(labelValues []string) *T {
+	key := strings.Join(labelValues, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if child, ok := v.children[key]; ok {
+		return child
+	}
+	if v.children == nil {
+		v.children = make(map[string]*T)
+		v.values = make(map[string][]string)
+	}
+	child := v.newChild()
+	v.children[key] = child
+	v.values[key] = append([]string(nil), labelValues...)
+	return child
+}
+
+// each visits the children sorted by label values, so output is stable.
+func (v *vec[T]) each(fn func(labelValues []string, child *T)) {
+	type entry struct {
+		key         string
+		labelValues []string
+		child       *T
+	}
+	v.mu.Lock()
+	entries := make([]entry, 0, len(v.children))
+	for key, child := range v.children {
+		entries = append(entries, entry{key: key, labelValues: v.values[key], child: child})
+	}
+	v.mu.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+	for _, e := range entries {
+		fn(e.labelValues, e.child)
+	}
+}
+
+// Counter is a monotonically increasing count.
+type Counter struct {
+	value atomic.Uint64
+}
+
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+func (c *Counter) Add(n uint64) {
+	c.value.Add(n)
+}
+func (c *Counter) Value() uint64 {
+	return c.value.Load()
+}
+
+type CounterVec struct {
+	desc
+	vec vec[Counter]
+}
+
+// NewCounterVec registers a counter family. The name should end in "_total".
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{desc: desc{name: name, help: help, typ: "counter", labels: labels}}
+	c.vec.newChild = func() *Counter { return &Counter{} }
+	r.register(c, name)
+	return c
+}
+
+// WithLabelValues returns the counter for the given values, in label order.
+func (c *CounterVec) WithLabelValues(labelValues ...string) *Counter {
+	c.checkLabels(labelValues)
+	return c.vec.with(labelValues)
+}
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.writeHeader(w)
+	c.vec.each(func(labelValues []string, child *Counter) {
+		c.writeSample(w, "", labelValues, "", float64(child.Value()))
+	})
+}
+
+// Histogram counts observations into buckets with fixed upper bounds.
+type Histogram struct {
+	upperBounds []float64
+
+	mu     sync.Mutex
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *Histogram) Observe(value float64) {
+	i := sort.SearchFloat64s(h.upperBounds, value)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.sum += value
+	h.count++
+}
+
+type HistogramVec struct {
+	desc
+	upperBounds []float64
+	vec         vec[Histogram]
+}
+
+// NewHistogramVec registers a histogram family. buckets are upper bounds in
+// increasing order; the +Inf bucket is implied.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("metrics: %s buckets are not sorted", name))
+	}
+	h := &HistogramVec{
+		desc:        desc{name: name, help: help, typ: "histogram", labels: labels},
+		upperBounds: append([]float64(nil), buckets...),
+	}
+	h.vec.newChild = func() *Histogram {
+		return &Histogram{upperBounds: h.upperBounds, counts: make([]uint64, len(h.upperBounds))}
+	}
+	r.register(h, name)
+	return h
+}
+
+// WithLabelValues returns the histogram for the given values, in label order.
+func (h *HistogramVec) WithLabelValues(labelValues ...string) *Histogram {
+	h.checkLabels(labelValues)
+	return h.vec.with(labelValues)
+}
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.writeHeader(w)
+	h.vec.each(func(labelValues []string, child *Histogram) {
+		child.mu.Lock()
+		counts := append([]uint64(nil), child.counts...)
+		sum, count := child.sum, child.count
+		child.mu.Unlock()
+
+		var cumulative uint64
+		for i, upperBound := range h.upperBounds {
+			cumulative += counts[i]
+			h.writeSample(w, "_bucket", labelValues, fmt.Sprintf("le=\"%s\"", formatFloat(upperBound)), float64(cumulative))
+		}
+		h.writeSample(w, "_bucket", labelValues, `le="+Inf"`, float64(count))
+		h.writeSample(w, "_sum", labelValues, "", sum)
+		h.writeSample(w, "_count", labelValues, "", float64(count))
+	})
+}
+
+// funcFamily reports values computed when the registry is written.
+type funcFamily struct {
+	desc
+	collect func(emit func(value float64, labelValues ...string))
+}
+
+// NewGaugeFunc registers a gauge family whose samples collect emits on every
+// write, one call per combination of label values.
+func (r *Registry) NewGaugeFunc(name, help string, labels []string, collect func(emit func(value float64, labelValues ...string))) {
+	r.register(&funcFamily{desc: desc{name: name, help: help, typ: "gauge", labels: labels}, collect: collect}, name)
+}
+
+// NewCounterFunc is NewGaugeFunc for values that only increase.
+func (r *Registry) NewCounterFunc(name, help string, labels []string, collect func(emit func(value float64, labelValues ...string))) {
+	r.register(&funcFamily{desc: desc{name: name, help: help, typ: "counter", labels: labels}, collect: collect}, name)
+}
+func (f *funcFamily) write(w *bufio.Writer) {
+	f.writeHeader(w)
+	f.collect(func(value float64, labelValues ...string) {
+		f.checkLabels(labelValues)
+		f.writeSample(w, "", labelValues, "", value)
+	})
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
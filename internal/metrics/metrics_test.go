@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegistry_WriteTo(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("requests_total", "Requests served.", "route", "code")
+	latency := r.NewHistogramVec("latency_seconds", "Request latency.", []float64{0.1, 1}, "route")
+	r.NewGaugeFunc("queue_length", "Items queued.", nil, func(emit func(float64, ...string)) {
+		emit(3)
+	})
+
+	requests.WithLabelValues("/b", "200").Inc()
+	requests.WithLabelValues("/a", "500").Add(2)
+	requests.WithLabelValues("/a", "200").Inc()
+	requests.WithLabelValues("/a", "200").Inc()
+	latency.WithLabelValues(`say "hi"`).Observe(0.05)
+	latency.WithLabelValues(`say "hi"`).Observe(0.5)
+	latency.WithLabelValues(`say "hi"`).Observe(5)
+
+	var b strings.Builder
+	if _, err := r.WriteTo(&b); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	want := `# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{route="/a",code="200"} 2
+requests_total{route="/a",code="500"} 2
+requests_total{route="/b",code="200"} 1
+# HELP latency_seconds Request latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="say \"hi\"",le="0.1"} 1
+latency_seconds_bucket{route="say \"hi\"",le="1"} 2
+latency_seconds_bucket{route="say \"hi\"",le="+Inf"} 3
+latency_seconds_sum{route="say \"hi\""} 5.55
+latency_seconds_count{route="say \"hi\""} 3
+# HELP queue_length Items queued.
+# TYPE queue_length gauge
+queue_length 3
+`
+	if got := b.String(); got != want {
+		t.Errorf("WriteTo() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHistogram_BucketBoundary(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogramVec("h", "", []float64{1, 2}).WithLabelValues()
+	h.Observe(1)
+	h.Observe(2.5)
+
+	var b strings.Builder
+	r.WriteTo(&b)
+	// Buckets are inclusive of their upper bound.
+	if !strings.Contains(b.String(), `h_bucket{le="1"} 1`) || !strings.Contains(b.String(), `h_bucket{le="2"} 1`) {
+		t.Errorf("WriteTo() =\n%s\nwant the observation of 1 in the le=\"1\" bucket", b.String())
+	}
+}
+
+func TestRegistry_DuplicateName(t *testing.T) {
+	r := NewRegistry()
+	RegisterRuntime(r)
+	defer func() {
+		if recover() == nil {
+			t.Error("registering go_goroutines twice did not panic")
+		}
+	}()
+	r.NewGaugeFunc("go_goroutines", "", nil, func(func(float64, ...string)) {})
+}
+
+func TestRegisterRuntime(t *testing.T) {
+	r := NewRegistry()
+	RegisterRuntime(r)
+	var b strings.Builder
+	r.WriteTo(&b)
+	for _, name := range []string{"go_goroutines ", "go_memstats_heap_alloc_bytes ", "go_gc_cycles_total ", "process_start_time_seconds "} {
+		if !strings.Contains(b.String(), "\n"+name) {
+			t.Errorf("WriteTo() is missing %s", name)
+		}
+	}
+}
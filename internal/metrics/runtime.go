@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"bufio"
+	"runtime"
+	"time"
+)
+
+// runtimeFamily reports Go runtime statistics from a single ReadMemStats per
+// write, as it briefly stops the world.
+type runtimeFamily struct {
+	start time.Time
+}
+
+var (
+	goInfo           = desc{name: "go_info", help: "Information about the Go environment.", typ: "gauge", labels: []string{"version"}}
+	goGoroutines     = desc{name: "go_goroutines", help: "Number of goroutines that currently exist.", typ: "gauge"}
+	goThreads        = desc{name: "go_threads", help: "Number of OS threads created.", typ: "gauge"}
+	goHeapAlloc      = desc{name: "go_memstats_heap_alloc_bytes", help: "Number of heap bytes allocated and still in use.", typ: "gauge"}
+	goHeapObjects    = desc{name: "go_memstats_heap_objects", help: "Number of allocated objects.", typ: "gauge"}
+	goSys            = desc{name: "go_memstats_sys_bytes", help: "Number of bytes obtained from the system.", typ: "gauge"}
+	goAllocTotal     = desc{name: "go_memstats_alloc_bytes_total", help: "Total number of bytes allocated, even if freed.", typ: "counter"}
+	goGCCycles       = desc{name: "go_gc_cycles_total", help: "Number of completed GC cycles.", typ: "counter"}
+	goGCPause        = desc{name: "go_gc_pause_seconds_total", help: "Total time the world was stopped for GC.", typ: "counter"}
+	processStartTime = desc{name: "process_start_time_seconds", help: "Start time of the process since the Unix epoch in seconds.", typ: "gauge"}
+)
+
+// RegisterRuntime adds goroutine, thread, memory and GC statistics and the
+// process start time.
+func RegisterRuntime(r *Registry) {
+	r.register(&runtimeFamily{start: time.Now()},
+		goInfo.name, goGoroutines.name, goThreads.name, goHeapAlloc.name, goHeapObjects.name,
+		goSys.name, goAllocTotal.name, goGCCycles.name, goGCPause.name, processStartTime.name)
+}
+func (f *runtimeFamily) write(w *bufio.Writer) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	threads, _ := runtime.ThreadCreateProfile(nil)
+
+	gauge := func(d desc, value float64, labelValues ...string) {
+		d.writeHeader(w)
+		d.writeSample(w, "", labelValues, "", value)
+	}
+	gauge(goInfo, 1, runtime.Version())
+	gauge(goGoroutines, float64(runtime.NumGoroutine()))
+	gauge(goThreads, float64(threads))
+	gauge(goHeapAlloc, float64(stats.HeapAlloc))
+	gauge(goHeapObjects, float64(stats.HeapObjects))
+	gauge(goSys, float64(stats.Sys))
+	gauge(goAllocTotal, float64(stats.TotalAlloc))
+	gauge(goGCCycles, float64(stats.NumGC))
+	gauge(goGCPause, time.Duration(stats.PauseTotalNs).Seconds())
+	gauge(processStartTime, float64(f.start.UnixNano())/1e9)
+}
@@ -0,0 +1,48 @@
+package http
+
+import (
+	"context"
+	"net/http"
+
+	"simplesurance/internal/logging"
+)
+
+// ReloadFunc re-reads the configuration and applies what can change while
+// serving. It returns the changed settings that only take effect after a
+// restart.
+type ReloadFunc func(ctx context.Context) (restartRequired []string, err error)
+
+type AdminHandler struct {
+	reload ReloadFunc
+}
+func NewAdminHandler(reload ReloadFunc) *AdminHandler {
+	return &AdminHandler{
+		reload: reload,
+	}
+}
+
+type reloadResponse struct {
+	Status          string   `json:"status"`
+	RestartRequired []string `json:"restart_required"`
+}
+
+// HandleReload reloads the configuration, as SIGHUP does. The running
+// configuration is kept if the new one is invalid. The errors name files, so
+// they are only logged.
+func (h *AdminHandler) HandleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondJSON(w, r, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	restartRequired, err := h.reload(r.Context())
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to reload configuration", "error", err)
+		respondJSON(w, r, http.StatusInternalServerError, map[string]string{"error": "failed to reload configuration, see the server log"})
+		return
+	}
+	if restartRequired == nil {
+		restartRequired = []string{}
+	}
+	respondJSON(w, r, http.StatusOK, reloadResponse{Status: "reloaded", RestartRequired: restartRequired})
+}
@@ -0,0 +1,75 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminHandler_HandleReload(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		reload     ReloadFunc
+		wantStatus int
+		wantBody   string
+		// hidden must not be in the body.
+		hidden string
+	}{
+		{
+			name:   "applied",
+			method: http.MethodPost,
+			reload: func(context.Context) ([]string, error) {
+				return nil, nil
+			},
+			wantStatus: http.StatusOK,
+			wantBody:   `{"status":"reloaded","restart_required":[]}`,
+		},
+		{
+			name:   "needs restart",
+			method: http.MethodPost,
+			reload: func(context.Context) ([]string, error) {
+				return []string{"PORT"}, nil
+			},
+			wantStatus: http.StatusOK,
+			wantBody:   `"restart_required":["PORT"]`,
+		},
+		{
+			name:   "invalid configuration",
+			method: http.MethodPost,
+			reload: func(context.Context) ([]string, error) {
+				return nil, errors.New(`invalid filename "/etc/app/timestamps.log"`)
+			},
+			wantStatus: http.StatusInternalServerError,
+			wantBody:   `failed to reload configuration`,
+			hidden:     `/etc/app`,
+		},
+		{
+			name:   "wrong method",
+			method: http.MethodGet,
+			reload: func(context.Context) ([]string, error) {
+				t.Error("reloaded on GET")
+				return nil, nil
+			},
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			NewAdminHandler(tt.reload).HandleReload(w, httptest.NewRequest(tt.method, "/admin/reload", nil))
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("body = %s, want it to contain %s", w.Body.String(), tt.wantBody)
+			}
+			if tt.hidden != "" && strings.Contains(w.Body.String(), tt.hidden) {
+				t.Errorf("body = %s, want no %s", w.Body.String(), tt.hidden)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"simplesurance/internal/application"
+	"simplesurance/internal/domain"
+	"simplesurance/internal/logging"
+)
+
+const CountersPrefix = "/counters/"
+
+type CounterHandler struct {
+	registry *application.CounterRegistry
+}
+func NewCounterHandler(registry *application.CounterRegistry) *CounterHandler {
+	return &CounterHandler{
+		registry: registry,
+	}
+}
+
+// HandleCounter records a hit on the counter named by the path, /counters/{name}.
+func (h *CounterHandler) HandleCounter(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.respondError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, CountersPrefix)
+	if name == "" || strings.Contains(name, "/") {
+		h.respondError(w, r, http.StatusNotFound, "not found")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	counter, err := h.registry.Get(ctx, name)
+	switch {
+	case errors.Is(err, domain.ErrInvalidInput):
+		h.respondError(w, r, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, domain.ErrNotFound):
+		h.respondError(w, r, http.StatusNotFound, "unknown counter")
+		return
+	case errors.Is(err, application.ErrTooManyCounters):
+		h.respondError(w, r, counterStatus(err), err.Error())
+		return
+	case err != nil:
+		logging.FromContext(ctx).Error("failed to open counter", "counter", name, "error", err)
+		h.respondError(w, r, http.StatusInternalServerError, "failed to open counter")
+		return
+	}
+
+	decision, err := counter.TryRecord(ctx)
+	if err != nil {
+		logging.FromContext(ctx).Error("failed to record timestamp", "counter", name, "error", err)
+		h.respondError(w, r, http.StatusInternalServerError, "failed to record timestamp")
+		return
+	}
+
+	writeRateLimitHeaders(w, decision)
+	if !decision.Allowed {
+		h.respondError(w, r, http.StatusTooManyRequests, "rate limit exceeded")
+		return
+	}
+	if err := waitForTurn(r.Context(), decision); err != nil {
+		h.respondError(w, r, http.StatusServiceUnavailable, "request cancelled while queued")
+		return
+	}
+	respondJSON(w, r, http.StatusOK, map[string]interface{}{"counter": name, "count": decision.Count})
+}
+func (h *CounterHandler) respondError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	respondJSON(w, r, status, map[string]string{"error": message})
+}
@@ -0,0 +1,74 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"simplesurance/internal/application"
+)
+
+type HealthHandler struct {
+	health *application.Health
+}
+func NewHealthHandler(health *application.Health) *HealthHandler {
+	return &HealthHandler{
+		health: health,
+	}
+}
+
+// HandleLivez reports whether the process should be restarted.
+func (h *HealthHandler) HandleLivez(w http.ResponseWriter, r *http.Request) {
+	h.respondProbe(w, r, h.health.Liveness)
+}
+
+// HandleReadyz reports whether the server should receive traffic.
+func (h *HealthHandler) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	h.respondProbe(w, r, h.health.Readiness)
+}
+
+// WhenStarted answers 503 until the counters have loaded, so requests that
+// arrive before readiness is observed don't touch half-loaded counters.
+func (h *HealthHandler) WhenStarted(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.health.Started() {
+			w.Header().Set("Retry-After", "1")
+			respondJSON(w, r, http.StatusServiceUnavailable, map[string]string{"error": application.ErrStarting.Error()})
+			return
+		}
+		next(w, r)
+	}
+}
+
+type checkStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type probeResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkStatus `json:"checks"`
+}
+
+func (h *HealthHandler) respondProbe(w http.ResponseWriter, r *http.Request, probe func(context.Context) []application.CheckResult) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		respondJSON(w, r, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	response := probeResponse{Status: "ok", Checks: make(map[string]checkStatus)}
+	status := http.StatusOK
+	for _, result := range probe(ctx) {
+		if result.Err != nil {
+			response.Checks[result.Name] = checkStatus{Status: "fail", Error: result.Err.Error()}
+			response.Status = "fail"
+			status = http.StatusServiceUnavailable
+			continue
+		}
+		response.Checks[result.Name] = checkStatus{Status: "ok"}
+	}
+	respondJSON(w, r, status, response)
+}
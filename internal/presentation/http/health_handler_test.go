@@ -0,0 +1,78 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"simplesurance/internal/application"
+)
+
+func TestHealthHandler_HandleReadyz(t *testing.T) {
+	health := application.NewHealth(application.WithMaxSyncFailures(1))
+	handler := NewHealthHandler(health)
+
+	probe := func() (int, probeResponse) {
+		w := httptest.NewRecorder()
+		handler.HandleReadyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var body probeResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("invalid JSON body %q: %v", w.Body.String(), err)
+		}
+		return w.Code, body
+	}
+
+	steps := []struct {
+		name       string
+		apply      func()
+		wantStatus int
+		wantFailed string
+	}{
+		{name: "starting", apply: func() {}, wantStatus: http.StatusServiceUnavailable, wantFailed: "startup"},
+		{name: "started", apply: health.MarkStarted, wantStatus: http.StatusOK},
+		{name: "sync failed", apply: func() { health.ObserveSync("timestamps.log", errors.New("disk full")) }, wantStatus: http.StatusServiceUnavailable, wantFailed: "persistence"},
+		{name: "sync recovered", apply: func() { health.ObserveSync("timestamps.log", nil) }, wantStatus: http.StatusOK},
+		{name: "draining", apply: health.MarkDraining, wantStatus: http.StatusServiceUnavailable, wantFailed: "shutdown"},
+	}
+	for _, step := range steps {
+		step.apply()
+		status, body := probe()
+		if status != step.wantStatus {
+			t.Fatalf("%s: status = %d, want %d", step.name, status, step.wantStatus)
+		}
+		if len(body.Checks) != 3 {
+			t.Errorf("%s: checks = %v, want startup, shutdown and persistence", step.name, body.Checks)
+		}
+		for name, check := range body.Checks {
+			wantStatus := "ok"
+			if name == step.wantFailed {
+				wantStatus = "fail"
+			}
+			if check.Status != wantStatus {
+				t.Errorf("%s: check %s = %+v, want %s", step.name, name, check, wantStatus)
+			}
+		}
+	}
+}
+
+func TestHealthHandler_WhenStarted(t *testing.T) {
+	health := application.NewHealth()
+	handler := NewHealthHandler(health).WhenStarted(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("before start: status = %d, Retry-After = %q, want 503 with Retry-After", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	health.MarkStarted()
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("after start: status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+}
@@ -0,0 +1,75 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"simplesurance/internal/logging"
+	"simplesurance/internal/metrics"
+)
+
+// Metrics counts requests and their latency by route and status.
+type Metrics struct {
+	requests *metrics.CounterVec
+	duration *metrics.HistogramVec
+}
+
+func NewMetrics(r *metrics.Registry) *Metrics {
+	return &Metrics{
+		requests: r.NewCounterVec("http_requests_total",
+			"HTTP requests served by route and status code.", "route", "code"),
+		duration: r.NewHistogramVec("http_request_duration_seconds",
+			"Time taken to serve HTTP requests by route and status code.", metrics.DefaultBuckets, "route", "code"),
+	}
+}
+
+// Instrument reports requests to next under route, which should be the
+// pattern next is registered with rather than the request path, to keep the
+// number of series bounded.
+func (m *Metrics) Instrument(route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(recorder, r)
+
+		code := strconv.Itoa(recorder.status)
+		m.requests.WithLabelValues(route, code).Inc()
+		m.duration.WithLabelValues(route, code).Observe(time.Since(start).Seconds())
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+type MetricsHandler struct {
+	registry *metrics.Registry
+}
+func NewMetricsHandler(registry *metrics.Registry) *MetricsHandler {
+	return &MetricsHandler{
+		registry: registry,
+	}
+}
+
+// HandleMetrics writes every metric in the Prometheus text format.
+func (h *MetricsHandler) HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		respondJSON(w, r, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	w.Header().Set("Content-Type", metrics.ContentType)
+	if _, err := h.registry.WriteTo(w); err != nil {
+		logging.FromContext(r.Context()).Error("failed to write metrics", "error", err)
+	}
+}
@@ -0,0 +1,40 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"simplesurance/internal/metrics"
+)
+
+func TestMetrics_Instrument(t *testing.T) {
+	registry := metrics.NewRegistry()
+	m := NewMetrics(registry)
+	handler := m.Instrument("/items/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/items/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	for _, path := range []string{"/items/a", "/items/b", "/items/missing"} {
+		handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	w := httptest.NewRecorder()
+	NewMetricsHandler(registry).HandleMetrics(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if got := w.Header().Get("Content-Type"); got != metrics.ContentType {
+		t.Errorf("Content-Type = %q, want %q", got, metrics.ContentType)
+	}
+	for _, want := range []string{
+		`http_requests_total{route="/items/",code="200"} 2`,
+		`http_requests_total{route="/items/",code="404"} 1`,
+		`http_request_duration_seconds_count{route="/items/",code="200"} 2`,
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("metrics output is missing %q:\n%s", want, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package http
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"simplesurance/internal/application"
+	"simplesurance/internal/logging"
+)
+
+var ErrNoClientKey = errors.New("request has no client key")
+
+// KeyFunc picks the rate-limiting key of a request. Requests for which it
+// returns an error are rejected with 400 Bad Request.
+type KeyFunc func(r *http.Request) (string, error)
+
+// ClientIP keys requests by the remote address of the connection.
+func ClientIP(r *http.Request) (string, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if host == "" {
+		return "", ErrNoClientKey
+	}
+	return host, nil
+}
+
+// ForwardedClientIP keys requests by client IP like ClientIP, but when the
+// connection comes from a trusted proxy it walks X-Forwarded-For from the
+// right and uses the first address that is not a trusted proxy. Without
+// trusted proxies the header is ignored, since any client can set it.
+func ForwardedClientIP(trusted []netip.Prefix) KeyFunc {
+	isTrusted := func(addr netip.Addr) bool {
+		for _, prefix := range trusted {
+			if prefix.Contains(addr.Unmap()) {
+				return true
+			}
+		}
+		return false
+	}
+	return func(r *http.Request) (string, error) {
+		host, err := ClientIP(r)
+		if err != nil {
+			return "", err
+		}
+		addr, err := netip.ParseAddr(host)
+		if err != nil || !isTrusted(addr) {
+			return host, nil
+		}
+
+		hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				// Anything left of a malformed entry cannot be trusted.
+				break
+			}
+			addr = hop
+			if !isTrusted(hop) {
+				break
+			}
+		}
+		return addr.Unmap().String(), nil
+	}
+}
+
+// HeaderKey keys requests by the value of a header such as "X-API-Key".
+func HeaderKey(name string) KeyFunc {
+	return func(r *http.Request) (string, error) {
+		if value := r.Header.Get(name); value != "" {
+			return value, nil
+		}
+		return "", ErrNoClientKey
+	}
+}
+
+// QueryKey keys requests by the value of a query parameter such as "api_key".
+func QueryKey(name string) KeyFunc {
+	return func(r *http.Request) (string, error) {
+		if value := r.URL.Query().Get(name); value != "" {
+			return value, nil
+		}
+		return "", ErrNoClientKey
+	}
+}
+
+// KeySwitch is a KeyFunc that can be replaced while requests are being
+// served, so configuration reloads can change how clients are told apart.
+type KeySwitch struct {
+	key atomic.Pointer[KeyFunc]
+}
+
+func NewKeySwitch(key KeyFunc) *KeySwitch {
+	s := &KeySwitch{}
+	s.Set(key)
+	return s
+}
+func (s *KeySwitch) Set(key KeyFunc) {
+	s.key.Store(&key)
+}
+
+// Key calls the current KeyFunc.
+func (s *KeySwitch) Key(r *http.Request) (string, error) {
+	return (*s.key.Load())(r)
+}
+
+// RateLimit keeps one sliding window per key in registry, so the limit and
+// threshold come from the registry's service options. Allowed requests are
+// passed to next with RateLimit-* headers set; rejected ones get 429.
+func RateLimit(registry *application.CounterRegistry, key KeyFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			clientKey, err := key(r)
+			if err != nil {
+				respondJSON(w, r, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+			defer cancel()
+
+			counter, release, err := keyedCounter(ctx, registry, clientKey)
+			if err != nil {
+				logging.FromContext(ctx).Error("failed to apply rate limit", "error", err)
+				respondJSON(w, r, counterStatus(err), map[string]string{"error": "failed to apply rate limit"})
+				return
+			}
+			decision, err := counter.TryRecord(ctx)
+			release()
+			if err != nil {
+				logging.FromContext(ctx).Error("failed to apply rate limit", "error", err)
+				respondJSON(w, r, http.StatusInternalServerError, map[string]string{"error": "failed to apply rate limit"})
+				return
+			}
+
+			writeRateLimitHeaders(w, decision)
+			if !decision.Allowed {
+				respondJSON(w, r, http.StatusTooManyRequests, map[string]string{"error": "rate limit exceeded"})
+				return
+			}
+			if err := waitForTurn(r.Context(), decision); err != nil {
+				respondJSON(w, r, http.StatusServiceUnavailable, map[string]string{"error": "request cancelled while queued"})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// keyedCounter acquires the client's counter, which is not evicted until
+// release is called.
+func keyedCounter(ctx context.Context, registry *application.CounterRegistry, clientKey string) (application.Counter, func(), error) {
+	return registry.Acquire(ctx, keyedCounterName(clientKey))
+}
+
+// keyedCounterName hashes the client key, so that any string is a valid
+// counter name.
+func keyedCounterName(clientKey string) string {
+	sum := sha256.Sum256([]byte(clientKey))
+	return hex.EncodeToString(sum[:16])
+}
+
+// counterStatus maps errors from opening a counter. Running out of room for
+// counters is the server's limit, not the client's, and idle ones may be
+// evicted or the limit raised, so it is reported as unavailable.
+func counterStatus(err error) int {
+	if errors.Is(err, application.ErrTooManyCounters) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,115 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"simplesurance/internal/application"
+	"simplesurance/internal/clock"
+	"simplesurance/internal/domain"
+	"simplesurance/internal/infrastructure/repository"
+)
+
+func newTestRegistry(limit int, clk domain.Clock) *application.CounterRegistry {
+	storage := application.Storage{
+		Timestamps: func(name string) (domain.TimestampRepository, error) {
+			return repository.NewMemoryStore("", nil, repository.WithRingBuffer()), nil
+		},
+	}
+	return application.NewCounterRegistry(storage, application.CounterSpec{Threshold: time.Minute},
+		application.WithServiceOptions(application.WithClock(clk), application.WithLimit(limit)))
+}
+
+func TestRateLimit(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1_700_000_000, 0))
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	handler := RateLimit(newTestRegistry(2, fake), HeaderKey("X-API-Key"))(next)
+
+	request := func(apiKey string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if apiKey != "" {
+			r.Header.Set("X-API-Key", apiKey)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	steps := []struct {
+		name       string
+		apiKey     string
+		advance    time.Duration
+		wantStatus int
+		wantRetry  string
+	}{
+		{name: "first request", apiKey: "alice", wantStatus: http.StatusNoContent},
+		{name: "second request", apiKey: "alice", advance: 20 * time.Second, wantStatus: http.StatusNoContent},
+		{name: "over the limit", apiKey: "alice", advance: 10 * time.Second, wantStatus: http.StatusTooManyRequests, wantRetry: "30"},
+		{name: "other key has its own window", apiKey: "bob", wantStatus: http.StatusNoContent},
+		{name: "oldest hit expired", apiKey: "alice", advance: 30 * time.Second, wantStatus: http.StatusNoContent},
+		{name: "missing key", wantStatus: http.StatusBadRequest},
+	}
+	for _, step := range steps {
+		fake.Advance(step.advance)
+		w := request(step.apiKey)
+		if w.Code != step.wantStatus {
+			t.Fatalf("%s: status = %d, want %d", step.name, w.Code, step.wantStatus)
+		}
+		if got := w.Header().Get("Retry-After"); got != step.wantRetry {
+			t.Errorf("%s: Retry-After = %q, want %q", step.name, got, step.wantRetry)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		remoteAddr string
+		want       string
+	}{
+		{remoteAddr: "192.0.2.1:1234", want: "192.0.2.1"},
+		{remoteAddr: "[2001:db8::1]:443", want: "2001:db8::1"},
+		{remoteAddr: "192.0.2.1", want: "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		if got, err := ClientIP(r); err != nil || got != tt.want {
+			t.Errorf("ClientIP(%q) = %q, %v, want %q", tt.remoteAddr, got, err, tt.want)
+		}
+	}
+}
+
+func TestForwardedClientIP(t *testing.T) {
+	key := ForwardedClientIP([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")})
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		want         string
+	}{
+		{name: "untrusted peer ignores header", remoteAddr: "192.0.2.1:1234", forwardedFor: []string{"198.51.100.7"}, want: "192.0.2.1"},
+		{name: "trusted peer without header", remoteAddr: "10.0.0.1:1234", want: "10.0.0.1"},
+		{name: "trusted peer", remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"198.51.100.7"}, want: "198.51.100.7"},
+		{name: "spoofed entries left of the client", remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"203.0.113.9, 198.51.100.7, 10.0.0.2"}, want: "198.51.100.7"},
+		{name: "repeated headers", remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"203.0.113.9", "198.51.100.7"}, want: "198.51.100.7"},
+		{name: "only proxies", remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"10.0.0.3, 10.0.0.2"}, want: "10.0.0.3"},
+		{name: "malformed entry", remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"198.51.100.7, garbage"}, want: "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwardedFor {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if got, err := key(r); err != nil || got != tt.want {
+				t.Errorf("ForwardedClientIP() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package http
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"simplesurance/internal/application"
+)
+
+// writeRateLimitHeaders sets the RateLimit-* headers for a limited service and
+// Retry-After for a rejected request. Durations are rounded up to whole seconds.
+func writeRateLimitHeaders(w http.ResponseWriter, d application.Decision) {
+	if d.Limit == 0 {
+		return
+	}
+	h := w.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(d.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(d.Reset)))
+	if !d.Allowed {
+		h.Set("Retry-After", strconv.Itoa(max(ceilSeconds(d.RetryAfter()), 1)))
+	}
+}
+
+// waitForTurn holds an allowed request for the delay the leaky bucket gave it.
+// It waits on the request's own context, not the handler's storage timeout.
+// The counter caps the delay with MAX_WAIT, below the server's write timeout.
+func waitForTurn(ctx context.Context, d application.Decision) error {
+	if d.Delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d.Delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,67 @@
+package http
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"simplesurance/internal/logging"
+	"simplesurance/internal/tracing"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+// RequestLogger gives every request a logger carrying its ID, method and
+// path, reachable through logging.FromContext, and logs each request once
+// served. Behind Trace it reuses the request ID and adds the trace and span
+// IDs; on its own it takes the ID from X-Request-ID when a caller sets a
+// sensible one, and echoes it in the response.
+func RequestLogger(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			id := tracing.RequestID(r.Context())
+			if id == "" {
+				id = r.Header.Get(RequestIDHeader)
+				if !validRequestID(id) {
+					id = newRequestID()
+				}
+				w.Header().Set(RequestIDHeader, id)
+			}
+
+			requestLogger := logger.With("request_id", id, "method", r.Method, "path", r.URL.Path)
+			if sc := tracing.SpanFromContext(r.Context()).SpanContext(); sc.IsValid() {
+				requestLogger = requestLogger.With("trace_id", sc.TraceID.String(), "span_id", sc.SpanID.String())
+			}
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(recorder, r.WithContext(logging.WithLogger(r.Context(), requestLogger)))
+
+			requestLogger.Info("request served",
+				"status", recorder.status,
+				"latency", time.Since(start),
+				"remote_addr", r.RemoteAddr,
+			)
+		})
+	}
+}
+
+// validRequestID accepts up to 128 printable ASCII characters, so IDs from
+// callers cannot forge log lines.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
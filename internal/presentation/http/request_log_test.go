@@ -0,0 +1,70 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"simplesurance/internal/logging"
+)
+
+func TestRequestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	handler := RequestLogger(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logging.FromContext(r.Context()).Info("inside handler")
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	tests := []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{name: "caller's ID is kept", incoming: "abc-123", keep: true},
+		{name: "missing ID is generated"},
+		{name: "unsafe ID is replaced", incoming: "abc\ninjected"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+			r := httptest.NewRequest(http.MethodPost, "/items", nil)
+			if tt.incoming != "" {
+				r.Header.Set(RequestIDHeader, tt.incoming)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			id := w.Header().Get(RequestIDHeader)
+			if tt.keep && id != tt.incoming {
+				t.Errorf("%s = %q, want %q", RequestIDHeader, id, tt.incoming)
+			}
+			if !tt.keep && (id == "" || id == tt.incoming) {
+				t.Errorf("%s = %q, want a generated ID", RequestIDHeader, id)
+			}
+
+			var lines []map[string]interface{}
+			for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+				var entry map[string]interface{}
+				if err := json.Unmarshal(line, &entry); err != nil {
+					t.Fatalf("invalid log line %q: %v", line, err)
+				}
+				lines = append(lines, entry)
+			}
+			if len(lines) != 2 {
+				t.Fatalf("logged %d lines, want 2", len(lines))
+			}
+			for _, entry := range lines {
+				if entry["request_id"] != id || entry["method"] != http.MethodPost || entry["path"] != "/items" {
+					t.Errorf("log entry %v lacks the request attributes", entry)
+				}
+			}
+			if lines[1]["status"] != float64(http.StatusTeapot) || lines[1]["latency"] == nil {
+				t.Errorf("request log %v, want status %d and latency", lines[1], http.StatusTeapot)
+			}
+		})
+	}
+}
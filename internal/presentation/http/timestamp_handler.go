@@ -3,55 +3,146 @@ package http
 import (
 	"context"
 	"encoding/json"
-	"log"
 	"net/http"
 	"time"
 
 	"simplesurance/internal/application"
+	"simplesurance/internal/logging"
 )
 type TimestampHandler struct {
-	service *application.TimestampService
-	logger  *log.Logger
+	service  application.Counter
+	registry *application.CounterRegistry
+	key      KeyFunc
 }
-func NewTimestampHandler(service *application.TimestampService, logger *log.Logger) *TimestampHandler {
+func NewTimestampHandler(service application.Counter) *TimestampHandler {
 	return &TimestampHandler{
 		service: service,
-		logger:  logger,
+	}
+}
+
+// NewKeyedTimestampHandler counts every client separately, in a counter of
+// registry picked by key.
+func NewKeyedTimestampHandler(registry *application.CounterRegistry, key KeyFunc) *TimestampHandler {
+	return &TimestampHandler{
+		registry: registry,
+		key:      key,
 	}
 }
 func (h *TimestampHandler) HandleTimestamp(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		h.respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		h.respondError(w, r, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
 
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
 
-	count, err := h.service.RecordTimestamp(ctx)
+	counter, release, ok := h.counter(ctx, w, r)
+	if !ok {
+		return
+	}
+	decision, err := counter.TryRecord(ctx)
+	release()
 	if err != nil {
-		h.logger.Printf("error recording timestamp: %v", err)
-		h.respondError(w, http.StatusInternalServerError, "failed to record timestamp")
+		logging.FromContext(ctx).Error("failed to record timestamp", "error", err)
+		h.respondError(w, r, http.StatusInternalServerError, "failed to record timestamp")
 		return
 	}
 
-	h.respondJSON(w, http.StatusOK, map[string]int{"count": count})
+	writeRateLimitHeaders(w, decision)
+	if !decision.Allowed {
+		h.respondError(w, r, http.StatusTooManyRequests, "rate limit exceeded")
+		return
+	}
+	if err := waitForTurn(r.Context(), decision); err != nil {
+		h.respondError(w, r, http.StatusServiceUnavailable, "request cancelled while queued")
+		return
+	}
+	h.respondJSON(w, r, http.StatusOK, map[string]int{"count": decision.Count})
 }
-func (h *TimestampHandler) respondJSON(w http.ResponseWriter, status int, data interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	if err := json.NewEncoder(w).Encode(data); err != nil {
-		h.logger.Printf("error encoding JSON response: %v", err)
+// HandleCount reports the current count without recording a hit.
+func (h *TimestampHandler) HandleCount(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		h.respondError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
 	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	counter, release, ok := h.openCounter(w, r)
+	if !ok {
+		return
+	}
+	// A client without a counter has no hits in the window.
+	var count int
+	var err error
+	if counter != nil {
+		count, err = counter.Peek(ctx)
+		release()
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("failed to read count", "error", err)
+		h.respondError(w, r, http.StatusInternalServerError, "failed to read count")
+		return
+	}
+
+	h.respondJSON(w, r, http.StatusOK, map[string]int{"count": count})
+}
+// counter returns the counter of the request's client, or the shared one,
+// and a release func to call once it is used. It responds with an error
+// itself when there is none.
+func (h *TimestampHandler) counter(ctx context.Context, w http.ResponseWriter, r *http.Request) (application.Counter, func(), bool) {
+	if h.registry == nil {
+		return h.service, func() {}, true
+	}
+	clientKey, err := h.key(r)
+	if err != nil {
+		h.respondError(w, r, http.StatusBadRequest, err.Error())
+		return nil, nil, false
+	}
+	counter, release, err := keyedCounter(ctx, h.registry, clientKey)
+	if err != nil {
+		logging.FromContext(ctx).Error("failed to open client counter", "error", err)
+		h.respondError(w, r, counterStatus(err), "failed to open client counter")
+		return nil, nil, false
+	}
+	return counter, release, true
+}
+
+// openCounter is counter for reads: it returns a nil counter instead of
+// creating one for a client that has none.
+func (h *TimestampHandler) openCounter(w http.ResponseWriter, r *http.Request) (application.Counter, func(), bool) {
+	if h.registry == nil {
+		return h.service, func() {}, true
+	}
+	clientKey, err := h.key(r)
+	if err != nil {
+		h.respondError(w, r, http.StatusBadRequest, err.Error())
+		return nil, nil, false
+	}
+	counter, release, _ := h.registry.Lookup(keyedCounterName(clientKey))
+	return counter, release, true
+}
+func (h *TimestampHandler) respondJSON(w http.ResponseWriter, r *http.Request, status int, data interface{}) {
+	respondJSON(w, r, status, data)
 }
 func (h *TimestampHandler) HandleHealth(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		h.respondError(w, http.StatusMethodNotAllowed, "method not allowed")
+		h.respondError(w, r, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("OK"))
 }
-func (h *TimestampHandler) respondError(w http.ResponseWriter, status int, message string) {
-	h.respondJSON(w, status, map[string]string{"error": message})
+func (h *TimestampHandler) respondError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	h.respondJSON(w, r, status, map[string]string{"error": message})
+}
+
+func respondJSON(w http.ResponseWriter, r *http.Request, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		logging.FromContext(r.Context()).Error("failed to encode JSON response", "error", err)
+	}
 }
@@ -0,0 +1,100 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"simplesurance/internal/application"
+	"simplesurance/internal/clock"
+	"simplesurance/internal/domain"
+	"simplesurance/internal/infrastructure/repository"
+)
+
+func TestKeyedTimestampHandler(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1_700_000_000, 0))
+	storage := application.Storage{
+		Timestamps: func(name string) (domain.TimestampRepository, error) {
+			return repository.NewMemoryStore("", nil, repository.WithRingBuffer()), nil
+		},
+	}
+	registry := application.NewCounterRegistry(storage, application.CounterSpec{Threshold: time.Minute},
+		application.WithMaxCounters(2),
+		application.WithEviction(),
+		application.WithServiceOptions(application.WithClock(fake)))
+	handler := NewKeyedTimestampHandler(registry, QueryKey("client"))
+
+	request := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.HandleTimestamp(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w
+	}
+
+	steps := []struct {
+		name       string
+		target     string
+		advance    time.Duration
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "first client", target: "/?client=a", wantStatus: http.StatusOK, wantBody: `{"count":1}`},
+		{name: "first client again", target: "/?client=a", wantStatus: http.StatusOK, wantBody: `{"count":2}`},
+		{name: "second client counts separately", target: "/?client=b", advance: 30 * time.Second, wantStatus: http.StatusOK, wantBody: `{"count":1}`},
+		{name: "no room for a third client", target: "/?client=c", wantStatus: http.StatusServiceUnavailable},
+		{name: "idle first client is evicted", target: "/?client=c", advance: 30 * time.Second, wantStatus: http.StatusOK, wantBody: `{"count":1}`},
+		{name: "evicted client starts over", target: "/?client=a", advance: 30 * time.Second, wantStatus: http.StatusOK, wantBody: `{"count":1}`},
+		{name: "missing key", target: "/", wantStatus: http.StatusBadRequest},
+	}
+	for _, step := range steps {
+		fake.Advance(step.advance)
+		w := request(step.target)
+		if w.Code != step.wantStatus {
+			t.Fatalf("%s: status = %d, want %d", step.name, w.Code, step.wantStatus)
+		}
+		if step.wantBody != "" && w.Body.String() != step.wantBody+"\n" {
+			t.Errorf("%s: body = %q, want %q", step.name, w.Body.String(), step.wantBody)
+		}
+	}
+}
+
+func TestKeyedTimestampHandler_CountDoesNotOpenCounters(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1_700_000_000, 0))
+	storage := application.Storage{
+		Timestamps: func(name string) (domain.TimestampRepository, error) {
+			return repository.NewMemoryStore("", nil, repository.WithRingBuffer()), nil
+		},
+	}
+	registry := application.NewCounterRegistry(storage, application.CounterSpec{Threshold: time.Minute},
+		application.WithMaxCounters(1),
+		application.WithEviction(),
+		application.WithServiceOptions(application.WithClock(fake)))
+	handler := NewKeyedTimestampHandler(registry, QueryKey("client"))
+
+	steps := []struct {
+		name       string
+		handle     http.HandlerFunc
+		target     string
+		advance    time.Duration
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "unknown client counts nothing", handle: handler.HandleCount, target: "/count?client=a", wantStatus: http.StatusOK, wantBody: `{"count":0}`},
+		{name: "first client takes the only slot", handle: handler.HandleTimestamp, target: "/?client=a", wantStatus: http.StatusOK, wantBody: `{"count":1}`},
+		{name: "count of an unknown client needs no slot", handle: handler.HandleCount, target: "/count?client=b", wantStatus: http.StatusOK, wantBody: `{"count":0}`},
+		{name: "known client", handle: handler.HandleCount, target: "/count?client=a", wantStatus: http.StatusOK, wantBody: `{"count":1}`},
+		{name: "expired hit is not counted", handle: handler.HandleCount, target: "/count?client=a", advance: time.Minute, wantStatus: http.StatusOK, wantBody: `{"count":0}`},
+		{name: "missing key", handle: handler.HandleCount, target: "/count", wantStatus: http.StatusBadRequest},
+	}
+	for _, step := range steps {
+		fake.Advance(step.advance)
+		w := httptest.NewRecorder()
+		step.handle(w, httptest.NewRequest(http.MethodGet, step.target, nil))
+		if w.Code != step.wantStatus {
+			t.Fatalf("%s: status = %d, want %d", step.name, w.Code, step.wantStatus)
+		}
+		if step.wantBody != "" && w.Body.String() != step.wantBody+"\n" {
+			t.Errorf("%s: body = %q, want %q", step.name, w.Body.String(), step.wantBody)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package http
+
+import (
+	"net/http"
+
+	"simplesurance/internal/tracing"
+)
+
+const TraceparentHeader = "traceparent"
+
+// Trace gives every request an ID and a server span. The request ID is taken
+// from X-Request-ID when a caller sets a sensible one, and the span continues
+// the caller's trace when it sends a valid traceparent. Both are echoed in
+// the response and carried in the request context for handlers and
+// RequestLogger.
+func Trace(tracer *tracing.Tracer) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(RequestIDHeader)
+			if !validRequestID(id) {
+				id = newRequestID()
+			}
+			remote, _ := tracing.ParseTraceparent(r.Header.Get(TraceparentHeader))
+
+			ctx, span := tracer.StartServer(r.Context(), r.Method+" "+r.URL.Path, remote)
+			defer span.End()
+			ctx = tracing.ContextWithRequestID(ctx, id)
+
+			w.Header().Set(RequestIDHeader, id)
+			w.Header().Set(TraceparentHeader, span.SpanContext().Traceparent())
+
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(recorder, r.WithContext(ctx))
+
+			span.SetAttributes(
+				tracing.Attribute{Key: "http.request.method", Value: r.Method},
+				tracing.Attribute{Key: "url.path", Value: r.URL.Path},
+				tracing.Attribute{Key: "http.response.status_code", Value: recorder.status},
+				tracing.Attribute{Key: "request.id", Value: id},
+			)
+			if recorder.status >= http.StatusInternalServerError {
+				span.RecordError(errorStatus(recorder.status))
+			}
+		})
+	}
+}
+
+// NameSpan names the request's span after route, the pattern next is
+// registered with, rather than the raw path.
+func NameSpan(route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tracing.SpanFromContext(r.Context()).SetName(r.Method + " " + route)
+		next(w, r)
+	}
+}
+
+type errorStatus int
+
+func (s errorStatus) Error() string {
+	return http.StatusText(int(s))
+}
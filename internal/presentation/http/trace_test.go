@@ -0,0 +1,63 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"simplesurance/internal/tracing"
+)
+
+func TestTrace(t *testing.T) {
+	const incoming = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	var seenID string
+	var seen tracing.SpanContext
+	handler := Trace(tracing.NewTracer(nil))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seenID = tracing.RequestID(r.Context())
+		seen = tracing.SpanFromContext(r.Context()).SpanContext()
+	}))
+
+	tests := []struct {
+		name        string
+		requestID   string
+		traceparent string
+		continues   bool
+	}{
+		{name: "caller's trace is continued", requestID: "abc-123", traceparent: incoming, continues: true},
+		{name: "missing traceparent starts a trace"},
+		{name: "invalid traceparent starts a trace", traceparent: "00-zz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.requestID != "" {
+				r.Header.Set(RequestIDHeader, tt.requestID)
+			}
+			if tt.traceparent != "" {
+				r.Header.Set(TraceparentHeader, tt.traceparent)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			id := w.Header().Get(RequestIDHeader)
+			if id == "" || id != seenID || (tt.requestID != "" && id != tt.requestID) {
+				t.Errorf("%s = %q, handler saw %q, sent %q", RequestIDHeader, id, seenID, tt.requestID)
+			}
+			echoed, err := tracing.ParseTraceparent(w.Header().Get(TraceparentHeader))
+			if err != nil {
+				t.Fatalf("response traceparent: %v", err)
+			}
+			if echoed != seen {
+				t.Errorf("response traceparent = %s, handler saw %s", echoed.Traceparent(), seen.Traceparent())
+			}
+			remote, _ := tracing.ParseTraceparent(incoming)
+			if continues := echoed.TraceID == remote.TraceID; continues != tt.continues {
+				t.Errorf("trace continued = %v, want %v", continues, tt.continues)
+			}
+			if echoed.SpanID == remote.SpanID {
+				t.Error("server span reused the caller's span ID")
+			}
+		})
+	}
+}
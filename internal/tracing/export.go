@@ -0,0 +1,290 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"simplesurance/internal/domain"
+)
+
+const (
+	defaultBatchInterval = 5 * time.Second
+	maxBatchSize         = 512
+	maxQueuedSpans       = 4096
+)
+
+// Exporter sends finished spans to a tracing backend.
+type Exporter interface {
+	Export(ctx context.Context, spans []SpanData) error
+	Shutdown(ctx context.Context) error
+}
+
+// batcher queues ended spans and exports them in batches from a single
+// goroutine, so ending a span never waits on the backend. Spans are dropped
+// when the queue is full.
+type batcher struct {
+	exporter Exporter
+	interval time.Duration
+	queue    chan SpanData
+	stop     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+func newBatcher(exporter Exporter) *batcher {
+	return &batcher{
+		exporter: exporter,
+		interval: defaultBatchInterval,
+		queue:    make(chan SpanData, maxQueuedSpans),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+func (b *batcher) start(clk domain.Clock) {
+	ticker := clk.NewTicker(b.interval)
+	go func() {
+		defer close(b.done)
+		defer ticker.Stop()
+
+		batch := make([]SpanData, 0, maxBatchSize)
+		flush := func() {
+			if len(batch) == 0 {
+				return
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			// Export failures are not retried: traces are best effort.
+			_ = b.exporter.Export(ctx, batch)
+			batch = make([]SpanData, 0, maxBatchSize)
+		}
+		for {
+			select {
+			case span := <-b.queue:
+				batch = append(batch, span)
+				if len(batch) >= maxBatchSize {
+					flush()
+				}
+			case <-ticker.C():
+				flush()
+			case <-b.stop:
+				for {
+					select {
+					case span := <-b.queue:
+						batch = append(batch, span)
+					default:
+						flush()
+						return
+					}
+				}
+			}
+		}
+	}()
+}
+func (b *batcher) enqueue(span SpanData) {
+	select {
+	case b.queue <- span:
+	default:
+	}
+}
+func (b *batcher) shutdown(ctx context.Context) error {
+	b.once.Do(func() { close(b.stop) })
+	select {
+	case <-b.done:
+	case <-ctx.Done():
+		return fmt.Errorf("failed to flush spans: %w", ctx.Err())
+	}
+	return b.exporter.Shutdown(ctx)
+}
+
+// HTTPExporter posts spans to an OTLP/HTTP collector as JSON.
+type HTTPExporter struct {
+	endpoint string
+	service  string
+	client   *http.Client
+}
+
+// NewHTTPExporter posts to endpoint, typically http://collector:4318/v1/traces.
+func NewHTTPExporter(endpoint, service string) *HTTPExporter {
+	return &HTTPExporter{
+		endpoint: endpoint,
+		service:  service,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+func (e *HTTPExporter) Export(ctx context.Context, spans []SpanData) error {
+	body, err := json.Marshal(encodeOTLP(e.service, spans))
+	if err != nil {
+		return fmt.Errorf("failed to encode spans: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create export request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to export spans: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("failed to export spans: collector answered %s", resp.Status)
+	}
+	return nil
+}
+func (e *HTTPExporter) Shutdown(context.Context) error {
+	e.client.CloseIdleConnections()
+	return nil
+}
+
+// FileExporter appends each batch to a file as one line of OTLP/JSON, the
+// same payload HTTPExporter sends, for local debugging.
+type FileExporter struct {
+	service string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewFileExporter(fileName, service string) (*FileExporter, error) {
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trace file: %w", err)
+	}
+	return &FileExporter{service: service, file: file}, nil
+}
+func (e *FileExporter) Export(_ context.Context, spans []SpanData) error {
+	line, err := json.Marshal(encodeOTLP(e.service, spans))
+	if err != nil {
+		return fmt.Errorf("failed to encode spans: %w", err)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, err := e.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write spans: %w", err)
+	}
+	return nil
+}
+func (e *FileExporter) Shutdown(context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.file.Close()
+}
+
+// The types below are the subset of the OTLP/JSON trace payload we emit.
+// IDs are hex and timestamps are decimal strings, as OTLP/JSON requires.
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+const otlpStatusError = 2
+
+func encodeOTLP(service string, spans []SpanData) otlpRequest {
+	encoded := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		s := otlpSpan{
+			TraceID:           span.SpanContext.TraceID.String(),
+			SpanID:            span.SpanContext.SpanID.String(),
+			Name:              span.Name,
+			Kind:              span.Kind,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+		}
+		if !span.Parent.IsZero() {
+			s.ParentSpanID = span.Parent.String()
+		}
+		for _, attr := range span.Attributes {
+			s.Attributes = append(s.Attributes, encodeAttribute(attr))
+		}
+		if span.Err != "" {
+			s.Status = otlpStatus{Code: otlpStatusError, Message: span.Err}
+		}
+		encoded = append(encoded, s)
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpKeyValue{
+			encodeAttribute(Attribute{Key: "service.name", Value: service}),
+		}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "simplesurance/internal/tracing"},
+			Spans: encoded,
+		}},
+	}}}
+}
+func encodeAttribute(attr Attribute) otlpKeyValue {
+	kv := otlpKeyValue{Key: attr.Key}
+	switch v := attr.Value.(type) {
+	case string:
+		kv.Value.StringValue = &v
+	case bool:
+		kv.Value.BoolValue = &v
+	case int:
+		s := strconv.Itoa(v)
+		kv.Value.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		kv.Value.IntValue = &s
+	case float64:
+		kv.Value.DoubleValue = &v
+	default:
+		s := fmt.Sprint(v)
+		kv.Value.StringValue = &s
+	}
+	return kv
+}
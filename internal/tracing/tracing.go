@@ -0,0 +1,219 @@
+// Package tracing records span trees for requests, propagates them with the
+// W3C traceparent header and exports them as OTLP/JSON.
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"simplesurance/internal/clock"
+	"simplesurance/internal/domain"
+)
+
+type SpanKind int
+
+// Values match OTLP's span kinds.
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+)
+
+// Attribute is a key-value pair attached to a span. Values are strings,
+// bools, ints, int64s or float64s; anything else is exported as a string.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// SpanData is a finished span as handed to an Exporter.
+type SpanData struct {
+	Name        string
+	Kind        SpanKind
+	SpanContext SpanContext
+	Parent      SpanID
+	Start       time.Time
+	End         time.Time
+	Attributes  []Attribute
+	Err         string
+}
+
+// Tracer starts root spans. Spans are only recorded when sampled and when
+// the tracer has an exporter; otherwise they still carry IDs, so traceparent
+// is propagated either way.
+type Tracer struct {
+	exporter *batcher
+	clock    domain.Clock
+}
+
+type Option func(*Tracer)
+
+// WithClock replaces the wall clock used to time spans.
+func WithClock(c domain.Clock) Option {
+	return func(t *Tracer) {
+		t.clock = c
+	}
+}
+
+// WithBatchInterval sets how often recorded spans are exported.
+func WithBatchInterval(d time.Duration) Option {
+	return func(t *Tracer) {
+		if d > 0 && t.exporter != nil {
+			t.exporter.interval = d
+		}
+	}
+}
+
+// NewTracer exports to exporter, which may be nil to record nothing.
+func NewTracer(exporter Exporter, opts ...Option) *Tracer {
+	t := &Tracer{clock: clock.System{}}
+	if exporter != nil {
+		t.exporter = newBatcher(exporter)
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	if t.exporter != nil {
+		t.exporter.start(t.clock)
+	}
+	return t
+}
+
+// Shutdown exports the spans still buffered and stops exporting.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t.exporter == nil {
+		return nil
+	}
+	return t.exporter.shutdown(ctx)
+}
+
+// StartServer starts the span of an incoming request. A valid remote parent
+// continues the caller's trace and sampling decision; otherwise a new,
+// sampled trace begins.
+func (t *Tracer) StartServer(ctx context.Context, name string, remote SpanContext) (context.Context, *Span) {
+	sc := SpanContext{SpanID: newSpanID()}
+	var parent SpanID
+	if remote.IsValid() {
+		sc.TraceID, sc.Flags, parent = remote.TraceID, remote.Flags, remote.SpanID
+	} else {
+		sc.TraceID, sc.Flags = newTraceID(), flagSampled
+	}
+	span := t.newSpan(name, SpanKindServer, sc, parent)
+	return ContextWithSpan(ctx, span), span
+}
+
+// Start starts a child of the span in ctx. Without a recording parent span
+// it returns ctx and a nil span, whose methods do nothing.
+func Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if !parent.recording() {
+		return ctx, nil
+	}
+	sc := SpanContext{TraceID: parent.data.SpanContext.TraceID, SpanID: newSpanID(), Flags: parent.data.SpanContext.Flags}
+	span := parent.tracer.newSpan(name, SpanKindInternal, sc, parent.data.SpanContext.SpanID)
+	span.data.Attributes = append(span.data.Attributes, attrs...)
+	return ContextWithSpan(ctx, span), span
+}
+
+func (t *Tracer) newSpan(name string, kind SpanKind, sc SpanContext, parent SpanID) *Span {
+	return &Span{
+		tracer: t,
+		data: SpanData{
+			Name:        name,
+			Kind:        kind,
+			SpanContext: sc,
+			Parent:      parent,
+			Start:       t.clock.Now(),
+		},
+	}
+}
+
+// Span is an operation within a trace. All methods are safe on a nil span.
+type Span struct {
+	tracer *Tracer
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+func (s *Span) recording() bool {
+	return s != nil && s.tracer.exporter != nil && s.data.SpanContext.Sampled()
+}
+
+// SpanContext returns the span's IDs, or the zero value for a nil span.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.SpanContext
+}
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Name = name
+}
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Attributes = append(s.data.Attributes, attrs...)
+}
+
+// RecordError marks the span as failed, if err is not nil.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Err = err.Error()
+}
+
+// End finishes the span and queues it for export. Later calls do nothing.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = s.tracer.clock.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	if s.recording() {
+		s.tracer.exporter.enqueue(data)
+	}
+}
+
+type spanKey struct{}
+type requestIDKey struct{}
+
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the current span, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the ID of the request ctx belongs to, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
@@ -0,0 +1,155 @@
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"simplesurance/internal/clock"
+)
+
+type recordingExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (e *recordingExporter) Export(_ context.Context, spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+func (e *recordingExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+func TestTracer_SpanTree(t *testing.T) {
+	exporter := &recordingExporter{}
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	tracer := NewTracer(exporter, WithClock(clk))
+
+	remote, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, server := tracer.StartServer(context.Background(), "GET /", remote)
+	childCtx, child := Start(ctx, "counter.record")
+	_, grandchild := Start(childCtx, "file.append", Attribute{Key: "file.path", Value: "timestamps.log"})
+	clk.Advance(time.Millisecond)
+	grandchild.RecordError(errors.New("disk full"))
+	grandchild.End()
+	child.End()
+	server.End()
+	server.End()
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	if len(exporter.spans) != 3 {
+		t.Fatalf("exported %d spans, want 3", len(exporter.spans))
+	}
+	byName := make(map[string]SpanData)
+	for _, span := range exporter.spans {
+		if span.SpanContext.TraceID != remote.TraceID {
+			t.Errorf("span %q has trace ID %s, want %s", span.Name, span.SpanContext.TraceID, remote.TraceID)
+		}
+		byName[span.Name] = span
+	}
+	parents := map[string]SpanID{
+		"GET /":          remote.SpanID,
+		"counter.record": byName["GET /"].SpanContext.SpanID,
+		"file.append":    byName["counter.record"].SpanContext.SpanID,
+	}
+	for name, want := range parents {
+		if got := byName[name].Parent; got != want {
+			t.Errorf("parent of %q = %s, want %s", name, got, want)
+		}
+	}
+	if got := byName["file.append"]; got.Err != "disk full" || got.End.Sub(got.Start) != time.Millisecond {
+		t.Errorf("file.append = %+v, want error and 1ms duration", got)
+	}
+}
+
+func TestTracer_NotRecording(t *testing.T) {
+	tests := []struct {
+		name     string
+		exporter Exporter
+		remote   SpanContext
+	}{
+		{name: "no exporter"},
+		{
+			name:     "caller did not sample",
+			exporter: &recordingExporter{},
+			remote:   SpanContext{TraceID: TraceID{1}, SpanID: SpanID{1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracer := NewTracer(tt.exporter)
+			ctx, server := tracer.StartServer(context.Background(), "GET /", tt.remote)
+			if !server.SpanContext().IsValid() {
+				t.Error("server span has no IDs to propagate")
+			}
+			if _, child := Start(ctx, "counter.record"); child != nil {
+				t.Error("Start() returned a span under a non-recording parent")
+			}
+			server.End()
+			if err := tracer.Shutdown(context.Background()); err != nil {
+				t.Fatalf("Shutdown() error = %v", err)
+			}
+			if e, ok := tt.exporter.(*recordingExporter); ok && len(e.spans) != 0 {
+				t.Errorf("exported %d spans, want none", len(e.spans))
+			}
+		})
+	}
+}
+
+func TestFileExporter(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "traces.jsonl")
+	exporter, err := NewFileExporter(fileName, "test-service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracer := NewTracer(exporter)
+	ctx, server := tracer.StartServer(context.Background(), "GET /", SpanContext{})
+	_, child := Start(ctx, "counter.record", Attribute{Key: "counter.count", Value: 3})
+	child.End()
+	server.End()
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+
+	file, err := os.Open(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		t.Fatal("trace file is empty")
+	}
+	var payload otlpRequest
+	if err := json.Unmarshal(scanner.Bytes(), &payload); err != nil {
+		t.Fatalf("invalid OTLP/JSON line: %v", err)
+	}
+	resource := payload.ResourceSpans[0]
+	if got := *resource.Resource.Attributes[0].Value.StringValue; got != "test-service" {
+		t.Errorf("service.name = %q, want %q", got, "test-service")
+	}
+	spans := resource.ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("exported %d spans, want 2", len(spans))
+	}
+	if spans[0].Name != "counter.record" || spans[0].ParentSpanID != spans[1].SpanID {
+		t.Errorf("spans = %+v, want counter.record under the server span", spans)
+	}
+	if got := *spans[0].Attributes[0].Value.IntValue; got != "3" {
+		t.Errorf("counter.count = %q, want %q", got, "3")
+	}
+}